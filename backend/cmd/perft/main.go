@@ -0,0 +1,40 @@
+package main
+
+import (
+	"burrchess/internal/chess"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Counts move generation leaf nodes, compare against https://www.chessprogramming.org/Perft_Results
+func main() {
+	fen := flag.String("fen", chess.StartingFEN, "Position to search from")
+	depth := flag.Int("depth", 4, "Search depth in plies")
+	divide := flag.Bool("divide", true, "Print the node count below each legal move")
+
+	flag.Parse()
+
+	if *depth < 1 {
+		fmt.Fprintln(os.Stderr, "depth must be at least 1")
+		os.Exit(2)
+	}
+
+	start := time.Now()
+
+	var nodes int64
+	if *divide {
+		for _, entry := range chess.PerftDivide(*fen, *depth) {
+			fmt.Printf("%s: %d\n", entry.UCI, entry.Nodes)
+			nodes += entry.Nodes
+		}
+		fmt.Println()
+	} else {
+		nodes = chess.Perft(*fen, *depth)
+	}
+
+	elapsed := time.Since(start)
+	fmt.Printf("Nodes searched: %d\n", nodes)
+	fmt.Printf("Took %s (%.0f nodes/s)\n", elapsed, float64(nodes)/elapsed.Seconds())
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Direct challenges from one logged in player to another
+// They are held in memory until the target accepts or declines, or they expire
+
+// Unanswered challenges are dropped after this long
+const challengeTimeout = 2 * time.Minute
+
+var (
+	errChallengeNotFound      = errors.New("challenge not found")
+	errChallengePlayerInMatch = errors.New("challenge player is in a match")
+)
+
+type challenge struct {
+	challengeID              int64
+	challengerID             int64
+	challengerUsername       string
+	targetID                 int64
+	targetUsername           string
+	timeFormatInMilliseconds int64
+	incrementInMilliseconds  int64
+	challengerColour         colourPreference
+	rated                    bool
+	created                  time.Time
+}
+
+func (c *challenge) isExpired(now time.Time) bool {
+	return now.Sub(c.created) > challengeTimeout
+}
+
+// Data of the "challenge" SSE event sent to the target
+type challengeEventData struct {
+	ChallengeID              int64            `json:"challengeID"`
+	Challenger               string           `json:"challenger"`
+	TimeFormatInMilliseconds int64            `json:"timeFormatInMilliseconds"`
+	IncrementInMilliseconds  int64            `json:"incrementInMilliseconds"`
+	Colour                   colourPreference `json:"colour"` // The challenger's colour
+	Rated                    bool             `json:"rated"`
+	ExpiresAtUnixMs          int64            `json:"expiresAtUnixMs"`
+}
+
+// Data of the "challengeDeclined" SSE event sent to the challenger
+type challengeDeclinedEventData struct {
+	ChallengeID int64  `json:"challengeID"`
+	Username    string `json:"username"`
+}
+
+type ChallengeStore struct {
+	mu         sync.Mutex
+	nextID     int64
+	challenges map[int64]*challenge
+}
+
+var challenges = ChallengeStore{
+	challenges: make(map[int64]*challenge),
+}
+
+// Stores the challenge and gives it an ID, expired challenges are swept first
+func (s *ChallengeStore) add(c *challenge) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for challengeID, existing := range s.challenges {
+		if existing.isExpired(now) {
+			delete(s.challenges, challengeID)
+		}
+	}
+
+	s.nextID += 1
+	c.challengeID = s.nextID
+	c.created = now
+	s.challenges[c.challengeID] = c
+	return c.challengeID
+}
+
+// Removes and returns the challenge if it was sent to targetID, has not expired and eligible accepts it,
+// eligible runs under the lock and a challenge it rejects stays open
+func (s *ChallengeStore) take(challengeID int64, targetID int64, eligible func(c *challenge) error) (*challenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.challenges[challengeID]
+	if !ok || c.targetID != targetID {
+		return nil, errChallengeNotFound
+	}
+	if c.isExpired(time.Now()) {
+		delete(s.challenges, challengeID)
+		return nil, errChallengeNotFound
+	}
+	if err := eligible(c); err != nil {
+		return nil, err
+	}
+
+	delete(s.challenges, challengeID)
+	return c, nil
+}
+
+func sendChallenge(c *challenge) error {
+	data, err := json.Marshal(challengeEventData{
+		ChallengeID:              c.challengeID,
+		Challenger:               c.challengerUsername,
+		TimeFormatInMilliseconds: c.timeFormatInMilliseconds,
+		IncrementInMilliseconds:  c.incrementInMilliseconds,
+		Colour:                   c.challengerColour,
+		Rated:                    c.rated,
+		ExpiresAtUnixMs:          c.created.Add(challengeTimeout).UnixMilli(),
+	})
+	if err != nil {
+		app.errorLog.Printf("Error marshalling challenge: %v\n", err)
+		return err
+	}
+
+	clients.send(c.targetID, sseMessage{event: "challenge", data: string(data)})
+	return nil
+}
+
+func acceptChallenge(c *challenge) error {
+	challengerData := directMatchPlayerData(c.challengerID, c.timeFormatInMilliseconds)
+	targetData := directMatchPlayerData(c.targetID, c.timeFormatInMilliseconds)
+
+	_, err := createMatch(challengerData, targetData, c.timeFormatInMilliseconds, c.incrementInMilliseconds, c.challengerColour, c.rated)
+	return err
+}
+
+func declineChallenge(c *challenge) error {
+	data, err := json.Marshal(challengeDeclinedEventData{
+		ChallengeID: c.challengeID,
+		Username:    c.targetUsername,
+	})
+	if err != nil {
+		app.errorLog.Printf("Error marshalling declined challenge: %v\n", err)
+		return err
+	}
+
+	clients.send(c.challengerID, sseMessage{event: "challengeDeclined", data: string(data)})
+	return nil
+}
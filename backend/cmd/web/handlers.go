@@ -3,11 +3,14 @@ package main
 import (
 	"burrchess/internal/chess"
 	"burrchess/internal/models"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
@@ -31,9 +34,12 @@ type getChessMoveDataJSON struct {
 }
 
 type joinQueueRequest struct {
-	TimeFormatInMilliseconds int64  `json:"timeFormatInMilliseconds"`
-	IncrementInMilliseconds  int64  `json:"incrementInMilliseconds"`
-	Action                   string `json:"action"`
+	TimeFormatInMilliseconds int64            `json:"timeFormatInMilliseconds"`
+	IncrementInMilliseconds  int64            `json:"incrementInMilliseconds"`
+	Action                   string           `json:"action"`
+	MaxRatingGap             int64            `json:"maxRatingGap"` // Optional, 0 for no limit
+	Colour                   colourPreference `json:"colour"`       // Optional, random if empty
+	Rated                    *bool            `json:"rated"`        // Optional, rated if missing for logged in players, casual for logged out ones
 }
 
 type getHighestEloMatchResponse struct {
@@ -57,6 +63,73 @@ type updatePasswordRequest struct {
 	NewPassword     string `json:"newPassword"`
 }
 
+type ratingHistoryPoint struct {
+	MatchID    int64 `json:"matchID"`
+	UnixMsTime int64 `json:"unixMsTime"`
+	Rating     int64 `json:"rating"`
+}
+
+type ratingHistoryResponse struct {
+	Username   string               `json:"username"`
+	TimeFormat string               `json:"timeFormat"`
+	History    []ratingHistoryPoint `json:"history"` // Rating after each rated game, oldest first
+	Peak       *ratingHistoryPoint  `json:"peak"`    // nil until the first rated game
+	Lowest     *ratingHistoryPoint  `json:"lowest"`
+}
+
+type createChallengeRequest struct {
+	Username                 string           `json:"username"`
+	TimeFormatInMilliseconds int64            `json:"timeFormatInMilliseconds"`
+	IncrementInMilliseconds  int64            `json:"incrementInMilliseconds"`
+	Colour                   colourPreference `json:"colour"` // Defaults to random
+	Rated                    bool             `json:"rated"`
+}
+
+type createChallengeResponse struct {
+	ChallengeID int64 `json:"challengeID"`
+}
+
+type createSeekRequest struct {
+	TimeFormatInMilliseconds int64            `json:"timeFormatInMilliseconds"`
+	IncrementInMilliseconds  int64            `json:"incrementInMilliseconds"`
+	MinRating                int64            `json:"minRating"` // 0 for no limit
+	MaxRating                int64            `json:"maxRating"` // 0 for no limit
+	Colour                   colourPreference `json:"colour"`    // Defaults to random
+	Rated                    bool             `json:"rated"`
+}
+
+type createSeekResponse struct {
+	SeekID int64 `json:"seekID"`
+}
+
+type createInviteRequest struct {
+	TimeFormatInMilliseconds int64            `json:"timeFormatInMilliseconds"`
+	IncrementInMilliseconds  int64            `json:"incrementInMilliseconds"`
+	Colour                   colourPreference `json:"colour"` // Defaults to random
+	Rated                    bool             `json:"rated"`
+}
+
+type createInviteResponse struct {
+	Token string `json:"token"`
+}
+
+type inviteResponse struct {
+	Creator                  string           `json:"creator"` // Empty for logged out players
+	TimeFormatInMilliseconds int64            `json:"timeFormatInMilliseconds"`
+	IncrementInMilliseconds  int64            `json:"incrementInMilliseconds"`
+	Colour                   colourPreference `json:"colour"` // The creator's colour
+	Rated                    bool             `json:"rated"`
+	ExpiresAtUnixMs          int64            `json:"expiresAtUnixMs"`
+}
+
+type acceptInviteResponse struct {
+	MatchID int64 `json:"matchID"`
+}
+
+type blockResponse struct {
+	Blocked bool `json:"blocked"`
+}
+
 func generateNewPlayerId() int64 {
 	return rand.Int63()
 }
@@ -122,6 +195,21 @@ func joinQueueHandler(w http.ResponseWriter, r *http.Request) {
 
 	app.infoLog.Printf("Received body: %+v\n", joinQueue)
 
+	if joinQueue.Colour == "" {
+		joinQueue.Colour = randomColour
+	}
+	if !joinQueue.Colour.isValid() {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	// Players who cannot play rated join the casual queue unless they asked for a rated one
+	var canPlayRated = app.canPlayRated(r)
+	var rated = canPlayRated && (joinQueue.Rated == nil || *joinQueue.Rated)
+	if !canPlayRated && joinQueue.Rated != nil && *joinQueue.Rated && joinQueue.Action == "join" {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
 	// Generate new playerID if it doesnt exist, this is for logged out players
 	if !app.sessionManager.Exists(r.Context(), "playerID") && joinQueue.Action == "join" {
 		var playerID = generateNewPlayerId()
@@ -148,7 +236,7 @@ func joinQueueHandler(w http.ResponseWriter, r *http.Request) {
 	app.infoLog.Printf("Player ID: %v\n", playerID)
 
 	if joinQueue.Action == "join" {
-		addPlayerToWaitingPool(playerID, joinQueue.TimeFormatInMilliseconds, joinQueue.IncrementInMilliseconds)
+		addPlayerToWaitingPool(playerID, joinQueue.TimeFormatInMilliseconds, joinQueue.IncrementInMilliseconds, rated, joinQueue.Colour, max(joinQueue.MaxRatingGap, 0))
 	} else {
 		// err = removePlayerFromQueue(playerIDasInt, joinQueue.Time, joinQueue.Increment)
 		removePlayerFromWaitingPool(playerID, joinQueue.TimeFormatInMilliseconds, joinQueue.IncrementInMilliseconds)
@@ -156,9 +244,50 @@ func joinQueueHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// Waiting players, median wait and rating spread of every matchmaking queue
+func queuesHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() { app.perfLog.Printf("queuesHandler took: %s\n", time.Since(start)) }()
+
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	jsonStr, err := json.Marshal(matchmaker.status())
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonStr)
+}
+
+type sseMessage struct {
+	event string // Empty for the default "message" event
+	data  string
+}
+
+// Messages held for a player with no open stream
+const maxPendingSSEMessages = 8
+
+// Buffer of each open stream, messages to a stream that falls this far behind are dropped
+const sseStreamBufferSize = 8
+
+// Heartbeats keep streams busy, a write that takes longer than sseWriteTimeout ends the stream
+// so a client that went away without closing the connection is noticed within one heartbeat
+const (
+	sseHeartbeatInterval = 15 * time.Second
+	sseWriteTimeout      = 10 * time.Second
+)
+
+// A player can have several streams open, every message goes to all of them
 type Client struct {
 	id      int64
-	channel chan string
+	streams map[chan sseMessage]bool
+	pending []sseMessage
 }
 
 type Clients struct {
@@ -170,6 +299,74 @@ var clients = Clients{
 	clients: make(map[int64]*Client),
 }
 
+func (c *Clients) getOrCreate(playerID int64) *Client {
+	client, ok := c.clients[playerID]
+	if !ok {
+		client = &Client{id: playerID, streams: make(map[chan sseMessage]bool)}
+		c.clients[playerID] = client
+	}
+	return client
+}
+
+// Sends to every open stream of the player, or holds the message until one opens
+func (c *Clients) send(playerID int64, message sseMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	client := c.getOrCreate(playerID)
+	if len(client.streams) == 0 {
+		client.pending = append(client.pending, message)
+		if len(client.pending) > maxPendingSSEMessages {
+			client.pending = client.pending[1:]
+		}
+		return
+	}
+
+	for stream := range client.streams {
+		select {
+		case stream <- message:
+		default:
+			app.errorLog.Printf("SSE stream for playerID %v is full, dropping %+v\n", playerID, message)
+		}
+	}
+}
+
+func (c *Clients) isListening(playerID int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	client, ok := c.clients[playerID]
+	return ok && len(client.streams) > 0
+}
+
+// Opens a stream that starts with any held messages
+func (c *Clients) openStream(playerID int64) chan sseMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	client := c.getOrCreate(playerID)
+	stream := make(chan sseMessage, max(sseStreamBufferSize, len(client.pending)))
+	for _, message := range client.pending {
+		stream <- message
+	}
+	client.pending = nil
+	client.streams[stream] = true
+	return stream
+}
+
+func (c *Clients) closeStream(playerID int64, stream chan sseMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	client, ok := c.clients[playerID]
+	if !ok {
+		return
+	}
+	delete(client.streams, stream)
+	if len(client.streams) == 0 && len(client.pending) == 0 {
+		delete(c.clients, playerID)
+	}
+}
+
 func matchFoundSSEHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Set appropriate headers for SSE
@@ -200,25 +397,29 @@ func matchFoundSSEHandler(w http.ResponseWriter, r *http.Request) {
 	var playerID = app.sessionManager.GetInt64(r.Context(), "playerID")
 	app.infoLog.Printf("playerID in session: %v", playerID)
 
-	// Do the channels get properly closed on a leave queue?
-	// Do we send the proper message on a leave queue?
-	clients.mu.Lock()
-	_, ok := clients.clients[playerID]
-	if !ok {
-		clients.clients[playerID] = &Client{id: playerID, channel: make(chan string)}
-	}
-	clientChannel := clients.clients[playerID].channel
-	clients.mu.Unlock()
+	clientChannel := clients.openStream(playerID)
+
+	// Queued players are only paired while listening, and dropped once they stop
+	matchmaker.signal()
 
 	defer func() {
-		clients.mu.Lock()
-		delete(clients.clients, playerID)
-		clients.mu.Unlock()
+		clients.closeStream(playerID, clientChannel)
 		app.infoLog.Printf("Closed SSE for playerID: %v\n", playerID)
+
+		// Nobody would be told a seek was accepted
+		if !clients.isListening(playerID) {
+			lobby.removePlayerSeeks(playerID)
+		}
+		matchmaker.signal()
 	}()
 
 	defer app.liveMatches.EnQueueLogAll()
 
+	streamSSE(w, r, clientChannel)
+}
+
+// Writes messages from stream in SSE format until the client disconnects
+func streamSSE(w http.ResponseWriter, r *http.Request, stream chan sseMessage) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		app.infoLog.Println("Streaming not supported")
@@ -226,20 +427,37 @@ func matchFoundSSEHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	heartbeat := time.NewTicker(15 * time.Second)
+	// Replaces the server's WriteTimeout, which would end the stream
+	controller := http.NewResponseController(w)
+	extendWriteDeadline := func() {
+		err := controller.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+		if err != nil {
+			app.errorLog.Printf("SSE: Could not set write deadline: %s\n", err)
+		}
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
 	defer heartbeat.Stop()
 
 	for {
 		select {
-		case message, ok := <-clientChannel:
+		case message, ok := <-stream:
 			if !ok {
 				app.infoLog.Printf("SSE: Client Channel Closed")
 				return
 			}
-			app.infoLog.Printf("Sending: data: %s\n\n", message)
+			app.infoLog.Printf("Sending: event: %s data: %s\n\n", message.event, message.data)
+			extendWriteDeadline()
 
 			// Send the message to the client in SSE format
-			_, err := fmt.Fprintf(w, "data: %s\n\n", message)
+			if message.event != "" {
+				_, err := fmt.Fprintf(w, "event: %s\n", message.event)
+				if err != nil {
+					app.infoLog.Printf("SSE: Client disconnected unexpectedly: %s\n", err)
+					return
+				}
+			}
+			_, err := fmt.Fprintf(w, "data: %s\n\n", message.data)
 			if err != nil {
 				app.infoLog.Printf("SSE: Client disconnected unexpectedly: %s\n", err)
 				return
@@ -247,6 +465,7 @@ func matchFoundSSEHandler(w http.ResponseWriter, r *http.Request) {
 			flusher.Flush()
 
 		case <-heartbeat.C:
+			extendWriteDeadline()
 			_, err := fmt.Fprintf(w, ": heartbeat\n\n")
 			if err != nil {
 				app.infoLog.Printf("SSE: Client disconnected during heartbeat: %s\n", err)
@@ -522,6 +741,20 @@ func getTileInfoHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	userRatings, err := app.userRatings.GetRatingFromPlayerID(tileInfo.PlayerID)
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	var now = time.Now()
+	tileInfo.Ratings.Provisional = models.ProvisionalRatings{
+		Bullet:    app.ratingSystem.IsProvisional(app.ratingSystem.Decay(userRatings.Bullet, now)),
+		Blitz:     app.ratingSystem.IsProvisional(app.ratingSystem.Decay(userRatings.Blitz, now)),
+		Rapid:     app.ratingSystem.IsProvisional(app.ratingSystem.Decay(userRatings.Rapid, now)),
+		Classical: app.ratingSystem.IsProvisional(app.ratingSystem.Decay(userRatings.Classical, now)),
+	}
+
 	jsonStr, err := json.Marshal(tileInfo)
 	if err != nil {
 		app.serverError(w, err, false)
@@ -689,3 +922,798 @@ func updatePasswordHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 }
+
+func getPastMatchPGNHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() { app.perfLog.Printf("getPastMatchPGNHandler took: %s\n", time.Since(start)) }()
+
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	matchID, err := strconv.ParseInt(r.PathValue("matchID"), 10, 64)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	match, err := app.pastMatches.GetFromMatchID(matchID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err, false)
+		}
+		return
+	}
+
+	pgn, err := buildPastMatchPGN(match)
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-chess-pgn")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"burrchess_%v.pgn\"", matchID))
+	w.Write([]byte(pgn))
+}
+
+// Chat is only public once the match is over, spectators could otherwise pass hints to players
+func getPastMatchChatHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() { app.perfLog.Printf("getPastMatchChatHandler took: %s\n", time.Since(start)) }()
+
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	matchID, err := strconv.ParseInt(r.PathValue("matchID"), 10, 64)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	_, err = app.pastMatches.GetFromMatchID(matchID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err, false)
+		}
+		return
+	}
+
+	chatMessages, err := app.chatMessages.GetFromMatchID(matchID)
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	jsonStr, err := json.Marshal(chatMessages)
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonStr)
+}
+
+func getRatingHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() { app.perfLog.Printf("getRatingHistoryHandler took: %s\n", time.Since(start)) }()
+
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	timeFormat := r.URL.Query().Get("timeFormat")
+	ratingType, ok := models.RatingTypeFromString(timeFormat)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userRatings, err := app.userRatings.GetRatingFromUsername(r.PathValue("username"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err, false)
+		}
+		return
+	}
+
+	history, err := app.userRatings.GetRatingHistoryFromPlayerID(userRatings.PlayerID, ratingType)
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	data := ratingHistoryResponse{
+		Username:   userRatings.Username,
+		TimeFormat: timeFormat,
+		History:    make([]ratingHistoryPoint, 0, len(history)),
+	}
+	for _, entry := range history {
+		data.History = append(data.History, ratingHistoryPoint{
+			MatchID:    entry.MatchID,
+			UnixMsTime: entry.UnixMsTime,
+			Rating:     int64(math.Round(entry.RatingAfter)),
+		})
+	}
+
+	// Earliest point wins ties
+	for i := range data.History {
+		point := &data.History[i]
+		if data.Peak == nil || point.Rating > data.Peak.Rating {
+			data.Peak = point
+		}
+		if data.Lowest == nil || point.Rating < data.Lowest.Rating {
+			data.Lowest = point
+		}
+	}
+
+	jsonStr, err := json.Marshal(data)
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonStr)
+}
+
+func createChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() { app.perfLog.Printf("createChallengeHandler took: %s\n", time.Since(start)) }()
+
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !app.sessionManager.Exists(r.Context(), "username") {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+	playerID := app.sessionManager.GetInt64(r.Context(), "playerID")
+	username := app.sessionManager.GetString(r.Context(), "username")
+
+	var challengeRequest createChallengeRequest
+	err := json.NewDecoder(r.Body).Decode(&challengeRequest)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if challengeRequest.Colour == "" {
+		challengeRequest.Colour = randomColour
+	}
+
+	if !challengeRequest.Colour.isValid() || challengeRequest.TimeFormatInMilliseconds <= 0 || challengeRequest.IncrementInMilliseconds < 0 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	target, err := app.users.GetUserFromUsername(challengeRequest.Username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err, false)
+		}
+		return
+	}
+
+	if target.PlayerID == playerID {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	isBlocked, err := app.userBlocks.IsBlockedEitherWay(playerID, target.PlayerID)
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+	if isBlocked {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	// The target can only see the challenge while listening
+	if !clients.isListening(target.PlayerID) {
+		app.clientError(w, http.StatusConflict)
+		return
+	}
+
+	for _, id := range []int64{playerID, target.PlayerID} {
+		isInMatch, err := app.liveMatches.IsPlayerInMatch(id)
+		if err != nil {
+			app.serverError(w, err, false)
+			return
+		}
+		if isInMatch {
+			app.clientError(w, http.StatusConflict)
+			return
+		}
+	}
+
+	newChallenge := &challenge{
+		challengerID:             playerID,
+		challengerUsername:       username,
+		targetID:                 target.PlayerID,
+		targetUsername:           target.Username,
+		timeFormatInMilliseconds: challengeRequest.TimeFormatInMilliseconds,
+		incrementInMilliseconds:  challengeRequest.IncrementInMilliseconds,
+		challengerColour:         challengeRequest.Colour,
+		rated:                    challengeRequest.Rated,
+	}
+	challengeID := challenges.add(newChallenge)
+
+	err = sendChallenge(newChallenge)
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	jsonStr, err := json.Marshal(createChallengeResponse{ChallengeID: challengeID})
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(jsonStr)
+}
+
+// Accept or decline, only the target of the challenge can answer it
+func answerChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() { app.perfLog.Printf("answerChallengeHandler took: %s\n", time.Since(start)) }()
+
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !app.sessionManager.Exists(r.Context(), "username") {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+	playerID := app.sessionManager.GetInt64(r.Context(), "playerID")
+
+	challengeID, err := strconv.ParseInt(r.PathValue("challengeID"), 10, 64)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	action := r.PathValue("action")
+	if action != "accept" && action != "decline" {
+		app.notFound(w)
+		return
+	}
+
+	answered, err := challenges.take(challengeID, playerID, func(c *challenge) error {
+		if action == "decline" {
+			return nil
+		}
+		// The challenge stays open, so it can still be accepted once the game in progress ends
+		for _, id := range []int64{c.challengerID, c.targetID} {
+			isInMatch, err := app.liveMatches.IsPlayerInMatch(id)
+			if err != nil {
+				return err
+			}
+			if isInMatch {
+				return errChallengePlayerInMatch
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, errChallengeNotFound) {
+			app.notFound(w)
+		} else if errors.Is(err, errChallengePlayerInMatch) {
+			app.clientError(w, http.StatusConflict)
+		} else {
+			app.serverError(w, err, false)
+		}
+		return
+	}
+
+	if action == "decline" {
+		err = declineChallenge(answered)
+		if err != nil {
+			app.serverError(w, err, false)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Both players are sent the match over SSE
+	err = acceptChallenge(answered)
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET lists the open seeks, POST opens a seek for the player
+func lobbyHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() { app.perfLog.Printf("lobbyHandler took: %s\n", time.Since(start)) }()
+
+	if r.Method == "GET" {
+		jsonStr, err := json.Marshal(lobby.list())
+		if err != nil {
+			app.serverError(w, err, false)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonStr)
+		return
+	}
+
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var seekRequest createSeekRequest
+	err := json.NewDecoder(r.Body).Decode(&seekRequest)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if seekRequest.Colour == "" {
+		seekRequest.Colour = randomColour
+	}
+
+	if !seekRequest.Colour.isValid() || seekRequest.TimeFormatInMilliseconds <= 0 || seekRequest.IncrementInMilliseconds < 0 ||
+		seekRequest.MinRating < 0 || seekRequest.MaxRating < 0 || (seekRequest.MaxRating != 0 && seekRequest.MaxRating < seekRequest.MinRating) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if seekRequest.Rated && !app.canPlayRated(r) {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	if !app.sessionManager.Exists(r.Context(), "playerID") {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+	playerID := app.sessionManager.GetInt64(r.Context(), "playerID")
+
+	// The seeker is told the seek was accepted over SSE
+	if !clients.isListening(playerID) {
+		app.clientError(w, http.StatusConflict)
+		return
+	}
+
+	isInMatch, err := app.liveMatches.IsPlayerInMatch(playerID)
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+	if isInMatch {
+		app.clientError(w, http.StatusConflict)
+		return
+	}
+
+	var playerRating = getPlayerRating(playerID, seekRequest.TimeFormatInMilliseconds)
+	seekID := lobby.add(&seek{
+		PlayerID:                 playerID,
+		Username:                 app.sessionManager.GetString(r.Context(), "username"),
+		Rating:                   playerRating.RoundedRating(),
+		Provisional:              app.ratingSystem.IsProvisional(playerRating),
+		Deviation:                playerRating.Deviation,
+		TimeFormatInMilliseconds: seekRequest.TimeFormatInMilliseconds,
+		IncrementInMilliseconds:  seekRequest.IncrementInMilliseconds,
+		MinRating:                seekRequest.MinRating,
+		MaxRating:                seekRequest.MaxRating,
+		Colour:                   seekRequest.Colour,
+		Rated:                    seekRequest.Rated,
+	})
+
+	jsonStr, err := json.Marshal(createSeekResponse{SeekID: seekID})
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(jsonStr)
+}
+
+func cancelSeekHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() { app.perfLog.Printf("cancelSeekHandler took: %s\n", time.Since(start)) }()
+
+	if r.Method != "DELETE" {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	seekID, err := strconv.ParseInt(r.PathValue("seekID"), 10, 64)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = lobby.cancel(seekID, app.sessionManager.GetInt64(r.Context(), "playerID"))
+	if err != nil {
+		app.notFound(w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func acceptSeekHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() { app.perfLog.Printf("acceptSeekHandler took: %s\n", time.Since(start)) }()
+
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	seekID, err := strconv.ParseInt(r.PathValue("seekID"), 10, 64)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.sessionManager.Exists(r.Context(), "playerID") {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+	playerID := app.sessionManager.GetInt64(r.Context(), "playerID")
+	canPlayRated := app.canPlayRated(r)
+
+	isInMatch, err := app.liveMatches.IsPlayerInMatch(playerID)
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+	if isInMatch {
+		app.clientError(w, http.StatusConflict)
+		return
+	}
+
+	acceptedSeek, err := lobby.take(seekID, playerID, func(s *seek) error {
+		if s.Rated && !canPlayRated {
+			return errSeekNeedsLogin
+		}
+		if !s.acceptsRating(getPlayerRating(playerID, s.TimeFormatInMilliseconds).RoundedRating()) {
+			return errSeekNotInRange
+		}
+		isBlocked, err := app.userBlocks.IsBlockedEitherWay(s.PlayerID, playerID)
+		if err != nil {
+			return err
+		}
+		if isBlocked {
+			return errSeekBlocked
+		}
+		return nil
+	})
+	if err != nil {
+		// Blocked players are not told about the block
+		if errors.Is(err, errSeekNotFound) || errors.Is(err, errSeekBlocked) {
+			app.notFound(w)
+		} else if errors.Is(err, errSeekNeedsLogin) {
+			app.clientError(w, http.StatusUnauthorized)
+		} else if errors.Is(err, errSeekNotInRange) || errors.Is(err, errSeekOwnSeek) {
+			app.clientError(w, http.StatusForbidden)
+		} else {
+			app.serverError(w, err, false)
+		}
+		return
+	}
+
+	// The seeker may have found a game elsewhere since
+	isInMatch, err = app.liveMatches.IsPlayerInMatch(acceptedSeek.PlayerID)
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+	if isInMatch {
+		app.clientError(w, http.StatusConflict)
+		return
+	}
+
+	// Both players are sent the match over SSE
+	err = acceptSeek(acceptedSeek, playerID)
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func lobbyFeedSSEHandler(w http.ResponseWriter, r *http.Request) {
+	feed := lobby.openFeed()
+	defer lobby.closeFeed(feed)
+
+	streamSSE(w, r, feed)
+}
+
+func createInviteHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() { app.perfLog.Printf("createInviteHandler took: %s\n", time.Since(start)) }()
+
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var inviteRequest createInviteRequest
+	err := json.NewDecoder(r.Body).Decode(&inviteRequest)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if inviteRequest.Colour == "" {
+		inviteRequest.Colour = randomColour
+	}
+
+	if !inviteRequest.Colour.isValid() || inviteRequest.TimeFormatInMilliseconds <= 0 || inviteRequest.IncrementInMilliseconds < 0 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if inviteRequest.Rated && !app.canPlayRated(r) {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	// Generate new playerID if it doesnt exist, this is for logged out players
+	if !app.sessionManager.Exists(r.Context(), "playerID") {
+		app.sessionManager.Put(r.Context(), "playerID", generateNewPlayerId())
+	}
+	playerID := app.sessionManager.GetInt64(r.Context(), "playerID")
+
+	isInMatch, err := app.liveMatches.IsPlayerInMatch(playerID)
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+	if isInMatch {
+		app.clientError(w, http.StatusConflict)
+		return
+	}
+
+	token, err := invites.add(&invite{
+		creatorID:                playerID,
+		creatorUsername:          app.sessionManager.GetString(r.Context(), "username"),
+		timeFormatInMilliseconds: inviteRequest.TimeFormatInMilliseconds,
+		incrementInMilliseconds:  inviteRequest.IncrementInMilliseconds,
+		creatorColour:            inviteRequest.Colour,
+		rated:                    inviteRequest.Rated,
+	})
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	jsonStr, err := json.Marshal(createInviteResponse{Token: token})
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(jsonStr)
+}
+
+// GET describes the invite, DELETE lets its creator cancel it
+func inviteHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() { app.perfLog.Printf("inviteHandler took: %s\n", time.Since(start)) }()
+
+	token := r.PathValue("token")
+
+	if r.Method == "DELETE" {
+		playerID := app.sessionManager.GetInt64(r.Context(), "playerID")
+		_, err := invites.take(token, func(i *invite) error {
+			if i.creatorID != playerID {
+				return errInviteNotFound
+			}
+			return nil
+		})
+		if err != nil {
+			app.notFound(w)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	openInvite, err := invites.get(token)
+	if err != nil {
+		app.notFound(w)
+		return
+	}
+
+	jsonStr, err := json.Marshal(inviteResponse{
+		Creator:                  openInvite.creatorUsername,
+		TimeFormatInMilliseconds: openInvite.timeFormatInMilliseconds,
+		IncrementInMilliseconds:  openInvite.incrementInMilliseconds,
+		Colour:                   openInvite.creatorColour,
+		Rated:                    openInvite.rated,
+		ExpiresAtUnixMs:          openInvite.created.Add(inviteTimeout).UnixMilli(),
+	})
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonStr)
+}
+
+// Takes the open seat, the invite cannot be used again
+func acceptInviteHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() { app.perfLog.Printf("acceptInviteHandler took: %s\n", time.Since(start)) }()
+
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Generate new playerID if it doesnt exist, this is for logged out players
+	if !app.sessionManager.Exists(r.Context(), "playerID") {
+		app.sessionManager.Put(r.Context(), "playerID", generateNewPlayerId())
+	}
+	playerID := app.sessionManager.GetInt64(r.Context(), "playerID")
+	canPlayRated := app.canPlayRated(r)
+
+	isInMatch, err := app.liveMatches.IsPlayerInMatch(playerID)
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+	if isInMatch {
+		app.clientError(w, http.StatusConflict)
+		return
+	}
+
+	acceptedInvite, err := invites.take(r.PathValue("token"), func(i *invite) error {
+		if i.creatorID == playerID {
+			return errInviteOwnInvite
+		}
+		if i.rated && !canPlayRated {
+			return errInviteNeedsLogin
+		}
+		// A creator without an open stream gets the match found event once they listen again,
+		// and the abort timer ends the game if they never come back
+		creatorInMatch, err := app.liveMatches.IsPlayerInMatch(i.creatorID)
+		if err != nil {
+			return err
+		}
+		if creatorInMatch {
+			return errInviteCreatorInMatch
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, errInviteNotFound) {
+			app.notFound(w)
+		} else if errors.Is(err, errInviteNeedsLogin) {
+			app.clientError(w, http.StatusUnauthorized)
+		} else if errors.Is(err, errInviteOwnInvite) || errors.Is(err, errInviteCreatorInMatch) {
+			app.clientError(w, http.StatusConflict)
+		} else {
+			app.serverError(w, err, false)
+		}
+		return
+	}
+
+	matchID, err := acceptInvite(acceptedInvite, playerID)
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	jsonStr, err := json.Marshal(acceptInviteResponse{MatchID: matchID})
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonStr)
+}
+
+// GET whether the logged in player blocked username, POST blocks them and DELETE unblocks them
+func blockUserHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() { app.perfLog.Printf("blockUserHandler took: %s\n", time.Since(start)) }()
+
+	if r.Method != "GET" && r.Method != "POST" && r.Method != "DELETE" {
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !app.sessionManager.Exists(r.Context(), "username") {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+	playerID := app.sessionManager.GetInt64(r.Context(), "playerID")
+
+	target, err := app.users.GetUserFromUsername(r.PathValue("username"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err, false)
+		}
+		return
+	}
+
+	if target.PlayerID == playerID {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		err = app.userBlocks.Block(playerID, target.PlayerID)
+	case "DELETE":
+		err = app.userBlocks.Unblock(playerID, target.PlayerID)
+	}
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	isBlocked, err := app.userBlocks.HasBlocked(playerID, target.PlayerID)
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	jsonStr, err := json.Marshal(blockResponse{Blocked: isBlocked})
+	if err != nil {
+		app.serverError(w, err, false)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonStr)
+}
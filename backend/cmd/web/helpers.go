@@ -27,6 +27,11 @@ func (app *application) clientError(w http.ResponseWriter, status int) {
 	http.Error(w, http.StatusText(status), status)
 }
 
+// Logged out players have no rating to change, so only logged in players can play rated games
+func (app *application) canPlayRated(r *http.Request) bool {
+	return app.sessionManager.Exists(r.Context(), "username")
+}
+
 func (app *application) notFound(w http.ResponseWriter) {
 	app.clientError(w, http.StatusNotFound)
 }
@@ -66,23 +71,17 @@ func Read(r *http.Request, name string) (string, error) {
 	return string(value), nil
 }
 
-func WriteSigned(w http.ResponseWriter, cookie http.Cookie, secretKey []byte) error {
+// The signature covers name as well, so a value signed for one use cannot be replayed as another
+func sign(secretKey []byte, name string, value string) string {
 	mac := hmac.New(sha256.New, secretKey)
-	mac.Write([]byte(cookie.Name))
-	mac.Write([]byte(cookie.Value))
+	mac.Write([]byte(name))
+	mac.Write([]byte(value))
 	signature := mac.Sum(nil)
 
-	cookie.Value = string(signature) + cookie.Value
-
-	return Write(w, cookie)
+	return string(signature) + value
 }
 
-func ReadSigned(r *http.Request, secretKey []byte, name string) (string, error) {
-	signedValue, err := Read(r, name)
-	if err != nil {
-		return "", err
-	}
-
+func verifySigned(secretKey []byte, name string, signedValue string) (string, error) {
 	if len(signedValue) < sha256.Size {
 		return "", ErrInvalidValue
 	}
@@ -101,3 +100,33 @@ func ReadSigned(r *http.Request, secretKey []byte, name string) (string, error)
 
 	return value, nil
 }
+
+func WriteSigned(w http.ResponseWriter, cookie http.Cookie, secretKey []byte) error {
+	cookie.Value = sign(secretKey, cookie.Name, cookie.Value)
+
+	return Write(w, cookie)
+}
+
+func ReadSigned(r *http.Request, secretKey []byte, name string) (string, error) {
+	signedValue, err := Read(r, name)
+	if err != nil {
+		return "", err
+	}
+
+	return verifySigned(secretKey, name, signedValue)
+}
+
+// Tokens that travel in URLs, signed the same way as cookies
+
+func WriteSignedToken(secretKey []byte, name string, value string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(sign(secretKey, name, value)))
+}
+
+func ReadSignedToken(secretKey []byte, name string, token string) (string, error) {
+	signedValue, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", ErrInvalidValue
+	}
+
+	return verifySigned(secretKey, name, string(signedValue))
+}
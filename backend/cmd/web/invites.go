@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Private games shared as a link, the first player to open the link takes the open seat
+// The link carries a signed token, each token can start one game
+// Invites are only kept in memory, a restart drops them and their links stop working
+
+// Name the invite tokens are signed under
+const inviteTokenName = "invite"
+
+// Unused invites are dropped after this long
+const inviteTimeout = time.Hour
+
+var (
+	errInviteNotFound       = errors.New("invite not found")
+	errInviteOwnInvite      = errors.New("cannot accept own invite")
+	errInviteNeedsLogin     = errors.New("rated invites need a logged in player")
+	errInviteCreatorInMatch = errors.New("invite creator is in a match")
+)
+
+type invite struct {
+	inviteID                 int64
+	nonce                    string // Random, so tokens cannot be guessed from the inviteID
+	creatorID                int64
+	creatorUsername          string // Empty for logged out players
+	timeFormatInMilliseconds int64
+	incrementInMilliseconds  int64
+	creatorColour            colourPreference
+	rated                    bool
+	created                  time.Time
+}
+
+func (i *invite) isExpired(now time.Time) bool {
+	return now.Sub(i.created) > inviteTimeout
+}
+
+func (i *invite) token() string {
+	return WriteSignedToken(app.secretKey, inviteTokenName, fmt.Sprintf("%v.%v", i.inviteID, i.nonce))
+}
+
+type InviteStore struct {
+	mu      sync.Mutex
+	nextID  int64
+	invites map[int64]*invite
+}
+
+var invites = InviteStore{
+	invites: make(map[int64]*invite),
+}
+
+// Stores the invite and returns its token, expired invites are swept first
+func (s *InviteStore) add(i *invite) (string, error) {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		app.errorLog.Printf("Error generating invite nonce: %v\n", err)
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for inviteID, existing := range s.invites {
+		if existing.isExpired(now) {
+			delete(s.invites, inviteID)
+		}
+	}
+
+	s.nextID += 1
+	i.inviteID = s.nextID
+	i.nonce = hex.EncodeToString(nonce)
+	i.created = now
+	s.invites[i.inviteID] = i
+	return i.token(), nil
+}
+
+// Caller must hold mu
+func (s *InviteStore) lookup(token string) (*invite, error) {
+	value, err := ReadSignedToken(app.secretKey, inviteTokenName, token)
+	if err != nil {
+		return nil, errInviteNotFound
+	}
+
+	var inviteID int64
+	var nonce string
+	_, err = fmt.Sscanf(value, "%d.%s", &inviteID, &nonce)
+	if err != nil {
+		return nil, errInviteNotFound
+	}
+
+	existing, ok := s.invites[inviteID]
+	if !ok || existing.nonce != nonce || existing.isExpired(time.Now()) {
+		return nil, errInviteNotFound
+	}
+	return existing, nil
+}
+
+// Returns a copy, the invite stays open
+func (s *InviteStore) get(token string) (invite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.lookup(token)
+	if err != nil {
+		return invite{}, err
+	}
+	return *existing, nil
+}
+
+// Removes and returns the invite if eligible accepts it, eligible runs under the lock
+func (s *InviteStore) take(token string, eligible func(i *invite) error) (*invite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.lookup(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := eligible(existing); err != nil {
+		return nil, err
+	}
+
+	delete(s.invites, existing.inviteID)
+	return existing, nil
+}
+
+// Creates the match between the creator and playerID, returns the new matchID
+func acceptInvite(acceptedInvite *invite, playerID int64) (int64, error) {
+	creatorData := directMatchPlayerData(acceptedInvite.creatorID, acceptedInvite.timeFormatInMilliseconds)
+	joinerData := directMatchPlayerData(playerID, acceptedInvite.timeFormatInMilliseconds)
+
+	return createMatch(creatorData, joinerData, acceptedInvite.timeFormatInMilliseconds, acceptedInvite.incrementInMilliseconds, acceptedInvite.creatorColour, acceptedInvite.rated)
+}
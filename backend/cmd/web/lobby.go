@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Open seeks that anyone eligible can accept, unlike the hidden pool in matchPlayers
+// Each player has at most one seek, it is dropped when they stop listening for matches
+
+var (
+	errSeekNotFound   = errors.New("seek not found")
+	errSeekOwnSeek    = errors.New("cannot accept own seek")
+	errSeekNotInRange = errors.New("rating outside of seek range")
+	errSeekNeedsLogin = errors.New("rated seeks need a logged in player")
+	errSeekBlocked    = errors.New("seeker and acceptor are blocked")
+)
+
+type seek struct {
+	SeekID                   int64            `json:"seekID"`
+	PlayerID                 int64            `json:"-"`
+	Username                 string           `json:"username"` // Empty for logged out players
+	Rating                   int64            `json:"rating"`
+	Provisional              bool             `json:"provisional"`
+	Deviation                float64          `json:"-"`
+	TimeFormatInMilliseconds int64            `json:"timeFormatInMilliseconds"`
+	IncrementInMilliseconds  int64            `json:"incrementInMilliseconds"`
+	MinRating                int64            `json:"minRating"` // 0 for no limit
+	MaxRating                int64            `json:"maxRating"` // 0 for no limit
+	Colour                   colourPreference `json:"colour"`    // The seeker's colour
+	Rated                    bool             `json:"rated"`
+	UnixMsTimeCreated        int64            `json:"unixMsTimeCreated"`
+}
+
+func (s *seek) acceptsRating(rating int64) bool {
+	return (s.MinRating == 0 || rating >= s.MinRating) && (s.MaxRating == 0 || rating <= s.MaxRating)
+}
+
+// Data of the "seekRemoved" lobby feed event
+type seekRemovedEventData struct {
+	SeekID int64 `json:"seekID"`
+}
+
+type SeekLobby struct {
+	mu        sync.Mutex
+	nextID    int64
+	seeks     map[int64]*seek
+	listeners map[chan sseMessage]bool
+}
+
+var lobby = SeekLobby{
+	seeks:     make(map[int64]*seek),
+	listeners: make(map[chan sseMessage]bool),
+}
+
+// Caller must hold mu
+func (l *SeekLobby) broadcast(event string, data any) {
+	jsonStr, err := json.Marshal(data)
+	if err != nil {
+		app.errorLog.Printf("Error marshalling lobby %s event: %v\n", event, err)
+		return
+	}
+
+	var message = sseMessage{event: event, data: string(jsonStr)}
+	for listener := range l.listeners {
+		select {
+		case listener <- message:
+		default:
+			app.errorLog.Printf("Lobby feed listener is full, dropping %+v\n", message)
+		}
+	}
+}
+
+// Caller must hold mu
+func (l *SeekLobby) remove(seekID int64) {
+	delete(l.seeks, seekID)
+	l.broadcast("seekRemoved", seekRemovedEventData{SeekID: seekID})
+}
+
+// Adds the seek, replacing any open seek of the same player
+func (l *SeekLobby) add(newSeek *seek) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for seekID, existing := range l.seeks {
+		if existing.PlayerID == newSeek.PlayerID {
+			l.remove(seekID)
+		}
+	}
+
+	l.nextID += 1
+	newSeek.SeekID = l.nextID
+	newSeek.UnixMsTimeCreated = time.Now().UnixMilli()
+	l.seeks[newSeek.SeekID] = newSeek
+	l.broadcast("seekAdded", newSeek)
+	return newSeek.SeekID
+}
+
+// Removes the seek if playerID owns it
+func (l *SeekLobby) cancel(seekID int64, playerID int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	existing, ok := l.seeks[seekID]
+	if !ok || existing.PlayerID != playerID {
+		return errSeekNotFound
+	}
+	l.remove(seekID)
+	return nil
+}
+
+func (l *SeekLobby) removePlayerSeeks(playerID int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for seekID, existing := range l.seeks {
+		if existing.PlayerID == playerID {
+			l.remove(seekID)
+		}
+	}
+}
+
+// Removes and returns the seek if eligible accepts it, eligible runs under the lock
+func (l *SeekLobby) take(seekID int64, playerID int64, eligible func(s *seek) error) (*seek, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	existing, ok := l.seeks[seekID]
+	if !ok {
+		return nil, errSeekNotFound
+	}
+	if existing.PlayerID == playerID {
+		return nil, errSeekOwnSeek
+	}
+	if err := eligible(existing); err != nil {
+		return nil, err
+	}
+
+	l.remove(seekID)
+	return existing, nil
+}
+
+// Oldest first
+func (l *SeekLobby) list() []seek {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	output := make([]seek, 0, len(l.seeks))
+	for _, existing := range l.seeks {
+		output = append(output, *existing)
+	}
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].SeekID < output[j].SeekID
+	})
+	return output
+}
+
+func (l *SeekLobby) openFeed() chan sseMessage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	listener := make(chan sseMessage, sseStreamBufferSize)
+	l.listeners[listener] = true
+	return listener
+}
+
+func (l *SeekLobby) closeFeed(listener chan sseMessage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.listeners, listener)
+}
+
+func acceptSeek(acceptedSeek *seek, playerID int64) error {
+	seekerData := &playerMatchmakingData{
+		playerID:  acceptedSeek.PlayerID,
+		elo:       acceptedSeek.Rating,
+		deviation: acceptedSeek.Deviation,
+	}
+	acceptorData := directMatchPlayerData(playerID, acceptedSeek.TimeFormatInMilliseconds)
+
+	_, err := createMatch(seekerData, acceptorData, acceptedSeek.TimeFormatInMilliseconds, acceptedSeek.IncrementInMilliseconds, acceptedSeek.Colour, acceptedSeek.Rated)
+	return err
+}
@@ -4,6 +4,7 @@ package main
 
 import (
 	"burrchess/internal/models"
+	"burrchess/internal/rating"
 	"crypto/tls"
 	"database/sql"
 	"flag"
@@ -28,8 +29,18 @@ type application struct {
 	pastMatches    *models.PastMatchModel
 	users          *models.UserModel
 	userRatings    *models.UserRatingsModel
+	chatMessages   *models.ChatMessageModel
+	userBlocks     *models.UserBlockModel
 	dbTaskQueue    *models.TaskQueue
 	sessionManager *scs.SessionManager
+	abortTimeout   time.Duration
+	ratingSystem   rating.System
+	// Widening of queues without an entry in queueWidening
+	matchmakingWidening thresholdWidening
+	queueWidening       map[string]thresholdWidening
+	rematchCooldown     time.Duration
+	rematchPenalty      int64
+	balanceColours      bool
 }
 
 var app *application
@@ -38,6 +49,18 @@ func main() {
 	addr := flag.String("addr", ":8080", "HTTPS network address")
 	dbDriverName := flag.String("db", "sqlite", "Database Driver Name")
 	dbDataSourceName := flag.String("dsn", "file:chess_site.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", "Database Data Source Name")
+	abortTimeout := flag.Duration("abortTimeout", 30*time.Second, "Time the player to move has to make their first move before the game is aborted")
+	ratingSystemName := flag.String("ratingSystem", "glicko2", "Rating system for rated games, glicko2 or elo")
+	widenStep := flag.Int64("widenStep", 50, "Rating added to a waiting player's matchmaking threshold every widenInterval")
+	widenInterval := flag.Duration("widenInterval", 5*time.Second, "Time between matchmaking threshold widenings")
+	widenMax := flag.Int64("widenMax", 1000, "Matchmaking threshold at which widening stops")
+	rematchCooldown := flag.Duration("rematchCooldown", 10*time.Minute, "Time after a match during which matchmaking avoids pairing the same two players again")
+	rematchPenalty := flag.Int64("rematchPenalty", 300, "Rating gap added to the matchmaking score of two players who played within rematchCooldown")
+	balanceColours := flag.Bool("balanceColours", true, "Give white to the player who had it less often in their recent matches instead of picking at random")
+	queueWidening := make(map[string]thresholdWidening)
+	flag.Func("queueWidening", "Widening of single queues, e.g. \"60000+0=100/3s/1500,600000+5000=25/10s/600\" as time+increment in milliseconds=step/interval/max", func(spec string) error {
+		return parseQueueWidening(spec, queueWidening)
+	})
 
 	flag.Parse()
 
@@ -46,6 +69,11 @@ func main() {
 	perfLog := log.New(os.Stdout, "PERF\t", log.Lshortfile)
 	debugLog := log.New(os.Stdout, "DEBUG\t", log.Lshortfile)
 
+	ratingSystem, err := rating.NewSystem(*ratingSystemName)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
 	models.InitDatabase(*dbDriverName, *dbDataSourceName)
 	db, err := sql.Open(*dbDriverName, *dbDataSourceName)
 	if err != nil {
@@ -100,8 +128,21 @@ func main() {
 		pastMatches:    &models.PastMatchModel{DB: db},
 		users:          &models.UserModel{DB: db},
 		userRatings:    &models.UserRatingsModel{DB: db},
+		chatMessages:   &models.ChatMessageModel{DB: db},
+		userBlocks:     &models.UserBlockModel{DB: db},
 		dbTaskQueue:    models.DBTaskQueue,
 		sessionManager: sessionManager,
+		abortTimeout:   *abortTimeout,
+		ratingSystem:   ratingSystem,
+		matchmakingWidening: thresholdWidening{
+			step:     *widenStep,
+			interval: *widenInterval,
+			max:      *widenMax,
+		},
+		queueWidening:   queueWidening,
+		rematchCooldown: *rematchCooldown,
+		rematchPenalty:  *rematchPenalty,
+		balanceColours:  *balanceColours,
 	}
 
 	go func() {
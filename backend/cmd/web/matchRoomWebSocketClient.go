@@ -56,6 +56,14 @@ type MatchRoomHubClient struct {
 	// White, black or spectator
 	playerIdentifier messageIdentifier
 
+	playerID int64
+
+	// Empty for anonymous players
+	username string
+
+	// Times of this client's recent chat messages, for rate limiting
+	recentChatMessages []time.Time
+
 	// Buffered channel of outbound messages
 	send chan []byte
 }
@@ -118,7 +126,7 @@ func (c *MatchRoomHubClient) readPump() {
 		sender := []byte{byte(c.playerIdentifier)}
 		message = append(sender, message...)
 		message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
-		c.hub.broadcast <- message
+		c.hub.broadcast <- &clientMessage{client: c, message: message}
 	}
 }
 
@@ -186,6 +194,7 @@ func serveMatchroomWs(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var playerID = app.sessionManager.GetInt64(r.Context(), "playerID")
+	var username = app.sessionManager.GetString(r.Context(), "username")
 
 	var conn *websocket.Conn
 
@@ -197,7 +206,7 @@ func serveMatchroomWs(w http.ResponseWriter, r *http.Request) {
 
 	var client *MatchRoomHubClient
 
-	client, err = matchRoomHubManager.registerClientToMatchRoomHub(conn, matchID, &playerID)
+	client, err = matchRoomHubManager.registerClientToMatchRoomHub(conn, matchID, &playerID, username)
 	if err != nil {
 		app.websocketError(conn, err)
 		return
@@ -3,14 +3,16 @@ package main
 import (
 	"burrchess/internal/chess"
 	"burrchess/internal/models"
+	"burrchess/internal/rating"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 // WEBSOCKET TO CLIENT TYPES
@@ -24,6 +26,7 @@ const (
 	opponentEvent    = "opponentEvent"
 	userMessage      = "userMessage"
 	sendPlayerCode   = "sendPlayerCode"
+	rematchCreated   = "rematchCreated"
 )
 
 type eventType string
@@ -38,25 +41,36 @@ const (
 	disconnect          = "disconnect"
 	decline             = "decline"
 	threefoldRepetition = "threefoldRepetition"
+	fiftyMoveRule       = "fiftyMoveRule"
 )
 
 // Bodies
 
 type onConnectBody struct {
-	MatchStateHistory        []MatchStateHistory      `json:"matchStateHistory"`
-	GameOverStatusCode       chess.GameOverStatusCode `json:"gameOverStatus"`
-	ThreefoldRepetition      bool                     `json:"threefoldRepetition"`
-	WhitePlayerConnected     bool                     `json:"whitePlayerConnected"`
-	BlackPlayerConnected     bool                     `json:"blackPlayerConnected"`
-	MillisecondsUntilTimeout int64                    `json:"millisecondsUntilTimeout"`
-	WhitePlayerUsername      sql.NullString           `json:"whitePlayerUsername"`
-	BlackPlayerUsername      sql.NullString           `json:"blackPlayerUsername"`
+	MatchStateHistory                    []MatchStateHistory      `json:"matchStateHistory"`
+	GameOverStatusCode                   chess.GameOverStatusCode `json:"gameOverStatus"`
+	ThreefoldRepetition                  bool                     `json:"threefoldRepetition"`
+	FiftyMoveRule                        bool                     `json:"fiftyMoveRule"`
+	WhitePlayerConnected                 bool                     `json:"whitePlayerConnected"`
+	BlackPlayerConnected                 bool                     `json:"blackPlayerConnected"`
+	MillisecondsUntilTimeout             int64                    `json:"millisecondsUntilTimeout"`
+	WhitePlayerUsername                  sql.NullString           `json:"whitePlayerUsername"`
+	BlackPlayerUsername                  sql.NullString           `json:"blackPlayerUsername"`
+	ChatHistory                          []onUserMessageBody      `json:"chatHistory"`
+	RatingChangePreview                  *rating.Preview          `json:"ratingChangePreview"` // Players only, while a rated game is ongoing
+	Rated                                bool                     `json:"rated"`
+	WhitePlayerTimeRemainingMilliseconds int64                    `json:"whitePlayerTimeRemainingMilliseconds"`
+	BlackPlayerTimeRemainingMilliseconds int64                    `json:"blackPlayerTimeRemainingMilliseconds"`
 }
 
 type onMoveBody struct {
 	MatchStateHistory   []MatchStateHistory      `json:"matchStateHistory"`
 	GameOverStatusCode  chess.GameOverStatusCode `json:"gameOverStatus"`
 	ThreefoldRepetition bool                     `json:"threefoldRepetition"`
+	FiftyMoveRule       bool                     `json:"fiftyMoveRule"`
+	// Live clocks, extra time can put them ahead of the latest history entry
+	WhitePlayerTimeRemainingMilliseconds int64 `json:"whitePlayerTimeRemainingMilliseconds"`
+	BlackPlayerTimeRemainingMilliseconds int64 `json:"blackPlayerTimeRemainingMilliseconds"`
 }
 
 type onPlayerConnectionChangeBody struct {
@@ -75,6 +89,12 @@ type onUserMessageBody struct {
 	MessageContent string `json:"messageContent"`
 }
 
+type rematchCreatedBody struct {
+	MatchID                  int64 `json:"matchID"`
+	TimeFormatInMilliseconds int64 `json:"timeFormatInMilliseconds"`
+	IncrementInMilliseconds  int64 `json:"incrementInMilliseconds"`
+}
+
 // Responses
 
 type onConnectResponse struct {
@@ -102,6 +122,11 @@ type onUserMessageResponse struct {
 	Body        onUserMessageBody `json:"body"`
 }
 
+type rematchCreatedResponse struct {
+	MessageType hubMessageType     `json:"messageType"`
+	Body        rematchCreatedBody `json:"body"`
+}
+
 // CLIENT TO WEBSOCKET TYPES
 
 type clientMessageType string
@@ -114,14 +139,18 @@ const (
 )
 
 // Bodies
+// SAN or UCI take priority over piece and move when given
 type postMoveBody struct {
 	Piece           int    `json:"piece"`
 	Move            int    `json:"move"`
 	PromotionString string `json:"promotionString"`
+	SAN             string `json:"san"`
+	UCI             string `json:"uci"`
 }
 
 type playerEventBody struct {
-	EventType eventType `json:"eventType"`
+	EventType               eventType `json:"eventType"`
+	ExtraTimeInMilliseconds int64     `json:"extraTimeInMilliseconds"` // extraTime only, defaults to defaultExtraTime
 }
 
 type userMessageBody struct {
@@ -159,6 +188,11 @@ type MatchStateHistory struct {
 	BlackPlayerTimeRemainingMilliseconds int64  `json:"blackPlayerTimeRemainingMilliseconds"`
 }
 
+type clientMessage struct {
+	client  *MatchRoomHubClient
+	message []byte // First byte is the sender's messageIdentifier
+}
+
 type offerInfo struct {
 	sender messageIdentifier
 	event  eventType
@@ -166,6 +200,17 @@ type offerInfo struct {
 
 const pingTimeout = 20 * time.Second
 
+const (
+	defaultExtraTime = 15 * time.Second
+	maxExtraTime     = 60 * time.Second
+)
+
+const (
+	maxChatMessageLength = 140 // In characters
+	chatRateLimit        = 5   // Messages per chatRateWindow
+	chatRateWindow       = 10 * time.Second
+)
+
 // Hub maintains the set of active clients and broadcasts messages to the
 // clients.
 type MatchRoomHub struct {
@@ -174,7 +219,7 @@ type MatchRoomHub struct {
 	clients map[*MatchRoomHubClient]bool
 
 	// Inbound messages from the clients.
-	broadcast chan []byte
+	broadcast chan *clientMessage
 
 	// Register requests from the clients.
 	register chan *MatchRoomHubClient
@@ -182,6 +227,9 @@ type MatchRoomHub struct {
 	// Unregister requests from clients.
 	unregister chan *MatchRoomHubClient
 
+	// Abort requests from the server, for matches a player never came to
+	abort chan struct{}
+
 	whitePlayerID int64
 
 	whitePlayerUsername sql.NullString
@@ -208,6 +256,8 @@ type MatchRoomHub struct {
 
 	flagTimer <-chan time.Time
 
+	abortTimer <-chan time.Time // Fires if the player to move has not made their first move
+
 	timeFormatInMilliseconds int64
 
 	increment time.Duration
@@ -236,6 +286,8 @@ type MatchRoomHub struct {
 
 	threefoldRepetition bool
 
+	fiftyMoveRule bool
+
 	averageElo float64
 
 	whitePlayerElo int64
@@ -244,6 +296,14 @@ type MatchRoomHub struct {
 
 	matchStartTime int64
 
+	rated bool // Casual matches leave ratings alone
+
+	rematchMatchID int64 // 0 until a rematch has been created
+
+	playerChat []onUserMessageBody
+
+	spectatorChat []onUserMessageBody
+
 	taskQueueWaitGroup *sync.WaitGroup
 }
 
@@ -274,25 +334,40 @@ func newMatchRoomHub(matchID int64) (*MatchRoomHub, error) {
 	var turn playerTurn
 	var fenFreqMap = make(map[string]int)
 	var splitFEN []string
-	var fen string
-	var threefoldRepetition = false
 
-	// FEN Freq Map
+	// Position Freq Map, built the same way as after each move
 	for _, val := range matchStateHistory {
-		splitFEN = strings.Split(val.FEN, " ")
-		fen = strings.Join(splitFEN[:4], " ")
-		fenFreqMap[fen] += 1
-		if fenFreqMap[fen] >= 3 {
-			threefoldRepetition = true
+		fenFreqMap[chess.PositionKey(val.FEN)] += 1
+	}
+	var threefoldRepetition = fenFreqMap[chess.PositionKey(matchState.CurrentFEN)] >= 3
+
+	var fiftyMoveRule = canClaimFiftyMoveRule(matchState.CurrentFEN)
+
+	chatMessages, err := app.chatMessages.EnQueueReturnGetFromMatchID(matchID, nil, nil)
+	if err != nil {
+		app.errorLog.Printf("Error getting chat history: %v\n", err)
+		return nil, err
+	}
+
+	var playerChat, spectatorChat = []onUserMessageBody{}, []onUserMessageBody{}
+	for _, storedMessage := range chatMessages {
+		body := onUserMessageBody{Sender: storedMessage.Sender, MessageContent: storedMessage.MessageContent}
+		if storedMessage.Channel == models.PlayerChatChannel {
+			playerChat = append(playerChat, body)
+		} else {
+			spectatorChat = append(spectatorChat, body)
 		}
 	}
 
 	currentGameState := onMoveResponse{
 		MessageType: onMove,
 		Body: onMoveBody{
-			MatchStateHistory:   matchStateHistory,
-			GameOverStatusCode:  chess.Ongoing,
-			ThreefoldRepetition: threefoldRepetition,
+			MatchStateHistory:                    matchStateHistory,
+			GameOverStatusCode:                   chess.Ongoing,
+			ThreefoldRepetition:                  threefoldRepetition,
+			FiftyMoveRule:                        fiftyMoveRule,
+			WhitePlayerTimeRemainingMilliseconds: matchState.WhitePlayerTimeRemainingMilliseconds,
+			BlackPlayerTimeRemainingMilliseconds: matchState.BlackPlayerTimeRemainingMilliseconds,
 		},
 	}
 
@@ -333,9 +408,10 @@ func newMatchRoomHub(matchID int64) (*MatchRoomHub, error) {
 
 	match := &MatchRoomHub{
 		matchID:                  matchID,
-		broadcast:                make(chan []byte),
+		broadcast:                make(chan *clientMessage),
 		register:                 make(chan *MatchRoomHubClient),
 		unregister:               make(chan *MatchRoomHubClient),
+		abort:                    make(chan struct{}, 1),
 		clients:                  make(map[*MatchRoomHubClient]bool),
 		whitePlayerID:            matchState.WhitePlayerID,
 		whitePlayerUsername:      matchState.WhitePlayerUsername,
@@ -356,15 +432,48 @@ func newMatchRoomHub(matchID int64) (*MatchRoomHub, error) {
 		whitePlayerConnected:     false,
 		blackPlayerConnected:     false,
 		threefoldRepetition:      threefoldRepetition,
+		fiftyMoveRule:            fiftyMoveRule,
 		averageElo:               matchState.AverageElo,
 		whitePlayerElo:           matchState.WhitePlayerElo,
 		blackPlayerElo:           matchState.BlackPlayerElo,
 		matchStartTime:           matchState.MatchStartTime,
+		rated:                    matchState.Rated,
+		playerChat:               playerChat,
+		spectatorChat:            spectatorChat,
 	}
 
+	match.resetAbortTimer()
+
 	return match, nil
 }
 
+// Fifty moves by each player without a pawn move or capture
+func canClaimFiftyMoveRule(fen string) bool {
+	splitFEN := strings.Split(fen, " ")
+	if len(splitFEN) < 5 {
+		return false
+	}
+	halfMoveClock, err := strconv.Atoi(splitFEN[4])
+	if err != nil {
+		app.errorLog.Printf("Could not parse halfmove clock from FEN %s: %v\n", fen, err)
+		return false
+	}
+	return halfMoveClock >= chess.FiftyMoveRuleHalfMoves
+}
+
+// Either player may abort until both have made their first move
+func (hub *MatchRoomHub) canAbort() bool {
+	return !hub.gameEnded && len(hub.moveHistory) < 3
+}
+
+// Counts from the last move, or from the start of the match
+func (hub *MatchRoomHub) resetAbortTimer() {
+	hub.abortTimer = nil
+	if hub.canAbort() {
+		hub.abortTimer = time.After(app.abortTimeout - time.Since(hub.timeOfLastMove))
+	}
+}
+
 func (hub *MatchRoomHub) sendMessageToAllClients(message []byte) {
 	for client := range hub.clients {
 		select {
@@ -418,28 +527,6 @@ func (hub *MatchRoomHub) sendMessageToAllSpectators(message []byte) {
 	}
 }
 
-func getKFactor(elo int64) float64 {
-	if elo < 2100 {
-		return 32
-	} else if elo <= 2400 {
-		return 24
-	} else {
-		return 16
-	}
-}
-
-func calculateEloChanges(playerOneElo int64, playerOnePoints float64, playerTwoElo int64, playerTwoPoints float64) (playerOneEloGain float64, playerTwoEloGain float64) {
-	var playerOneExpectedPoints, playerTwoExpectedPoints float64
-
-	playerOneExpectedPoints = (1) / (1 + math.Pow(10, (playerTwoExpectedPoints-playerOneExpectedPoints)/400))
-	playerTwoExpectedPoints = (1) / (1 + math.Pow(10, (playerOneExpectedPoints-playerTwoExpectedPoints)/400))
-
-	playerOneEloGain = getKFactor(playerOneElo) * (playerOnePoints - playerOneExpectedPoints)
-	playerTwoEloGain = getKFactor(playerTwoElo) * (playerTwoPoints - playerTwoExpectedPoints)
-
-	return playerOneEloGain, playerTwoEloGain
-}
-
 func (hub *MatchRoomHub) changeTurn() {
 	// Swap turn and activate timer if changing back to whites turn
 	if hub.turn == playerTurn(WhiteTurn) {
@@ -494,8 +581,19 @@ func (hub *MatchRoomHub) updateGameStateAfterMove(message []byte) (err error) {
 		return errors.New(fmt.Sprintf("error unmarshalling JSON: %v\n", err))
 	}
 
+	// Resolve notation into square indices
+	var piece, move, promotionString = chessMove.Body.Piece, chessMove.Body.Move, chessMove.Body.PromotionString
+	if chessMove.Body.SAN != "" {
+		piece, move, promotionString, err = chess.MoveFromSAN(hub.current_fen, chessMove.Body.SAN)
+	} else if chessMove.Body.UCI != "" {
+		piece, move, promotionString, err = chess.MoveFromUCI(hub.current_fen, chessMove.Body.UCI)
+	}
+	if err != nil {
+		return fmt.Errorf("could not resolve move: %w", err)
+	}
+
 	// Validate Move
-	var validMove = chess.IsMoveValid(hub.current_fen, chessMove.Body.Piece, chessMove.Body.Move)
+	var validMove = chess.IsMoveValid(hub.current_fen, piece, move)
 	if !validMove {
 		return errors.New("move is not valid")
 	}
@@ -504,14 +602,13 @@ func (hub *MatchRoomHub) updateGameStateAfterMove(message []byte) (err error) {
 	hub.updateTimeRemaining()
 
 	// Calculate reply variables
-	newFEN, gameOverStatus, algebraicNotation := chess.GetFENAfterMove(hub.current_fen, chessMove.Body.Piece, chessMove.Body.Move, chessMove.Body.PromotionString)
-	var threefoldRepetition = false
-	splitFEN := strings.Join(strings.Split(newFEN, " ")[:4], " ")
-	hub.fenFreqMap[splitFEN] += 1
-	if hub.fenFreqMap[splitFEN] >= 3 {
-		threefoldRepetition = true
-	}
+	newFEN, gameOverStatus, algebraicNotation := chess.GetFENAfterMove(hub.current_fen, piece, move, promotionString)
+	positionKey := chess.PositionKey(newFEN)
+	hub.fenFreqMap[positionKey] += 1
+	var threefoldRepetition = hub.fenFreqMap[positionKey] >= 3
 	hub.threefoldRepetition = threefoldRepetition
+	gameOverStatus = chess.AdjudicateRepetition(gameOverStatus, hub.fenFreqMap[positionKey])
+	hub.fiftyMoveRule = canClaimFiftyMoveRule(newFEN)
 
 	// Construct Reply
 	data := onMoveResponse{
@@ -519,13 +616,16 @@ func (hub *MatchRoomHub) updateGameStateAfterMove(message []byte) (err error) {
 		Body: onMoveBody{
 			MatchStateHistory: append(hub.moveHistory, MatchStateHistory{
 				FEN:                                  newFEN,
-				LastMove:                             [2]int{chessMove.Body.Piece, chessMove.Body.Move},
+				LastMove:                             [2]int{piece, move},
 				AlgebraicNotation:                    algebraicNotation,
 				WhitePlayerTimeRemainingMilliseconds: hub.whitePlayerTimeRemaining.Milliseconds(),
 				BlackPlayerTimeRemainingMilliseconds: hub.blackPlayerTimeRemaining.Milliseconds(),
 			}),
-			GameOverStatusCode:  gameOverStatus,
-			ThreefoldRepetition: threefoldRepetition,
+			GameOverStatusCode:                   gameOverStatus,
+			ThreefoldRepetition:                  threefoldRepetition,
+			FiftyMoveRule:                        hub.fiftyMoveRule,
+			WhitePlayerTimeRemainingMilliseconds: hub.whitePlayerTimeRemaining.Milliseconds(),
+			BlackPlayerTimeRemainingMilliseconds: hub.blackPlayerTimeRemaining.Milliseconds(),
 		},
 	}
 
@@ -542,8 +642,12 @@ func (hub *MatchRoomHub) updateGameStateAfterMove(message []byte) (err error) {
 	hub.moveHistory = data.Body.MatchStateHistory
 	hub.timeOfLastMove = time.Now()
 
+	// Pending offers were made against the previous position
+	hub.offerActive = nil
+
 	// Ppdate turn and start new flag timer
 	hub.changeTurn()
+	hub.resetAbortTimer()
 
 	var matchStateHistoryData []byte
 	matchStateHistoryData, err = json.Marshal(data.Body.MatchStateHistory)
@@ -555,7 +659,7 @@ func (hub *MatchRoomHub) updateGameStateAfterMove(message []byte) (err error) {
 	// @TODO: do we need a new waitGroup each time? Hub could just have one waitGroup that we add tasks to
 	var wg sync.WaitGroup
 	wg.Add(1)
-	app.liveMatches.EnQueueUpdateLiveMatch(hub.matchID, newFEN, chessMove.Body.Piece, chessMove.Body.Move, hub.whitePlayerTimeRemaining.Milliseconds(), hub.blackPlayerTimeRemaining.Milliseconds(), matchStateHistoryData, hub.timeOfLastMove, hub.taskQueueWaitGroup, &wg)
+	app.liveMatches.EnQueueUpdateLiveMatch(hub.matchID, newFEN, piece, move, hub.whitePlayerTimeRemaining.Milliseconds(), hub.blackPlayerTimeRemaining.Milliseconds(), matchStateHistoryData, hub.timeOfLastMove, hub.taskQueueWaitGroup, &wg)
 	hub.taskQueueWaitGroup = &wg
 
 	if gameOverStatus != chess.Ongoing {
@@ -575,9 +679,9 @@ func (hub *MatchRoomHub) getCurrentMatchStateForNewConnection(playerIdentifier m
 
 	// Correct times
 	if hub.turn == playerTurn(WhiteTurn) && hub.isTimerActive {
-		gameState.Body.MatchStateHistory[len(gameState.Body.MatchStateHistory)-1].WhitePlayerTimeRemainingMilliseconds -= time.Since(hub.timeOfLastMove).Milliseconds()
+		gameState.Body.WhitePlayerTimeRemainingMilliseconds -= time.Since(hub.timeOfLastMove).Milliseconds()
 	} else if hub.turn == playerTurn(BlackTurn) && hub.isTimerActive {
-		gameState.Body.MatchStateHistory[len(gameState.Body.MatchStateHistory)-1].BlackPlayerTimeRemainingMilliseconds -= time.Since(hub.timeOfLastMove).Milliseconds()
+		gameState.Body.BlackPlayerTimeRemainingMilliseconds -= time.Since(hub.timeOfLastMove).Milliseconds()
 	}
 
 	var millisecondsUntilTimeout int64 = 0
@@ -587,17 +691,42 @@ func (hub *MatchRoomHub) getCurrentMatchStateForNewConnection(playerIdentifier m
 		millisecondsUntilTimeout = pingTimeout.Milliseconds() - time.Since(hub.whitePlayerTimeoutStarted).Milliseconds()
 	}
 
+	var chatHistory = hub.spectatorChat
+	if playerIdentifier == messageIdentifier(WhitePlayer) || playerIdentifier == messageIdentifier(BlackPlayer) {
+		chatHistory = hub.playerChat
+	}
+
+	var ratingChangePreview *rating.Preview
+	if (playerIdentifier == messageIdentifier(WhitePlayer) || playerIdentifier == messageIdentifier(BlackPlayer)) && hub.rated && !hub.gameEnded {
+		// Stored ratings, so the preview decays them once like rateMatchInTx does
+		whitePlayerRating := getStoredPlayerRating(hub.whitePlayerID, hub.timeFormatInMilliseconds)
+		blackPlayerRating := getStoredPlayerRating(hub.blackPlayerID, hub.timeFormatInMilliseconds)
+		var preview rating.Preview
+		if playerIdentifier == messageIdentifier(WhitePlayer) {
+			preview = rating.PreviewChanges(app.ratingSystem, whitePlayerRating, blackPlayerRating, time.Now())
+		} else {
+			preview = rating.PreviewChanges(app.ratingSystem, blackPlayerRating, whitePlayerRating, time.Now())
+		}
+		ratingChangePreview = &preview
+	}
+
 	var response = onConnectResponse{
 		MessageType: onConnect,
 		Body: onConnectBody{
-			MatchStateHistory:        gameState.Body.MatchStateHistory,
-			GameOverStatusCode:       gameState.Body.GameOverStatusCode,
-			ThreefoldRepetition:      gameState.Body.ThreefoldRepetition,
-			WhitePlayerConnected:     hub.whitePlayerConnected,
-			BlackPlayerConnected:     hub.blackPlayerConnected,
-			MillisecondsUntilTimeout: millisecondsUntilTimeout,
-			WhitePlayerUsername:      hub.whitePlayerUsername,
-			BlackPlayerUsername:      hub.blackPlayerUsername,
+			MatchStateHistory:                    gameState.Body.MatchStateHistory,
+			GameOverStatusCode:                   gameState.Body.GameOverStatusCode,
+			ThreefoldRepetition:                  gameState.Body.ThreefoldRepetition,
+			FiftyMoveRule:                        gameState.Body.FiftyMoveRule,
+			WhitePlayerConnected:                 hub.whitePlayerConnected,
+			BlackPlayerConnected:                 hub.blackPlayerConnected,
+			MillisecondsUntilTimeout:             millisecondsUntilTimeout,
+			WhitePlayerUsername:                  hub.whitePlayerUsername,
+			BlackPlayerUsername:                  hub.blackPlayerUsername,
+			ChatHistory:                          chatHistory,
+			RatingChangePreview:                  ratingChangePreview,
+			Rated:                                hub.rated,
+			WhitePlayerTimeRemainingMilliseconds: gameState.Body.WhitePlayerTimeRemainingMilliseconds,
+			BlackPlayerTimeRemainingMilliseconds: gameState.Body.BlackPlayerTimeRemainingMilliseconds,
 		},
 	}
 
@@ -641,28 +770,120 @@ func (hub *MatchRoomHub) getMessageType(message []byte) clientMessageType {
 			return unknown
 		}
 		return playerEvent
+
+	case "userMessage":
+		return chatMessage
 	}
 
 	app.errorLog.Printf("Unknown message type\n")
 	return unknown
 }
 
-// @TODO: implement this
-func (hub *MatchRoomHub) takeBack() {
+// Rolls back until it is the requester's turn again, one ply if they just moved, two if the opponent has replied
+// Two plies on the opponent's turn would take back the requester's move and leave the opponent to move again,
+// so the requester always gets to replay their own move instead
+// With only the opponent's first move played there is a single ply to take back
+func (hub *MatchRoomHub) takeBack(requester messageIdentifier) error {
+	var plies = 1
+	if byte(requester) == byte(hub.turn) {
+		plies = 2
+	}
+
+	// First entry is the starting position and can't be taken back
+	plies = min(plies, len(hub.moveHistory)-1)
+	if plies < 1 {
+		return errors.New("no move to take back")
+	}
+
+	// Removed positions no longer count towards repetitions
+	for _, val := range hub.moveHistory[len(hub.moveHistory)-plies:] {
+		hub.fenFreqMap[chess.PositionKey(val.FEN)] -= 1
+	}
+
+	var matchStateHistory = hub.moveHistory[:len(hub.moveHistory)-plies]
+	var restored = matchStateHistory[len(matchStateHistory)-1]
+
+	hub.current_fen = restored.FEN
+	hub.moveHistory = matchStateHistory
+	hub.whitePlayerTimeRemaining = time.Duration(restored.WhitePlayerTimeRemainingMilliseconds) * time.Millisecond
+	hub.blackPlayerTimeRemaining = time.Duration(restored.BlackPlayerTimeRemainingMilliseconds) * time.Millisecond
+	hub.timeOfLastMove = time.Now()
+	hub.threefoldRepetition = hub.fenFreqMap[chess.PositionKey(restored.FEN)] >= 3
+	hub.fiftyMoveRule = canClaimFiftyMoveRule(restored.FEN)
+
+	splitFEN := strings.Split(restored.FEN, " ")
+	if splitFEN[1] == "w" {
+		hub.turn = playerTurn(WhiteTurn)
+	} else {
+		hub.turn = playerTurn(BlackTurn)
+	}
+
+	// Same rule as newMatchRoomHub, clocks start once both players have moved
+	hub.isTimerActive = splitFEN[5] != "1"
+	hub.flagTimer = nil
+	if hub.isTimerActive && hub.turn == playerTurn(WhiteTurn) {
+		hub.flagTimer = time.After(hub.whitePlayerTimeRemaining)
+	} else if hub.isTimerActive {
+		hub.flagTimer = time.After(hub.blackPlayerTimeRemaining)
+	}
 
+	data := onMoveResponse{
+		MessageType: onMove,
+		Body: onMoveBody{
+			MatchStateHistory:                    matchStateHistory,
+			GameOverStatusCode:                   chess.Ongoing,
+			ThreefoldRepetition:                  hub.threefoldRepetition,
+			FiftyMoveRule:                        hub.fiftyMoveRule,
+			WhitePlayerTimeRemainingMilliseconds: hub.whitePlayerTimeRemaining.Milliseconds(),
+			BlackPlayerTimeRemainingMilliseconds: hub.blackPlayerTimeRemaining.Milliseconds(),
+		},
+	}
+
+	jsonStr, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("error marshalling JSON: %w", err)
+	}
+	hub.currentGameState = jsonStr
+	hub.resetAbortTimer()
+
+	matchStateHistoryData, err := json.Marshal(matchStateHistory)
+	if err != nil {
+		return fmt.Errorf("error marshalling matchStateHistoryData: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	app.liveMatches.EnQueueUpdateLiveMatch(hub.matchID, restored.FEN, restored.LastMove[0], restored.LastMove[1], hub.whitePlayerTimeRemaining.Milliseconds(), hub.blackPlayerTimeRemaining.Milliseconds(), matchStateHistoryData, hub.timeOfLastMove, hub.taskQueueWaitGroup, &wg)
+	hub.taskQueueWaitGroup = &wg
+
+	return nil
 }
 
 func (hub *MatchRoomHub) acceptEventOffer(event eventType) {
 	// @TODO implement
 	app.infoLog.Printf("Making accepting event of type %s\n", event)
+	var sender = hub.offerActive.sender
+	hub.offerActive = nil
+
 	switch event {
 	case takeback:
-		hub.takeBack()
+		err := hub.takeBack(sender)
+		if err != nil {
+			app.errorLog.Printf("Could not take back move: %v\n", err)
+			return
+		}
+		hub.sendMessageToAllClients(hub.currentGameState)
 
 	case draw:
 		hub.endGame(chess.Draw)
 		hub.sendMessageToAllClients(hub.currentGameState)
 
+	case rematch:
+		err := hub.createRematch()
+		if err != nil {
+			app.errorLog.Printf("Could not create rematch: %v\n", err)
+		}
+
 	default:
 		return
 	}
@@ -672,6 +893,7 @@ func (hub *MatchRoomHub) endGame(reason chess.GameOverStatusCode) error {
 	// Updates game state and updates DB. Does not send response
 	app.infoLog.Println("Ending Match")
 	hub.flagTimer = nil
+	hub.abortTimer = nil
 	var gameState onMoveResponse
 	err := json.Unmarshal(hub.currentGameState, &gameState)
 	if err != nil {
@@ -694,25 +916,60 @@ func (hub *MatchRoomHub) endGame(reason chess.GameOverStatusCode) error {
 	var whitePlayerPoints, blackPlayerPoints float64
 
 	if outcome == 1 {
-		whitePlayerPoints = 1
-		blackPlayerPoints = 0
+		whitePlayerPoints = rating.Win
+		blackPlayerPoints = rating.Loss
 	} else if outcome == 2 {
-		whitePlayerPoints = 0
-		blackPlayerPoints = 1
+		whitePlayerPoints = rating.Loss
+		blackPlayerPoints = rating.Win
 	} else {
-		whitePlayerPoints = 0.5
-		blackPlayerPoints = 0.5
+		whitePlayerPoints = rating.Draw
+		blackPlayerPoints = rating.Draw
 	}
 
-	whitePlayerEloGain, blackPlayerEloGain := calculateEloChanges(hub.whitePlayerElo, whitePlayerPoints, hub.blackPlayerElo, blackPlayerPoints)
-	app.infoLog.Printf("whitePlayerElo: %v, whitePlayerEloGain: %v\n", hub.whitePlayerElo, whitePlayerEloGain)
-	whitePlayerNewElo := int64(math.Max(float64(hub.whitePlayerElo)+math.Round(whitePlayerEloGain), 0))
-	blackPlayerNewElo := int64(math.Max(float64(hub.blackPlayerElo)+math.Round(blackPlayerEloGain), 0))
-	go app.userRatings.UpdateRatingFromPlayerID(hub.whitePlayerID, models.GetRatingTypeFromTimeFormat(hub.timeFormatInMilliseconds), whitePlayerNewElo)
-	go app.userRatings.UpdateRatingFromPlayerID(hub.blackPlayerID, models.GetRatingTypeFromTimeFormat(hub.timeFormatInMilliseconds), blackPlayerNewElo)
+	// Aborted and casual games are not rated
+	var matchRating *models.MatchRating
+	if reason != chess.Abort && hub.rated {
+		matchRating = &models.MatchRating{
+			System:            app.ratingSystem,
+			WhitePlayerPoints: whitePlayerPoints,
+			BlackPlayerPoints: blackPlayerPoints,
+		}
+	}
 
 	hub.gameEnded = true
-	app.liveMatches.EnQueueMoveMatchToPastMatches(hub.matchID, outcome, reason, whitePlayerNewElo-hub.whitePlayerElo, blackPlayerNewElo-hub.blackPlayerElo, hub.taskQueueWaitGroup, nil)
+	app.liveMatches.EnQueueMoveMatchToPastMatches(hub.matchID, outcome, reason, matchRating, hub.taskQueueWaitGroup, nil)
+	return nil
+}
+
+func (hub *MatchRoomHub) createRematch() error {
+	if hub.rematchMatchID == 0 {
+		// Same time control, colours swapped
+		whitePlayerData := &playerMatchmakingData{playerID: hub.blackPlayerID, elo: getPlayerRating(hub.blackPlayerID, hub.timeFormatInMilliseconds).RoundedRating()}
+		blackPlayerData := &playerMatchmakingData{playerID: hub.whitePlayerID, elo: getPlayerRating(hub.whitePlayerID, hub.timeFormatInMilliseconds).RoundedRating()}
+
+		matchID, err := insertNewMatch(whitePlayerData, blackPlayerData, hub.timeFormatInMilliseconds, hub.increment.Milliseconds(), hub.rated)
+		if err != nil {
+			return err
+		}
+		hub.rematchMatchID = matchID
+	}
+
+	response := rematchCreatedResponse{
+		MessageType: rematchCreated,
+		Body: rematchCreatedBody{
+			MatchID:                  hub.rematchMatchID,
+			TimeFormatInMilliseconds: hub.timeFormatInMilliseconds,
+			IncrementInMilliseconds:  hub.increment.Milliseconds(),
+		},
+	}
+
+	jsonStr, err := json.Marshal(response)
+	if err != nil {
+		app.errorLog.Printf("Could not marshal rematchCreatedResponse: %s\n", err)
+		return err
+	}
+
+	hub.sendMessageToAllPlayers(jsonStr)
 	return nil
 }
 
@@ -743,14 +1000,95 @@ func (hub *MatchRoomHub) makeNewEventOffer(sender messageIdentifier, event event
 	hub.sendMessageToOnePlayer(jsonStr, receiver)
 }
 
+// Adds time to the recipient's clock and broadcasts the new clocks
+func (hub *MatchRoomHub) giveExtraTime(recipient messageIdentifier, extraTime time.Duration) error {
+	// Charge the player on the move up to now, so the latest clocks are current
+	var now = time.Now()
+	if hub.isTimerActive && hub.turn == playerTurn(WhiteTurn) {
+		hub.whitePlayerTimeRemaining -= now.Sub(hub.timeOfLastMove)
+	} else if hub.isTimerActive {
+		hub.blackPlayerTimeRemaining -= now.Sub(hub.timeOfLastMove)
+	}
+	hub.timeOfLastMove = now
+
+	if recipient == messageIdentifier(WhitePlayer) {
+		hub.whitePlayerTimeRemaining += extraTime
+	} else {
+		hub.blackPlayerTimeRemaining += extraTime
+	}
+
+	if hub.isTimerActive && hub.turn == playerTurn(WhiteTurn) {
+		hub.flagTimer = time.After(hub.whitePlayerTimeRemaining)
+	} else if hub.isTimerActive {
+		hub.flagTimer = time.After(hub.blackPlayerTimeRemaining)
+	}
+
+	// The history keeps the clocks as they were after each move, the gift only lives in the live clocks
+	var latest = hub.moveHistory[len(hub.moveHistory)-1]
+
+	data := onMoveResponse{
+		MessageType: onMove,
+		Body: onMoveBody{
+			MatchStateHistory:                    hub.moveHistory,
+			GameOverStatusCode:                   chess.Ongoing,
+			ThreefoldRepetition:                  hub.threefoldRepetition,
+			FiftyMoveRule:                        hub.fiftyMoveRule,
+			WhitePlayerTimeRemainingMilliseconds: hub.whitePlayerTimeRemaining.Milliseconds(),
+			BlackPlayerTimeRemainingMilliseconds: hub.blackPlayerTimeRemaining.Milliseconds(),
+		},
+	}
+
+	jsonStr, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("error marshalling JSON: %w", err)
+	}
+	hub.currentGameState = jsonStr
+
+	matchStateHistoryData, err := json.Marshal(hub.moveHistory)
+	if err != nil {
+		return fmt.Errorf("error marshalling matchStateHistoryData: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	app.liveMatches.EnQueueUpdateLiveMatch(hub.matchID, hub.current_fen, latest.LastMove[0], latest.LastMove[1], hub.whitePlayerTimeRemaining.Milliseconds(), hub.blackPlayerTimeRemaining.Milliseconds(), matchStateHistoryData, hub.timeOfLastMove, hub.taskQueueWaitGroup, &wg)
+	hub.taskQueueWaitGroup = &wg
+
+	hub.sendMessageToAllClients(hub.currentGameState)
+	return nil
+}
+
 func isOneSidedEvent(event eventType) bool {
 	return event == extraTime || event == resign || event == abort || event == disconnect
 }
 
-func (hub *MatchRoomHub) oneSidedEvent(sender messageIdentifier, event eventType) {
-	switch event {
+func (hub *MatchRoomHub) oneSidedEvent(sender messageIdentifier, body playerEventBody) {
+	switch body.EventType {
 	case extraTime:
-		return
+		var amount = time.Duration(body.ExtraTimeInMilliseconds) * time.Millisecond
+		if amount == 0 {
+			amount = defaultExtraTime
+		}
+		if amount < 0 || amount > maxExtraTime {
+			app.errorLog.Printf("Extra time of %s is out of range\n", amount)
+			return
+		}
+
+		var recipient = messageIdentifier(WhitePlayer)
+		if sender == messageIdentifier(WhitePlayer) {
+			recipient = messageIdentifier(BlackPlayer)
+		}
+
+		err := hub.giveExtraTime(recipient, amount)
+		if err != nil {
+			app.errorLog.Printf("Could not give extra time: %v\n", err)
+		}
+	case abort:
+		if !hub.canAbort() {
+			return
+		}
+		hub.endGame(chess.Abort)
+		hub.sendMessageToAllClients(hub.currentGameState)
 	case resign:
 		if sender == chess.White {
 			hub.endGame(chess.WhiteResigned)
@@ -776,6 +1114,22 @@ func (hub *MatchRoomHub) handlePlayerEvent(message []byte) {
 		return
 	}
 
+	// Once the game is over only a rematch can be offered or declined
+	if hub.gameEnded && data.Body.EventType != rematch && data.Body.EventType != decline {
+		return
+	}
+
+	if data.Body.EventType == rematch {
+		if !hub.gameEnded {
+			return
+		}
+		// Already agreed, send the match again instead of offering another
+		if hub.rematchMatchID != 0 {
+			hub.createRematch()
+			return
+		}
+	}
+
 	if data.Body.EventType == threefoldRepetition {
 		if hub.threefoldRepetition {
 			hub.endGame(chess.ThreefoldRepetition)
@@ -784,8 +1138,16 @@ func (hub *MatchRoomHub) handlePlayerEvent(message []byte) {
 		return
 	}
 
+	if data.Body.EventType == fiftyMoveRule {
+		if hub.fiftyMoveRule {
+			hub.endGame(chess.FiftyMoveRule)
+			hub.sendMessageToAllClients(hub.currentGameState)
+		}
+		return
+	}
+
 	if isOneSidedEvent(data.Body.EventType) {
-		hub.oneSidedEvent(messageIdentifier(message[0]), data.Body.EventType)
+		hub.oneSidedEvent(messageIdentifier(message[0]), data.Body)
 	} else if hub.offerActive == nil || hub.offerActive.event != data.Body.EventType {
 		hub.makeNewEventOffer(messageIdentifier(message[0]), data.Body.EventType)
 	} else if hub.offerActive != nil && byte(hub.offerActive.sender) != message[0] {
@@ -794,7 +1156,75 @@ func (hub *MatchRoomHub) handlePlayerEvent(message []byte) {
 
 }
 
-func (hub *MatchRoomHub) handleMessage(message []byte) {
+// Drops messages once a client has sent chatRateLimit within chatRateWindow
+func (client *MatchRoomHubClient) allowChatMessage(now time.Time) bool {
+	var recent = client.recentChatMessages[:0]
+	for _, timeSent := range client.recentChatMessages {
+		if now.Sub(timeSent) < chatRateWindow {
+			recent = append(recent, timeSent)
+		}
+	}
+	client.recentChatMessages = recent
+
+	if len(client.recentChatMessages) >= chatRateLimit {
+		return false
+	}
+	client.recentChatMessages = append(client.recentChatMessages, now)
+	return true
+}
+
+func (hub *MatchRoomHub) handleUserMessage(client *MatchRoomHubClient, message []byte) {
+	var data userMessageResponse
+	err := json.Unmarshal(message[1:], &data)
+	if err != nil {
+		app.errorLog.Printf("Could not unmarshal userMessage: %s", err)
+		return
+	}
+
+	var messageContent = strings.TrimSpace(data.Body.MessageContent)
+	if messageContent == "" || utf8.RuneCountInString(messageContent) > maxChatMessageLength {
+		app.infoLog.Printf("Dropping chat message with length %v\n", utf8.RuneCountInString(messageContent))
+		return
+	}
+
+	var now = time.Now()
+	if !client.allowChatMessage(now) {
+		app.infoLog.Printf("Player %v is sending chat messages too quickly\n", client.playerID)
+		return
+	}
+
+	var sender = client.username
+	if sender == "" {
+		sender = "Anon"
+	}
+
+	response := onUserMessageResponse{
+		MessageType: userMessage,
+		Body:        onUserMessageBody{Sender: sender, MessageContent: messageContent},
+	}
+
+	jsonStr, err := json.Marshal(response)
+	if err != nil {
+		app.errorLog.Printf("Could not marshal onUserMessageResponse: %s\n", err)
+		return
+	}
+
+	// Players and spectators can not read each other's chat
+	var channel string
+	if client.playerIdentifier == messageIdentifier(WhitePlayer) || client.playerIdentifier == messageIdentifier(BlackPlayer) {
+		channel = models.PlayerChatChannel
+		hub.playerChat = append(hub.playerChat, response.Body)
+		hub.sendMessageToAllPlayers(jsonStr)
+	} else {
+		channel = models.SpectatorChatChannel
+		hub.spectatorChat = append(hub.spectatorChat, response.Body)
+		hub.sendMessageToAllSpectators(jsonStr)
+	}
+
+	app.chatMessages.EnQueueInsertNew(hub.matchID, client.playerID, sender, channel, messageContent, now, nil, nil)
+}
+
+func (hub *MatchRoomHub) handleMessage(client *MatchRoomHubClient, message []byte) {
 	switch msgType := hub.getMessageType(message); msgType {
 	case postMove:
 
@@ -819,12 +1249,12 @@ func (hub *MatchRoomHub) handleMessage(message []byte) {
 
 	case playerEvent:
 
-		if hub.gameEnded {
-			return
-		}
 		// @TODO: implement this fully
 		hub.handlePlayerEvent(message)
 
+	case chatMessage:
+		hub.handleUserMessage(client, message)
+
 	default:
 		app.errorLog.Printf("Could not understand message: %s\n", message)
 		return
@@ -917,6 +1347,10 @@ func (hub *MatchRoomHub) run() {
 			hub.setDisconnected(client)
 			if !hub.hasActiveClients() {
 				matchRoomHubManager.unregisterHub(hub.matchID)
+				// The abort timer stops with the hub
+				if hub.canAbort() {
+					matchRoomHubManager.scheduleAbort(hub.matchID, app.abortTimeout-time.Since(hub.timeOfLastMove))
+				}
 				return
 			}
 
@@ -935,16 +1369,38 @@ func (hub *MatchRoomHub) run() {
 
 			hub.sendMessageToAllClients(hub.currentGameState)
 
+		case <-hub.abort:
+			// Players may have moved since the abort was requested
+			if !hub.canAbort() {
+				continue
+			}
+			err := hub.endGame(chess.Abort)
+			if err != nil {
+				app.errorLog.Println(err)
+				continue
+			}
+
+			hub.sendMessageToAllClients(hub.currentGameState)
+
+		case <-hub.abortTimer:
+			err := hub.endGame(chess.Abort)
+			if err != nil {
+				app.errorLog.Println(err)
+				continue
+			}
+
+			hub.sendMessageToAllClients(hub.currentGameState)
+
 		case <-hub.whitePlayerTimeout:
 			hub.blackCanClaimTimeout = true
 
 		case <-hub.blackPlayerTimeout:
 			hub.whiteCanClaimTimeout = true
 
-		case message := <-hub.broadcast:
-			app.infoLog.Printf("WS Message: %s\n", message)
+		case received := <-hub.broadcast:
+			app.infoLog.Printf("WS Message: %s\n", received.message)
 
-			hub.handleMessage(message)
+			hub.handleMessage(received.client, received.message)
 
 		}
 	}
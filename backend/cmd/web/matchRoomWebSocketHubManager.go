@@ -7,7 +7,12 @@
 package main
 
 import (
+	"burrchess/internal/chess"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -43,6 +48,65 @@ func (hubManager *MatchRoomHubManager) unregisterHub(matchID int64) {
 	delete(hubManager.hubs, matchID)
 }
 
+// Aborts a match before its first moves, a running hub aborts it itself unless the players have moved by then
+func (hubManager *MatchRoomHubManager) abortUnstartedMatch(matchID int64) error {
+	hubManager.mu.Lock()
+	defer hubManager.mu.Unlock()
+
+	if hub, ok := hubManager.hubs[matchID]; ok {
+		// Never blocks, the hub may be stopping and one pending request is enough
+		select {
+		case hub.abort <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+	return app.liveMatches.EnQueueReturnMoveMatchToPastMatches(matchID, 0, chess.Abort, nil, nil, nil)
+}
+
+// Aborts the match after the given time if it still has no first moves, even if no one opens the match room
+func (hubManager *MatchRoomHubManager) scheduleAbort(matchID int64, after time.Duration) {
+	time.AfterFunc(after, func() {
+		err := hubManager.abortIdleMatch(matchID)
+		if err != nil {
+			app.errorLog.Printf("Error aborting idle match %v: %v\n", matchID, err)
+		}
+	})
+}
+
+// A running hub keeps its own abort timer, otherwise the stored match is aborted if no one moved for abortTimeout
+func (hubManager *MatchRoomHubManager) abortIdleMatch(matchID int64) error {
+	hubManager.mu.Lock()
+	defer hubManager.mu.Unlock()
+
+	if _, ok := hubManager.hubs[matchID]; ok {
+		return nil
+	}
+
+	matchState, err := app.liveMatches.EnQueueReturnGetFromMatchID(matchID, nil, nil)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The match already ended
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var matchStateHistory []MatchStateHistory
+	err = json.Unmarshal(matchState.GameHistoryJSONString, &matchStateHistory)
+	if err != nil {
+		return err
+	}
+	// Same rule as the hub's canAbort, both players have moved once the history has 3 positions
+	if len(matchStateHistory) >= 3 {
+		return nil
+	}
+	// A later timer covers matches that were moved in since this one was scheduled
+	if time.Since(time.UnixMilli(matchState.UnixMsTimeOfLastMove)) < app.abortTimeout {
+		return nil
+	}
+	return app.liveMatches.EnQueueReturnMoveMatchToPastMatches(matchID, 0, chess.Abort, nil, nil, nil)
+}
+
 func (hubManager *MatchRoomHubManager) getHubFromMatchID(matchID int64) (*MatchRoomHub, error) {
 	hubManager.mu.Lock()
 	defer hubManager.mu.Unlock()
@@ -62,7 +126,7 @@ func (hubManager *MatchRoomHubManager) getHubFromMatchID(matchID int64) (*MatchR
 	return val, nil
 }
 
-func (hubManager *MatchRoomHubManager) registerClientToMatchRoomHub(conn *websocket.Conn, matchID int64, playerID *int64) (*MatchRoomHubClient, error) {
+func (hubManager *MatchRoomHubManager) registerClientToMatchRoomHub(conn *websocket.Conn, matchID int64, playerID *int64, username string) (*MatchRoomHubClient, error) {
 	val, err := hubManager.getHubFromMatchID(matchID)
 	if err != nil {
 		app.errorLog.Println(err)
@@ -70,6 +134,7 @@ func (hubManager *MatchRoomHubManager) registerClientToMatchRoomHub(conn *websoc
 	}
 
 	var playerCode messageIdentifier = messageIdentifier(Spectator)
+	var clientPlayerID int64
 
 	if playerID == nil {
 		// Do nothing
@@ -79,5 +144,9 @@ func (hubManager *MatchRoomHubManager) registerClientToMatchRoomHub(conn *websoc
 		playerCode = messageIdentifier(BlackPlayer)
 	}
 
-	return &MatchRoomHubClient{hub: val, conn: conn, playerIdentifier: playerCode, send: make(chan []byte, 256)}, nil
+	if playerID != nil {
+		clientPlayerID = *playerID
+	}
+
+	return &MatchRoomHubClient{hub: val, conn: conn, playerIdentifier: playerCode, playerID: clientPlayerID, username: username, send: make(chan []byte, 256)}, nil
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAbortIdleMatch(t *testing.T) {
+	var tests = []struct {
+		name    string
+		moves   int
+		idle    time.Duration
+		aborted bool
+	}{
+		{name: "fresh match", moves: 0, idle: 0, aborted: false},
+		{name: "no one opened the match room", moves: 0, idle: 2 * time.Minute, aborted: true},
+		{name: "black never moved", moves: 1, idle: 2 * time.Minute, aborted: true},
+		{name: "both players moved", moves: 2, idle: 2 * time.Minute, aborted: false},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			whitePlayer := newTestPlayer(3_000_000+int64(2*i), 1500, 400)
+			blackPlayer := newTestPlayer(3_000_001+int64(2*i), 1500, 400)
+			matchID, err := insertNewMatch(whitePlayer, blackPlayer, 60_000, 0, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Only the length of the history and the time of the last move are read
+			var history = make([]MatchStateHistory, test.moves+1)
+			historyJSON, err := json.Marshal(history)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = app.liveMatches.UpdateLiveMatch(matchID, "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", 0, 0, 60_000, 60_000, historyJSON, time.Now().Add(-test.idle))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = matchRoomHubManager.abortIdleMatch(matchID)
+			if err != nil {
+				t.Fatalf("abortIdleMatch: %v", err)
+			}
+			inMatch, err := app.liveMatches.IsPlayerInMatch(whitePlayer.playerID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inMatch == test.aborted {
+				t.Errorf("white still in the match %v, want %v", inMatch, !test.aborted)
+			}
+
+			// The timer scheduled at creation finds the match ended or not yet idle
+			err = matchRoomHubManager.abortIdleMatch(matchID)
+			if err != nil {
+				t.Errorf("second abortIdleMatch: %v", err)
+			}
+		})
+	}
+}
@@ -1,158 +1,361 @@
 package main
 
 import (
+	"burrchess/internal/rating"
 	"encoding/json"
 	"fmt"
+	"math"
 	"math/rand"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
-// Could make a map from playerid to some struct with a mutex
-// When remove request comes in, check map and toggle a bool in the struct
-
-// When a player joins the queue add them to the matchmaking pool
-// Pause joining
-// Find matches
-// If no matches found for a player increase threshold
-// Enable joining
-// Sleep 500ms
+// Joining and leaving take the matchmaker's lock and wake the service
+// The service pairs players under the lock, then creates the matches without it
+// Between wakes it sleeps until the next threshold widens or search range report is due
+// Only players with an open /listenformatch stream are paired, the rest are dropped once queueListenTimeout
+// has passed since they joined, streams opening and closing wake the service
 
 // Matchmaking requirements
 // 1. Time
 // 2. Increment
+// 3. Rated or casual
+// 4. Colour, a player who asked for a colour is only paired with someone who can take the other one
 
 // Matchmaking factors
 // 1. Elo
+// 2. Rating deviation, an uncertain rating is matched over a wider range
+// 3. Time waited, the range widens the longer a player waits
+// 4. The player's own maximum rating gap, which the range never passes
+// 5. Recent opponents, re-pairing them is penalised for rematchCooldown
+// 6. Blocks, players who blocked each other are never paired
 
 type playerMatchmakingData struct {
 	playerID             int64
 	elo                  int64
+	deviation            float64
 	matchmakingThreshold int64
-	isMatched            bool
+	initialThreshold     int64
+	maxRatingGap         int64 // 0 for no limit
+	joinedAt             time.Time
+	reportedThreshold    int64               // Threshold last sent to the player, 0 before the first report
+	reportedAt           time.Time           // When the search range was last sent to the player
+	recentOpponents      map[int64]time.Time // Opponents within rematchCooldown, to the end of their last match
+	blocked              map[int64]bool      // Players blocked by or blocking this player
+	colour               colourPreference    // Colour the player queued for
 }
 
-type matchingScore struct {
-	playerOneID  int64
-	playerOneIdx int
-	playerTwoID  int64
-	playerTwoIdx int
-	score        int64
+// Rating difference the player accepts right now
+func (p *playerMatchmakingData) searchThreshold() int64 {
+	if p.maxRatingGap > 0 {
+		return min(p.matchmakingThreshold, p.maxRatingGap)
+	}
+	return p.matchmakingThreshold
 }
 
-type OpenPool struct {
-	mu       sync.Mutex
-	openPool int
+// How a queue widens the threshold of waiting players
+type thresholdWidening struct {
+	step     int64 // Added to the threshold every interval
+	interval time.Duration
+	max      int64 // Widening stops at max, thresholds that start above it are kept
 }
 
-// Could we use a similar idea to the OpenPool struct
-// Have a bool that indicates if the map is in use
-// and if it is then put the requests in a queue instead
-type RemovalMap struct {
-	mu              sync.Mutex
-	awaitingRemoval map[int64]bool
+func (widening thresholdWidening) threshold(initial int64, waited time.Duration) int64 {
+	if widening.step <= 0 || widening.interval <= 0 {
+		return initial
+	}
+	widened := initial + widening.step*int64(waited/widening.interval)
+	return max(initial, min(widened, widening.max))
 }
 
+// When the threshold next widens, zero once it has stopped widening
+func (widening thresholdWidening) nextWidening(initial int64, joinedAt time.Time, now time.Time) time.Time {
+	if widening.step <= 0 || widening.interval <= 0 || widening.threshold(initial, now.Sub(joinedAt)) >= widening.max {
+		return time.Time{}
+	}
+	return joinedAt.Add((now.Sub(joinedAt)/widening.interval + 1) * widening.interval)
+}
+
+// Parses "time+increment=step/interval/max" entries separated by commas into widenings, keyed by timeControlKey
+// Rated and casual queues of a time control widen the same way
+func parseQueueWidening(spec string, widenings map[string]thresholdWidening) error {
+	for _, entry := range strings.Split(spec, ",") {
+		var queue, settings, found = strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			return fmt.Errorf("queue widening %q is missing \"=\"", entry)
+		}
+
+		var timeFormatInMilliseconds, incrementInMilliseconds int64
+		_, err := fmt.Sscanf(queue, "%d+%d", &timeFormatInMilliseconds, &incrementInMilliseconds)
+		if err != nil {
+			return fmt.Errorf("queue widening %q: %w", entry, err)
+		}
+
+		var fields = strings.Split(settings, "/")
+		if len(fields) != 3 {
+			return fmt.Errorf("queue widening %q is not step/interval/max", entry)
+		}
+
+		var widening thresholdWidening
+		widening.step, err = strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("queue widening %q step: %w", entry, err)
+		}
+		widening.interval, err = time.ParseDuration(fields[1])
+		if err != nil {
+			return fmt.Errorf("queue widening %q interval: %w", entry, err)
+		}
+		widening.max, err = strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("queue widening %q max: %w", entry, err)
+		}
+
+		widenings[timeControlKey(timeFormatInMilliseconds, incrementInMilliseconds)] = widening
+	}
+	return nil
+}
+
+// Number of recent waits the estimated wait is based on
+const recentWaitsSize = 20
+
+// Waiting players are sent their search range at least this often, so their wait stays up to date
+const searchRangeReportInterval = 5 * time.Second
+
+// Data of the "searchRange" SSE event sent to players in a queue
+type searchRangeEventData struct {
+	TimeFormatInMilliseconds int64  `json:"timeFormatInMilliseconds"`
+	IncrementInMilliseconds  int64  `json:"incrementInMilliseconds"`
+	Rated                    bool   `json:"rated"`
+	MinRating                int64  `json:"minRating"`
+	MaxRating                int64  `json:"maxRating"`
+	WaitedMs                 int64  `json:"waitedMs"`
+	EstimatedWaitMs          *int64 `json:"estimatedWaitMs"` // nil until the queue has paired players
+	WaitingPlayers           int    `json:"waitingPlayers"`
+}
+
+// One queue in the GET /queues response
+type queueStatus struct {
+	TimeFormatInMilliseconds int64  `json:"timeFormatInMilliseconds"`
+	IncrementInMilliseconds  int64  `json:"incrementInMilliseconds"`
+	Rated                    bool   `json:"rated"`
+	WaitingPlayers           int    `json:"waitingPlayers"`
+	MedianWaitMs             *int64 `json:"medianWaitMs"` // Of recently paired players, nil until the queue has paired players
+	MinRating                *int64 `json:"minRating"`    // Of waiting players, nil when no one is waiting
+	MaxRating                *int64 `json:"maxRating"`
+}
+
+type matchingScore struct {
+	playerOne *playerMatchmakingData
+	playerTwo *playerMatchmakingData
+	score     int64 // The rating gap plus any penalties
+}
+
+// Players are only scored against this many of their neighbours by rating
+const pairingWindow = 8
+
+// Time a player who joined a queue has to start listening for matches before they are dropped
+// It also covers changing queues, where the old stream may close after the new queue was joined
+const queueListenTimeout = 10 * time.Second
+
+// Sleep of the service when no one is waiting, joins wake it earlier
+const idleMatchmakingWake = time.Minute
+
 type QueueData struct {
-	openPool                 *OpenPool
-	waitingToJoinPoolA       *[]*playerMatchmakingData
-	waitingToJoinPoolB       *[]*playerMatchmakingData
-	matchmakingPool          *[]*playerMatchmakingData
-	awaitingRemoval          *RemovalMap
-	pendingRemovalRequests   *[]int
+	players                  map[int64]*playerMatchmakingData
 	timeFormatInMilliseconds int64
 	incrementInMilliseconds  int64
+	rated                    bool
+	widening                 thresholdWidening
+	recentWaits              []time.Duration // How long recently paired players waited, oldest first
 }
 
-var queueMap = make(map[string]*QueueData)
+// A message to send once the matchmaker's lock is released
+type matchmakingNotice struct {
+	playerID int64
+	message  sseMessage
+}
 
-func addNewQueue(timeFormatInMilliseconds int64, incrementInMilliseconds int64) {
-	app.infoLog.Printf("Creating new queue: %v %v\n", timeFormatInMilliseconds, incrementInMilliseconds)
-	var key string = fmt.Sprintf("%v + %v", timeFormatInMilliseconds, incrementInMilliseconds)
-	queueMap[key] = &QueueData{
-		openPool:                 &OpenPool{openPool: 0},
-		waitingToJoinPoolA:       &[]*playerMatchmakingData{},
-		waitingToJoinPoolB:       &[]*playerMatchmakingData{},
-		matchmakingPool:          &[]*playerMatchmakingData{},
-		awaitingRemoval:          &RemovalMap{awaitingRemoval: make(map[int64]bool)},
-		pendingRemovalRequests:   &[]int{},
+// Owns every queue, nothing outside the matchmaker touches a QueueData
+type Matchmaker struct {
+	mu     sync.Mutex
+	queues map[string]*QueueData
+	wake   chan struct{}
+}
+
+var matchmaker = Matchmaker{
+	queues: make(map[string]*QueueData),
+	wake:   make(chan struct{}, 1),
+}
+
+func timeControlKey(timeFormatInMilliseconds int64, incrementInMilliseconds int64) string {
+	return fmt.Sprintf("%v + %v", timeFormatInMilliseconds, incrementInMilliseconds)
+}
+
+// Rated and casual players of a time control wait in separate queues
+func queueKey(timeFormatInMilliseconds int64, incrementInMilliseconds int64, rated bool) string {
+	if rated {
+		return timeControlKey(timeFormatInMilliseconds, incrementInMilliseconds) + " rated"
+	}
+	return timeControlKey(timeFormatInMilliseconds, incrementInMilliseconds) + " casual"
+}
+
+// Caller must hold mu
+func (m *Matchmaker) getOrCreateQueue(timeFormatInMilliseconds int64, incrementInMilliseconds int64, rated bool) *QueueData {
+	var key string = queueKey(timeFormatInMilliseconds, incrementInMilliseconds, rated)
+	queue, ok := m.queues[key]
+	if ok {
+		return queue
+	}
+
+	app.infoLog.Printf("Creating new queue: %v\n", key)
+	widening, ok := app.queueWidening[timeControlKey(timeFormatInMilliseconds, incrementInMilliseconds)]
+	if !ok {
+		widening = app.matchmakingWidening
+	}
+
+	queue = &QueueData{
+		players:                  make(map[int64]*playerMatchmakingData),
 		timeFormatInMilliseconds: timeFormatInMilliseconds,
 		incrementInMilliseconds:  incrementInMilliseconds,
+		rated:                    rated,
+		widening:                 widening,
 	}
+	m.queues[key] = queue
+	return queue
 }
 
-// // Lock openPol?
-// var openPool = OpenPool{openPool: 0} // The pool to add waiting players too, 0 for A, 1 for B
-// var waitingToJoinPoolA []*playerMatchmakingData
-// var waitingToJoinPoolB []*playerMatchmakingData
-// var matchmakingPool []*playerMatchmakingData
-// var awaitingRemoval = RemovalMap{awaitingRemoval: make(map[int64]bool)}
-// var pendingRemovalRequests []int // Array of playerIDs
+// Wakes the service, a wake that is already pending covers this one
+func (m *Matchmaker) signal() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
 
 const defaultMatchmakingThreshold = 400
 
-func addPlayerToWaitingPool(playerID int64, timeFormatInMilliseconds int64, incrementInMilliseconds int64) {
-	var key string = fmt.Sprintf("%v + %v", timeFormatInMilliseconds, incrementInMilliseconds)
-	queue, ok := queueMap[key]
-	if !ok {
-		addNewQueue(timeFormatInMilliseconds, incrementInMilliseconds)
-		queue = queueMap[key]
+// Two deviations either side covers the player's true rating with 95% confidence
+func initialMatchmakingThreshold(deviation float64) int64 {
+	return max(defaultMatchmakingThreshold, int64(2*deviation))
+}
+
+// Joining a queue the player is already in only updates their colour and maxRatingGap
+func (m *Matchmaker) join(playerID int64, timeFormatInMilliseconds int64, incrementInMilliseconds int64, rated bool, colour colourPreference, maxRatingGap int64) {
+	var playerRating = getPlayerRating(playerID, timeFormatInMilliseconds)
+	var threshold = initialMatchmakingThreshold(playerRating.Deviation)
+	var recentOpponents, blocked = getPairingHistory(playerID)
+
+	m.mu.Lock()
+	queue := m.getOrCreateQueue(timeFormatInMilliseconds, incrementInMilliseconds, rated)
+	if existing, ok := queue.players[playerID]; ok {
+		existing.colour = colour
+		existing.maxRatingGap = maxRatingGap
+	} else {
+		queue.players[playerID] = &playerMatchmakingData{
+			playerID:             playerID,
+			elo:                  playerRating.RoundedRating(),
+			deviation:            playerRating.Deviation,
+			matchmakingThreshold: threshold,
+			initialThreshold:     threshold,
+			maxRatingGap:         maxRatingGap,
+			joinedAt:             time.Now(),
+			recentOpponents:      recentOpponents,
+			blocked:              blocked,
+			colour:               colour,
+		}
 	}
+	m.mu.Unlock()
 
-	queue.awaitingRemoval.mu.Lock()
-	_, ok = queue.awaitingRemoval.awaitingRemoval[playerID]
+	m.signal()
+}
 
-	if ok {
-		// Player already in queue, remove leave request if it exists
-		queue.awaitingRemoval.awaitingRemoval[playerID] = false
-		queue.awaitingRemoval.mu.Unlock()
-		return
+// Leaves both the rated and the casual queue of the time control
+func (m *Matchmaker) leave(playerID int64, timeFormatInMilliseconds int64, incrementInMilliseconds int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var found bool
+	for _, rated := range []bool{true, false} {
+		queue, ok := m.queues[queueKey(timeFormatInMilliseconds, incrementInMilliseconds, rated)]
+		if ok {
+			found = true
+			delete(queue.players, playerID)
+		}
+	}
+	if !found {
+		app.errorLog.Printf("Queue not found: %v %v\n", timeFormatInMilliseconds, incrementInMilliseconds)
 	}
-	queue.awaitingRemoval.mu.Unlock()
+}
 
-	var pools = []*[]*playerMatchmakingData{queue.waitingToJoinPoolA, queue.waitingToJoinPoolB}
-	var elo int64
+// Stops two queued players who blocked each other after joining from being paired again
+func (m *Matchmaker) recordBlock(playerOne *playerMatchmakingData, playerTwo *playerMatchmakingData) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	playerOne.blocked[playerTwo.playerID] = true
+	playerTwo.blocked[playerOne.playerID] = true
+}
 
-	playerRatings, err := app.userRatings.GetRatingFromPlayerID(playerID)
-	if err != nil {
-		elo = 1500
-	} else {
-		elo = playerRatings.GetRatingForTimeFormat(timeFormatInMilliseconds)
+// Puts players back after their match could not be created, they keep their place
+func (m *Matchmaker) requeue(queue *QueueData, players ...*playerMatchmakingData) {
+	m.mu.Lock()
+	for _, player := range players {
+		if _, ok := queue.players[player.playerID]; !ok {
+			queue.players[player.playerID] = player
+		}
 	}
+	m.mu.Unlock()
 
-	// Should openPool be locked?
-	queue.openPool.mu.Lock()
-	*pools[queue.openPool.openPool] = append(*pools[queue.openPool.openPool],
-		&playerMatchmakingData{
-			playerID:             playerID,
-			elo:                  elo,
-			matchmakingThreshold: defaultMatchmakingThreshold,
-			isMatched:            false,
-		})
+	m.signal()
+}
 
-	queue.openPool.mu.Unlock()
-	// Add playerID to awaitingRemoval map for easier check later
-	queue.awaitingRemoval.mu.Lock()
-	queue.awaitingRemoval.awaitingRemoval[playerID] = false
-	queue.awaitingRemoval.mu.Unlock()
+func addPlayerToWaitingPool(playerID int64, timeFormatInMilliseconds int64, incrementInMilliseconds int64, rated bool, colour colourPreference, maxRatingGap int64) {
+	matchmaker.join(playerID, timeFormatInMilliseconds, incrementInMilliseconds, rated, colour, maxRatingGap)
 }
 
 func removePlayerFromWaitingPool(playerID int64, timeFormatInMilliseconds int64, incrementInMilliseconds int64) {
-	var key string = fmt.Sprintf("%v + %v", timeFormatInMilliseconds, incrementInMilliseconds)
-	queue, ok := queueMap[key]
-	if !ok {
-		app.errorLog.Println("Queue not found")
-		app.errorLog.Println(queue)
-		return
+	matchmaker.leave(playerID, timeFormatInMilliseconds, incrementInMilliseconds)
+}
+
+// Rating in the time class as stored, a new player's rating if it cannot be loaded
+// This is what rating.System.Update expects, it applies the inactivity decay itself
+func getStoredPlayerRating(playerID int64, timeFormatInMilliseconds int64) rating.Player {
+	playerRatings, err := app.userRatings.GetRatingFromPlayerID(playerID)
+	if err != nil {
+		return app.ratingSystem.NewPlayer()
 	}
-	// If value does not exist, then player is not in queue
-	queue.awaitingRemoval.mu.Lock()
-	_, ok = queue.awaitingRemoval.awaitingRemoval[playerID]
-	if ok {
-		queue.awaitingRemoval.awaitingRemoval[playerID] = true
+	return playerRatings.GetRatingForTimeFormat(timeFormatInMilliseconds)
+}
+
+// Rating in the time class as of now with inactivity decay applied, for matchmaking and display
+// Never pass it to rating.System.Update, which would decay it a second time
+func getPlayerRating(playerID int64, timeFormatInMilliseconds int64) rating.Player {
+	return app.ratingSystem.Decay(getStoredPlayerRating(playerID, timeFormatInMilliseconds), time.Now())
+}
+
+// Recent opponents and blocks of the player, empty if they cannot be loaded so the player can still be paired
+func getPairingHistory(playerID int64) (map[int64]time.Time, map[int64]bool) {
+	recentOpponents, err := app.pastMatches.GetRecentOpponents(playerID, time.Now().Add(-app.rematchCooldown))
+	if err != nil {
+		recentOpponents = make(map[int64]time.Time)
+	}
+	blocked, err := app.userBlocks.GetBlockedEitherWay(playerID)
+	if err != nil {
+		blocked = make(map[int64]bool)
+	}
+	return recentOpponents, blocked
+}
+
+// Matchmaking data for a player paired directly, outside of the pool
+func directMatchPlayerData(playerID int64, timeFormatInMilliseconds int64) *playerMatchmakingData {
+	var playerRating = getPlayerRating(playerID, timeFormatInMilliseconds)
+	return &playerMatchmakingData{
+		playerID:  playerID,
+		elo:       playerRating.RoundedRating(),
+		deviation: playerRating.Deviation,
 	}
-	queue.awaitingRemoval.mu.Unlock()
 }
 
 func abs(x int64) int64 {
@@ -162,19 +365,349 @@ func abs(x int64) int64 {
 	return x
 }
 
-func calculateMatchingScore(playerOne *playerMatchmakingData, playerOneIdx int, playerTwo *playerMatchmakingData, playerTwoIdx int) *matchingScore {
+func playedRecently(player *playerMatchmakingData, opponentID int64, now time.Time) bool {
+	lastPlayed, ok := player.recentOpponents[opponentID]
+	return ok && now.Sub(lastPlayed) < app.rematchCooldown
+}
+
+// A recent rematch counts as rematchPenalty more rating apart, so other opponents are preferred
+func calculateMatchingScore(playerOne *playerMatchmakingData, playerTwo *playerMatchmakingData, now time.Time) *matchingScore {
+	var score = abs(playerOne.elo - playerTwo.elo)
+	if playedRecently(playerOne, playerTwo.playerID, now) || playedRecently(playerTwo, playerOne.playerID, now) {
+		score += app.rematchPenalty
+	}
 	return &matchingScore{
-		playerOneID:  playerOne.playerID,
-		playerOneIdx: playerOneIdx,
-		playerTwoID:  playerTwo.playerID,
-		playerTwoIdx: playerTwoIdx,
-		score:        abs(playerOne.elo - playerTwo.elo),
+		playerOne: playerOne,
+		playerTwo: playerTwo,
+		score:     score,
+	}
+}
+
+// Colour for playerOne that gives both players the colour they asked for, random if neither asked
+func pairingColour(playerOne *playerMatchmakingData, playerTwo *playerMatchmakingData) colourPreference {
+	if playerOne.colour != randomColour {
+		return playerOne.colour
+	}
+	return playerTwo.colour.opposite()
+}
+
+// Both players accept the score, so each is only paired inside the search range reported to them
+func canBePaired(playerOne *playerMatchmakingData, playerTwo *playerMatchmakingData, score int64) bool {
+	if playerOne.blocked[playerTwo.playerID] || playerTwo.blocked[playerOne.playerID] {
+		return false
+	}
+	if (playerOne.colour == preferWhite || playerOne.colour == preferBlack) && playerOne.colour == playerTwo.colour {
+		return false
+	}
+	// The search threshold stops at maxRatingGap and the score is never below the rating gap
+	return score <= playerOne.searchThreshold() && score <= playerTwo.searchThreshold()
+}
+
+func (queue *QueueData) recordWait(waited time.Duration) {
+	queue.recentWaits = append(queue.recentWaits, waited)
+	if len(queue.recentWaits) > recentWaitsSize {
+		queue.recentWaits = queue.recentWaits[1:]
+	}
+}
+
+// Median of the recent waits, false with no recent waits
+func (queue *QueueData) medianWait() (time.Duration, bool) {
+	if len(queue.recentWaits) == 0 {
+		return 0, false
+	}
+	waits := append([]time.Duration{}, queue.recentWaits...)
+	sort.Slice(waits, func(i, j int) bool { return waits[i] < waits[j] })
+	return waits[len(waits)/2], true
+}
+
+// Median of the recent waits less the time already waited, nil with no recent waits
+func (queue *QueueData) estimatedWait(waited time.Duration) *int64 {
+	median, ok := queue.medianWait()
+	if !ok {
+		return nil
+	}
+	estimate := max(median-waited, 0).Milliseconds()
+	return &estimate
+}
+
+// The player's search range, if it changed or has not been sent for searchRangeReportInterval
+func (queue *QueueData) searchRangeNotice(player *playerMatchmakingData, now time.Time) (matchmakingNotice, bool) {
+	threshold := player.searchThreshold()
+	if threshold == player.reportedThreshold && now.Sub(player.reportedAt) < searchRangeReportInterval {
+		return matchmakingNotice{}, false
+	}
+	player.reportedThreshold = threshold
+	player.reportedAt = now
+
+	waited := now.Sub(player.joinedAt)
+	data, err := json.Marshal(searchRangeEventData{
+		TimeFormatInMilliseconds: queue.timeFormatInMilliseconds,
+		IncrementInMilliseconds:  queue.incrementInMilliseconds,
+		Rated:                    queue.rated,
+		MinRating:                player.elo - threshold,
+		MaxRating:                player.elo + threshold,
+		WaitedMs:                 waited.Milliseconds(),
+		EstimatedWaitMs:          queue.estimatedWait(waited),
+		WaitingPlayers:           len(queue.players),
+	})
+	if err != nil {
+		app.errorLog.Printf("Error marshalling search range: %v\n", err)
+		return matchmakingNotice{}, false
+	}
+	return matchmakingNotice{playerID: player.playerID, message: sseMessage{event: "searchRange", data: string(data)}}, true
+}
+
+// Pairs the queue's listening players that are not in paired yet and adds them to it
+// Players are sorted by rating and only scored against their nearest neighbours, so a pass is O(n log n)
+func (queue *QueueData) pair(now time.Time, paired map[int64]bool, listening map[int64]bool) []*matchingScore {
+	var players = make([]*playerMatchmakingData, 0, len(queue.players))
+	for _, player := range queue.players {
+		if paired[player.playerID] || !listening[player.playerID] {
+			continue
+		}
+		player.matchmakingThreshold = queue.widening.threshold(player.initialThreshold, now.Sub(player.joinedAt))
+		players = append(players, player)
+	}
+
+	sort.Slice(players, func(i, j int) bool {
+		return players[i].elo < players[j].elo
+	})
+
+	var validMatches = []*matchingScore{}
+	for i, playerOne := range players {
+		for j := i + 1; j < len(players) && j <= i+pairingWindow; j++ {
+			playerTwo := players[j]
+			// Everyone further along is even further away in rating
+			if playerTwo.elo-playerOne.elo > playerOne.searchThreshold() {
+				break
+			}
+
+			matchingScore := calculateMatchingScore(playerOne, playerTwo, now)
+			if canBePaired(playerOne, playerTwo, matchingScore.score) {
+				validMatches = append(validMatches, matchingScore)
+			}
+		}
+	}
+
+	// Closest pairs first
+	sort.Slice(validMatches, func(i, j int) bool {
+		return validMatches[i].score < validMatches[j].score
+	})
+
+	var pairings []*matchingScore
+	for _, score := range validMatches {
+		if paired[score.playerOne.playerID] || paired[score.playerTwo.playerID] {
+			continue
+		}
+		paired[score.playerOne.playerID] = true
+		paired[score.playerTwo.playerID] = true
+		queue.recordWait(now.Sub(score.playerOne.joinedAt))
+		queue.recordWait(now.Sub(score.playerTwo.joinedAt))
+		pairings = append(pairings, score)
+	}
+
+	return pairings
+}
+
+type queuePairing struct {
+	queue *QueueData
+	score *matchingScore
+}
+
+// One matchmaking pass over every queue, returns when the next pass is due, zero with no one waiting
+func (m *Matchmaker) matchPlayers() time.Time {
+	var now = time.Now()
+	var pairings []queuePairing
+	var notices []matchmakingNotice
+	var nextWake time.Time
+
+	m.mu.Lock()
+	var listening = make(map[int64]bool)
+	for _, queue := range m.queues {
+		for playerID := range queue.players {
+			if _, ok := listening[playerID]; !ok {
+				listening[playerID] = clients.isListening(playerID)
+			}
+		}
 	}
+
+	var paired = make(map[int64]bool)
+	for _, queue := range m.queues {
+		for _, score := range queue.pair(now, paired, listening) {
+			pairings = append(pairings, queuePairing{queue: queue, score: score})
+		}
+	}
+
+	for _, queue := range m.queues {
+		for playerID, player := range queue.players {
+			// A player paired in one queue leaves all of them
+			if paired[playerID] {
+				delete(queue.players, playerID)
+				continue
+			}
+
+			if !listening[playerID] {
+				listenDeadline := player.joinedAt.Add(queueListenTimeout)
+				if !now.Before(listenDeadline) {
+					app.infoLog.Printf("Dropping playerID %v from queue %v, not listening for matches\n", playerID, queueKey(queue.timeFormatInMilliseconds, queue.incrementInMilliseconds, queue.rated))
+					delete(queue.players, playerID)
+					continue
+				}
+				if nextWake.IsZero() || listenDeadline.Before(nextWake) {
+					nextWake = listenDeadline
+				}
+			}
+
+			if notice, ok := queue.searchRangeNotice(player, now); ok {
+				notices = append(notices, notice)
+			}
+
+			widens := queue.widening.nextWidening(player.initialThreshold, player.joinedAt, now)
+			if !widens.IsZero() && (nextWake.IsZero() || widens.Before(nextWake)) {
+				nextWake = widens
+			}
+
+			reportDue := player.reportedAt.Add(searchRangeReportInterval)
+			if nextWake.IsZero() || reportDue.Before(nextWake) {
+				nextWake = reportDue
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	for _, notice := range notices {
+		clients.send(notice.playerID, notice.message)
+	}
+
+	for _, pairing := range pairings {
+		queue := pairing.queue
+		playerOne := pairing.score.playerOne
+		playerTwo := pairing.score.playerTwo
+
+		// A player who left since the pass is not paired, the other keeps their place
+		if listeners := stillListening(playerOne, playerTwo); len(listeners) < 2 {
+			m.requeue(queue, listeners...)
+			continue
+		}
+
+		// Blocks are loaded on join, one made since then is only seen here
+		isBlocked, err := app.userBlocks.IsBlockedEitherWay(playerOne.playerID, playerTwo.playerID)
+		if err != nil {
+			app.errorLog.Println(err)
+			m.requeue(queue, playerOne, playerTwo)
+			continue
+		}
+		if isBlocked {
+			m.recordBlock(playerOne, playerTwo)
+			m.requeue(queue, playerOne, playerTwo)
+			continue
+		}
+
+		// Players are only told once both are known to still be listening
+		matchID, err := insertMatch(playerOne, playerTwo, queue.timeFormatInMilliseconds, queue.incrementInMilliseconds, pairingColour(playerOne, playerTwo), queue.rated)
+		if err != nil {
+			app.errorLog.Println(err)
+			m.requeue(queue, playerOne, playerTwo)
+			continue
+		}
+
+		// Nobody would play for a player who left while the match was inserted,
+		// the one still listening never hears of it and keeps their place in the queue
+		if listeners := stillListening(playerOne, playerTwo); len(listeners) < 2 {
+			app.infoLog.Printf("Aborting match %v, a player stopped listening\n", matchID)
+			err := matchRoomHubManager.abortUnstartedMatch(matchID)
+			if err != nil {
+				app.errorLog.Printf("Error aborting match %v: %v\n", matchID, err)
+				continue
+			}
+			m.requeue(queue, listeners...)
+			continue
+		}
+
+		notifyMatchFound(matchID, queue.timeFormatInMilliseconds, queue.incrementInMilliseconds, playerOne.playerID, playerTwo.playerID)
+	}
+
+	return nextWake
+}
+
+// Every queue, ordered by time control with rated before casual
+func (m *Matchmaker) status() []queueStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	output := make([]queueStatus, 0, len(m.queues))
+	for _, queue := range m.queues {
+		status := queueStatus{
+			TimeFormatInMilliseconds: queue.timeFormatInMilliseconds,
+			IncrementInMilliseconds:  queue.incrementInMilliseconds,
+			Rated:                    queue.rated,
+			WaitingPlayers:           len(queue.players),
+		}
+
+		if median, ok := queue.medianWait(); ok {
+			medianMs := median.Milliseconds()
+			status.MedianWaitMs = &medianMs
+		}
+
+		if len(queue.players) > 0 {
+			var minRating, maxRating int64 = math.MaxInt64, math.MinInt64
+			for _, player := range queue.players {
+				minRating = min(minRating, player.elo)
+				maxRating = max(maxRating, player.elo)
+			}
+			status.MinRating = &minRating
+			status.MaxRating = &maxRating
+		}
+
+		output = append(output, status)
+	}
+
+	sort.Slice(output, func(i, j int) bool {
+		if output[i].TimeFormatInMilliseconds != output[j].TimeFormatInMilliseconds {
+			return output[i].TimeFormatInMilliseconds < output[j].TimeFormatInMilliseconds
+		}
+		if output[i].IncrementInMilliseconds != output[j].IncrementInMilliseconds {
+			return output[i].IncrementInMilliseconds < output[j].IncrementInMilliseconds
+		}
+		return output[i].Rated && !output[j].Rated
+	})
+	return output
 }
 
-func swapRemove[T any](arr []T, idx int) []T {
-	arr[idx] = arr[len(arr)-1]
-	return arr[:len(arr)-1]
+// The players that still have a stream open
+func stillListening(players ...*playerMatchmakingData) []*playerMatchmakingData {
+	var listeners []*playerMatchmakingData
+	for _, player := range players {
+		if clients.isListening(player.playerID) {
+			listeners = append(listeners, player)
+		}
+	}
+	return listeners
+}
+
+func matchmakingService() {
+	app.infoLog.Printf("Starting matchmakingService")
+	defer app.infoLog.Printf("Ending matchmakingService")
+
+	timer := time.NewTimer(idleMatchmakingWake)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-matchmaker.wake:
+		case <-timer.C:
+		}
+
+		start := time.Now()
+		nextWake := matchmaker.matchPlayers()
+		app.perfLog.Printf("matchPlayers took: %s\n", time.Since(start))
+
+		if nextWake.IsZero() {
+			timer.Reset(idleMatchmakingWake)
+		} else {
+			timer.Reset(max(time.Until(nextWake), 0))
+		}
+	}
 }
 
 func startingMatchHistory(timeFormatInMilliseconds int64) ([]byte, error) {
@@ -209,174 +742,105 @@ func startingMatchHistory(timeFormatInMilliseconds int64) ([]byte, error) {
 	return jsonStr, nil
 }
 
-func createMatch(playerOneData *playerMatchmakingData, playerTwoData *playerMatchmakingData, timeFormatInMilliseconds int64, incrementInMilliseconds int64) error {
-	playerOneID := playerOneData.playerID
-	playerTwoID := playerTwoData.playerID
-
-	playerOneIsWhite := rand.Intn(2) == 1
-
-	var whitePlayerData, blackPlayerData *playerMatchmakingData
-	if playerOneIsWhite {
-		whitePlayerData = playerOneData
-		blackPlayerData = playerTwoData
-	} else {
-		whitePlayerData = playerTwoData
-		blackPlayerData = playerOneData
-	}
+// Inserts a live match with the given colours, returns the new matchID
+func insertNewMatch(whitePlayerData *playerMatchmakingData, blackPlayerData *playerMatchmakingData, timeFormatInMilliseconds int64, incrementInMilliseconds int64, rated bool) (int64, error) {
 	startingHistory, err := startingMatchHistory(timeFormatInMilliseconds)
 	if err != nil {
 		app.errorLog.Printf("Error creating starting history for new match: %v\n", err)
-		return err
+		return 0, err
 	}
 
-	var averageElo float64 = (float64(playerOneData.elo) + float64(playerTwoData.elo)) / 2
+	var averageElo float64 = (float64(whitePlayerData.elo) + float64(blackPlayerData.elo)) / 2
 
-	var matchID int64
-	matchID, err = app.liveMatches.EnQueueReturnInsertNew(playerOneID, playerTwoID, playerOneIsWhite, timeFormatInMilliseconds, incrementInMilliseconds, startingHistory, averageElo, whitePlayerData.elo, blackPlayerData.elo, nil, nil)
+	matchID, err := app.liveMatches.EnQueueReturnInsertNew(whitePlayerData.playerID, blackPlayerData.playerID, true, timeFormatInMilliseconds, incrementInMilliseconds, startingHistory, averageElo, whitePlayerData.elo, blackPlayerData.elo, rated, nil, nil)
 	if err != nil {
 		app.errorLog.Printf("Error inserting new match: %v\n", err)
-		return err
-	}
-
-	clients.mu.Lock()
-	defer clients.mu.Unlock()
-	var ok bool
-
-	_, ok = clients.clients[playerOneID]
-	if !ok {
-		clients.clients[playerOneID] = &Client{id: playerOneID, channel: make(chan string, 1)}
+		return 0, err
 	}
+	matchRoomHubManager.scheduleAbort(matchID, app.abortTimeout)
 
-	clients.clients[playerOneID].channel <- fmt.Sprintf("%v,%v,%v", matchID, timeFormatInMilliseconds, incrementInMilliseconds)
-
-	_, ok = clients.clients[playerTwoID]
-	if !ok {
-		clients.clients[playerTwoID] = &Client{id: playerTwoID, channel: make(chan string, 1)}
-	}
-	clients.clients[playerTwoID].channel <- fmt.Sprintf("%v,%v,%v", matchID, timeFormatInMilliseconds, incrementInMilliseconds)
-
-	// close(clients.clients[playerOneID].channel)
-	// close(clients.clients[playerTwoID].channel)
-	return nil
+	return matchID, nil
 }
 
-func matchPlayers() {
-	// Merge Waiting Pool with Real Pool
-	// Go through all players
-	// Score all players against all other players
-	// If score below threshold for both players, add to list
-	// Order valid scores
-	// Go through all scores
-	// If both players not matched, match them
-	// Go through pool in reverse, if a player has been matched, swap remove them
-
-	for _, queue := range queueMap {
-
-		var timeFormatInMilliseconds = queue.timeFormatInMilliseconds
-		var incrementInMilliseconds = queue.incrementInMilliseconds
-
-		// Lock to change pool
-		var poolToEmpty int
-		queue.openPool.mu.Lock()
-		if queue.openPool.openPool == 0 {
-			queue.openPool.openPool = 1
-			poolToEmpty = 0
-		} else {
-			queue.openPool.openPool = 0
-			poolToEmpty = 1
-		}
-		queue.openPool.mu.Unlock()
+type colourPreference string
 
-		// Empty the pool
-		var pools = []*[]*playerMatchmakingData{queue.waitingToJoinPoolA, queue.waitingToJoinPoolB}
-		*queue.matchmakingPool = append(*queue.matchmakingPool, *pools[poolToEmpty]...)
-		*pools[poolToEmpty] = []*playerMatchmakingData{}
+const (
+	preferWhite  colourPreference = "white"
+	preferBlack  colourPreference = "black"
+	randomColour colourPreference = "random"
+)
 
-		var validMatches = []*matchingScore{}
+func (c colourPreference) isValid() bool {
+	return c == preferWhite || c == preferBlack || c == randomColour
+}
 
-		// Should we sort validMatches first?
+func (c colourPreference) opposite() colourPreference {
+	switch c {
+	case preferWhite:
+		return preferBlack
+	case preferBlack:
+		return preferWhite
+	default:
+		return randomColour
+	}
+}
 
-		// Score players against all other players
-		for playerOneIdx, playerOne := range *queue.matchmakingPool {
-			for playerTwoIdx, playerTwo := range (*queue.matchmakingPool)[playerOneIdx+1:] {
-				// playerTwoIdx starts from 0
-				playerTwoIdx += playerOneIdx + 1
-				matchingScore := calculateMatchingScore(playerOne, playerOneIdx, playerTwo, playerTwoIdx)
+// Number of a player's latest matches their colour balance is counted over
+const colourHistoryGames = 10
 
-				// Allows players with high threshold to find matches easier
-				if matchingScore.score*2 <= playerOne.matchmakingThreshold+playerTwo.matchmakingThreshold {
-					validMatches = append(validMatches, matchingScore)
-				}
-			}
+// True if playerOne should be white, the player who had white less often gets it when balanceColours is set
+func assignWhite(playerOneID int64, playerTwoID int64) bool {
+	if app.balanceColours {
+		playerOneBalance, errOne := app.pastMatches.GetColourBalance(playerOneID, colourHistoryGames)
+		playerTwoBalance, errTwo := app.pastMatches.GetColourBalance(playerTwoID, colourHistoryGames)
+		if errOne == nil && errTwo == nil && playerOneBalance != playerTwoBalance {
+			return playerOneBalance < playerTwoBalance
 		}
+	}
+	return rand.Intn(2) == 1
+}
 
-		// Sort Scores
-		sort.Slice(validMatches, func(i, j int) bool {
-			return validMatches[i].score < validMatches[j].score
-		})
-
-		// Go through all scores
-		for _, score := range validMatches {
-			playerOne := (*queue.matchmakingPool)[score.playerOneIdx]
-			playerTwo := (*queue.matchmakingPool)[score.playerTwoIdx]
-
-			queue.awaitingRemoval.mu.Lock()
-			if playerOne.isMatched || queue.awaitingRemoval.awaitingRemoval[playerOne.playerID] {
-				queue.awaitingRemoval.mu.Unlock()
-				continue
-			}
+// Inserts the match without telling the players, returns the new matchID
+func insertMatch(playerOneData *playerMatchmakingData, playerTwoData *playerMatchmakingData, timeFormatInMilliseconds int64, incrementInMilliseconds int64, playerOneColour colourPreference, rated bool) (int64, error) {
+	playerOneID := playerOneData.playerID
+	playerTwoID := playerTwoData.playerID
 
-			if playerTwo.isMatched || queue.awaitingRemoval.awaitingRemoval[playerTwo.playerID] {
-				queue.awaitingRemoval.mu.Unlock()
-				continue
-			}
+	var playerOneIsWhite bool
+	switch playerOneColour {
+	case preferWhite:
+		playerOneIsWhite = true
+	case preferBlack:
+		playerOneIsWhite = false
+	default:
+		playerOneIsWhite = assignWhite(playerOneID, playerTwoID)
+	}
 
-			queue.awaitingRemoval.mu.Unlock()
-			// Match players
-			err := createMatch(playerOne, playerTwo, timeFormatInMilliseconds, incrementInMilliseconds)
-			if err != nil {
-				app.errorLog.Println(err)
-				continue
-			}
-			playerOne.isMatched = true
-			playerTwo.isMatched = true
+	var whitePlayerData, blackPlayerData *playerMatchmakingData
+	if playerOneIsWhite {
+		whitePlayerData = playerOneData
+		blackPlayerData = playerTwoData
+	} else {
+		whitePlayerData = playerTwoData
+		blackPlayerData = playerOneData
+	}
 
-		}
+	return insertNewMatch(whitePlayerData, blackPlayerData, timeFormatInMilliseconds, incrementInMilliseconds, rated)
+}
 
-		// Cleanup pool
-		for i := len(*queue.matchmakingPool) - 1; i >= 0; i-- {
-			player := (*queue.matchmakingPool)[i]
-			// @TODO
-			// Is it possible for an awaitingRemoval key to be deleted whilst the player is in a waiting pool?
-			queue.awaitingRemoval.mu.Lock()
-			if player.isMatched || queue.awaitingRemoval.awaitingRemoval[player.playerID] {
-				*queue.matchmakingPool = swapRemove(*queue.matchmakingPool, i)
-				delete(queue.awaitingRemoval.awaitingRemoval, player.playerID)
-			}
-			queue.awaitingRemoval.mu.Unlock()
-		}
+// Match found is the default event
+func notifyMatchFound(matchID int64, timeFormatInMilliseconds int64, incrementInMilliseconds int64, playerIDs ...int64) {
+	var matchFound = sseMessage{data: fmt.Sprintf("%v,%v,%v", matchID, timeFormatInMilliseconds, incrementInMilliseconds)}
+	for _, playerID := range playerIDs {
+		clients.send(playerID, matchFound)
 	}
 }
 
-func matchmakingService() {
-	iterations := 0
-	app.infoLog.Printf("Starting matchmakingService")
-	defer app.infoLog.Printf("Ending matchmakingService")
-	logMatchmaking := false
-	for {
-		// Could use time.After to ensure service runs every 500ms
-		if logMatchmaking && iterations%20 == 0 {
-			for key, queue := range queueMap {
-				app.infoLog.Println(key)
-				app.infoLog.Printf("Matching iteration: %v\n", iterations)
-				app.infoLog.Println(queue.waitingToJoinPoolA)
-				app.infoLog.Println(queue.waitingToJoinPoolB)
-				app.infoLog.Println(queue.awaitingRemoval.awaitingRemoval)
-				app.infoLog.Println(queue.matchmakingPool)
-			}
-		}
-		matchPlayers()
-		time.Sleep(500 * time.Millisecond)
-		iterations += 1
+// Inserts the match and tells both players over SSE, returns the new matchID
+func createMatch(playerOneData *playerMatchmakingData, playerTwoData *playerMatchmakingData, timeFormatInMilliseconds int64, incrementInMilliseconds int64, playerOneColour colourPreference, rated bool) (int64, error) {
+	matchID, err := insertMatch(playerOneData, playerTwoData, timeFormatInMilliseconds, incrementInMilliseconds, playerOneColour, rated)
+	if err != nil {
+		return 0, err
 	}
+	notifyMatchFound(matchID, timeFormatInMilliseconds, incrementInMilliseconds, playerOneData.playerID, playerTwoData.playerID)
+	return matchID, nil
 }
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"burrchess/internal/models"
+	"burrchess/internal/rating"
+	"database/sql"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Matchmaking reads ratings, recent opponents and blocks, so the tests run against an empty in-memory database
+func TestMain(m *testing.M) {
+	db, err := sql.Open("sqlite", "file:matchmaking_test?mode=memory&cache=shared")
+	if err != nil {
+		log.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+
+	schema, err := os.ReadFile("../../internal/models/schema.sql")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(string(schema))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var discard = log.New(io.Discard, "", 0)
+	app = &application{
+		errorLog:        discard,
+		infoLog:         discard,
+		perfLog:         discard,
+		debugLog:        discard,
+		liveMatches:     &models.LiveMatchModel{DB: db},
+		pastMatches:     &models.PastMatchModel{DB: db},
+		userRatings:     &models.UserRatingsModel{DB: db},
+		userBlocks:      &models.UserBlockModel{DB: db},
+		dbTaskQueue:     models.DBTaskQueue,
+		ratingSystem:    rating.DefaultGlicko2(),
+		queueWidening:   make(map[string]thresholdWidening),
+		abortTimeout:    time.Minute,
+		rematchCooldown: 10 * time.Minute,
+		rematchPenalty:  300,
+		matchmakingWidening: thresholdWidening{
+			step:     50,
+			interval: 5 * time.Second,
+			max:      1000,
+		},
+	}
+
+	os.Exit(m.Run())
+}
+
+func newTestPlayer(playerID int64, elo int64, threshold int64) *playerMatchmakingData {
+	return &playerMatchmakingData{
+		playerID:             playerID,
+		elo:                  elo,
+		matchmakingThreshold: threshold,
+		initialThreshold:     threshold,
+		joinedAt:             time.Now(),
+		recentOpponents:      make(map[int64]time.Time),
+		blocked:              make(map[int64]bool),
+		colour:               randomColour,
+	}
+}
+
+func TestThresholdWidening(t *testing.T) {
+	var widening = thresholdWidening{step: 50, interval: 5 * time.Second, max: 600}
+	var tests = []struct {
+		name      string
+		widening  thresholdWidening
+		initial   int64
+		waited    time.Duration
+		threshold int64
+	}{
+		{name: "just joined", widening: widening, initial: 400, waited: 0, threshold: 400},
+		{name: "part of an interval", widening: widening, initial: 400, waited: 4 * time.Second, threshold: 400},
+		{name: "two intervals", widening: widening, initial: 400, waited: 10 * time.Second, threshold: 500},
+		{name: "capped at max", widening: widening, initial: 400, waited: time.Hour, threshold: 600},
+		{name: "start above max", widening: widening, initial: 800, waited: time.Hour, threshold: 800},
+		{name: "no step", widening: thresholdWidening{step: 0, interval: time.Second, max: 600}, initial: 400, waited: time.Hour, threshold: 400},
+		{name: "no interval", widening: thresholdWidening{step: 50, interval: 0, max: 600}, initial: 400, waited: time.Hour, threshold: 400},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.widening.threshold(test.initial, test.waited); got != test.threshold {
+				t.Errorf("threshold(%v, %v) = %v, want %v", test.initial, test.waited, got, test.threshold)
+			}
+		})
+	}
+}
+
+func TestNextWidening(t *testing.T) {
+	var widening = thresholdWidening{step: 50, interval: 5 * time.Second, max: 600}
+	var joinedAt = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if got, want := widening.nextWidening(400, joinedAt, joinedAt.Add(7*time.Second)), joinedAt.Add(10*time.Second); !got.Equal(want) {
+		t.Errorf("next widening %v, want %v", got, want)
+	}
+	if got := widening.nextWidening(400, joinedAt, joinedAt.Add(time.Hour)); !got.IsZero() {
+		t.Errorf("capped threshold widens again at %v", got)
+	}
+	if got := widening.nextWidening(800, joinedAt, joinedAt); !got.IsZero() {
+		t.Errorf("threshold that started above max widens at %v", got)
+	}
+}
+
+func TestParseQueueWidening(t *testing.T) {
+	var widenings = make(map[string]thresholdWidening)
+	err := parseQueueWidening("60000+0=100/3s/1500, 600000+5000=25/10s/600", widenings)
+	if err != nil {
+		t.Fatalf("parseQueueWidening: %v", err)
+	}
+	if got, want := widenings[timeControlKey(60000, 0)], (thresholdWidening{step: 100, interval: 3 * time.Second, max: 1500}); got != want {
+		t.Errorf("bullet widening %+v, want %+v", got, want)
+	}
+	if got, want := widenings[timeControlKey(600000, 5000)], (thresholdWidening{step: 25, interval: 10 * time.Second, max: 600}); got != want {
+		t.Errorf("rapid widening %+v, want %+v", got, want)
+	}
+
+	var malformed = []struct {
+		name string
+		spec string
+	}{
+		{name: "missing equals", spec: "60000+0 100/3s/1500"},
+		{name: "bad time control", spec: "bullet=100/3s/1500"},
+		{name: "too few fields", spec: "60000+0=100/3s"},
+		{name: "too many fields", spec: "60000+0=100/3s/1500/1"},
+		{name: "bad step", spec: "60000+0=wide/3s/1500"},
+		{name: "bad interval", spec: "60000+0=100/3/1500"},
+		{name: "bad max", spec: "60000+0=100/3s/all"},
+		{name: "one bad entry", spec: "60000+0=100/3s/1500,600000+5000"},
+		{name: "empty", spec: ""},
+	}
+	for _, test := range malformed {
+		t.Run(test.name, func(t *testing.T) {
+			if err := parseQueueWidening(test.spec, make(map[string]thresholdWidening)); err == nil {
+				t.Errorf("parseQueueWidening(%q) accepted a malformed spec", test.spec)
+			}
+		})
+	}
+}
+
+func TestCanBePaired(t *testing.T) {
+	var tests = []struct {
+		name    string
+		setup   func(playerOne *playerMatchmakingData, playerTwo *playerMatchmakingData)
+		eloTwo  int64
+		canPair bool
+	}{
+		{name: "close ratings", eloTwo: 1600, canPair: true},
+		{name: "outside both thresholds", eloTwo: 2000, canPair: false},
+		{
+			name:   "wide threshold does not make up for a narrow one",
+			eloTwo: 2100,
+			setup: func(playerOne *playerMatchmakingData, playerTwo *playerMatchmakingData) {
+				playerTwo.matchmakingThreshold = 800
+			},
+			canPair: false,
+		},
+		{
+			name:   "blocked by player one",
+			eloTwo: 1600,
+			setup: func(playerOne *playerMatchmakingData, playerTwo *playerMatchmakingData) {
+				playerOne.blocked[playerTwo.playerID] = true
+			},
+			canPair: false,
+		},
+		{
+			name:   "blocked by player two",
+			eloTwo: 1600,
+			setup: func(playerOne *playerMatchmakingData, playerTwo *playerMatchmakingData) {
+				playerTwo.blocked[playerOne.playerID] = true
+			},
+			canPair: false,
+		},
+		{
+			name:   "both want white",
+			eloTwo: 1600,
+			setup: func(playerOne *playerMatchmakingData, playerTwo *playerMatchmakingData) {
+				playerOne.colour, playerTwo.colour = preferWhite, preferWhite
+			},
+			canPair: false,
+		},
+		{
+			name:   "both want black",
+			eloTwo: 1600,
+			setup: func(playerOne *playerMatchmakingData, playerTwo *playerMatchmakingData) {
+				playerOne.colour, playerTwo.colour = preferBlack, preferBlack
+			},
+			canPair: false,
+		},
+		{
+			name:   "white and black",
+			eloTwo: 1600,
+			setup: func(playerOne *playerMatchmakingData, playerTwo *playerMatchmakingData) {
+				playerOne.colour, playerTwo.colour = preferWhite, preferBlack
+			},
+			canPair: true,
+		},
+		{
+			name:   "white and random",
+			eloTwo: 1600,
+			setup: func(playerOne *playerMatchmakingData, playerTwo *playerMatchmakingData) {
+				playerOne.colour = preferWhite
+			},
+			canPair: true,
+		},
+		{
+			name:    "past player one's maxRatingGap",
+			eloTwo:  1600,
+			setup:   func(playerOne *playerMatchmakingData, playerTwo *playerMatchmakingData) { playerOne.maxRatingGap = 50 },
+			canPair: false,
+		},
+		{
+			name:    "past player two's maxRatingGap",
+			eloTwo:  1600,
+			setup:   func(playerOne *playerMatchmakingData, playerTwo *playerMatchmakingData) { playerTwo.maxRatingGap = 50 },
+			canPair: false,
+		},
+		{
+			name:   "maxRatingGap is not widened by the other threshold",
+			eloTwo: 1700,
+			setup: func(playerOne *playerMatchmakingData, playerTwo *playerMatchmakingData) {
+				playerOne.maxRatingGap = 150
+				playerTwo.matchmakingThreshold = 1000
+			},
+			canPair: false,
+		},
+		{
+			name:    "within maxRatingGap",
+			eloTwo:  1600,
+			setup:   func(playerOne *playerMatchmakingData, playerTwo *playerMatchmakingData) { playerOne.maxRatingGap = 100 },
+			canPair: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			playerOne := newTestPlayer(1, 1500, 400)
+			playerTwo := newTestPlayer(2, test.eloTwo, 400)
+			if test.setup != nil {
+				test.setup(playerOne, playerTwo)
+			}
+
+			score := calculateMatchingScore(playerOne, playerTwo, time.Now())
+			if got := canBePaired(playerOne, playerTwo, score.score); got != test.canPair {
+				t.Errorf("canBePaired = %v, want %v", got, test.canPair)
+			}
+		})
+	}
+}
+
+func newTestQueue(players ...*playerMatchmakingData) (*QueueData, map[int64]bool) {
+	var queue = &QueueData{players: make(map[int64]*playerMatchmakingData)}
+	var listening = make(map[int64]bool)
+	for _, player := range players {
+		queue.players[player.playerID] = player
+		listening[player.playerID] = true
+	}
+	return queue, listening
+}
+
+func pairedIDs(pairings []*matchingScore) [][2]int64 {
+	var output [][2]int64
+	for _, pairing := range pairings {
+		output = append(output, [2]int64{pairing.playerOne.playerID, pairing.playerTwo.playerID})
+	}
+	return output
+}
+
+func TestPairRematchPenalty(t *testing.T) {
+	var now = time.Now()
+	var tests = []struct {
+		name        string
+		lastPlayed  time.Time // Of players 1 and 2, zero if they have not played
+		thirdElo    int64
+		wantPairing [2]int64
+	}{
+		{name: "no recent match", thirdElo: 1600, wantPairing: [2]int64{1, 2}},
+		// 1 and 2 score 10 + rematchPenalty, 2 and 3 are 90 apart
+		{name: "recent match", lastPlayed: now.Add(-time.Minute), thirdElo: 1600, wantPairing: [2]int64{2, 3}},
+		{name: "match before the cooldown", lastPlayed: now.Add(-time.Hour), thirdElo: 1600, wantPairing: [2]int64{1, 2}},
+		// The penalty only reorders, with no one else in range the rematch is still paired
+		{name: "recent match, no one else in range", lastPlayed: now.Add(-time.Minute), thirdElo: 3000, wantPairing: [2]int64{1, 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			playerOne := newTestPlayer(1, 1500, 400)
+			playerTwo := newTestPlayer(2, 1510, 400)
+			playerThree := newTestPlayer(3, test.thirdElo, 400)
+			if !test.lastPlayed.IsZero() {
+				playerOne.recentOpponents[2] = test.lastPlayed
+			}
+
+			queue, listening := newTestQueue(playerOne, playerTwo, playerThree)
+			got := pairedIDs(queue.pair(now, make(map[int64]bool), listening))
+			if len(got) != 1 || got[0] != test.wantPairing {
+				t.Errorf("pairings %v, want [%v]", got, test.wantPairing)
+			}
+		})
+	}
+}
+
+func TestPairEarlyBreak(t *testing.T) {
+	// Player one is out of player two's narrow range, the pass must keep looking past player two to find player three
+	playerOne := newTestPlayer(1, 1000, 400)
+	playerTwo := newTestPlayer(2, 1150, 100)
+	playerThree := newTestPlayer(3, 1300, 400)
+
+	queue, listening := newTestQueue(playerOne, playerTwo, playerThree)
+	got := pairedIDs(queue.pair(time.Now(), make(map[int64]bool), listening))
+	if len(got) != 1 || got[0] != [2]int64{1, 3} {
+		t.Errorf("pairings %v, want [[1 3]]", got)
+	}
+}
+
+func TestPairSkipsPairedAndNotListening(t *testing.T) {
+	playerOne := newTestPlayer(1, 1500, 400)
+	playerTwo := newTestPlayer(2, 1510, 400)
+	playerThree := newTestPlayer(3, 1520, 400)
+	playerFour := newTestPlayer(4, 1530, 400)
+
+	queue, listening := newTestQueue(playerOne, playerTwo, playerThree, playerFour)
+	listening[2] = false
+	var paired = map[int64]bool{3: true}
+
+	got := pairedIDs(queue.pair(time.Now(), paired, listening))
+	if len(got) != 1 || got[0] != [2]int64{1, 4} {
+		t.Errorf("pairings %v, want [[1 4]]", got)
+	}
+}
+
+// Run with -race, joins, leaves, stream changes and passes of the service share the queues and the clients
+func TestMatchmakerConcurrency(t *testing.T) {
+	var m = Matchmaker{
+		queues: make(map[string]*QueueData),
+		wake:   make(chan struct{}, 1),
+	}
+	const players = 20 // Even playerIDs listen and get paired, odd ones keep joining and leaving
+	const timeFormatInMilliseconds, incrementInMilliseconds = 180000, 0
+
+	var streams = make(map[int64]chan sseMessage)
+	for i := range players {
+		playerID := int64(1_000_000 + i)
+		if i%2 == 0 {
+			streams[playerID] = clients.openStream(playerID)
+		}
+	}
+	defer func() {
+		for playerID, stream := range streams {
+			clients.closeStream(playerID, stream)
+		}
+	}()
+
+	var done = make(chan struct{})
+	var passes sync.WaitGroup
+	passes.Add(1)
+	go func() {
+		defer passes.Done()
+		for {
+			select {
+			case <-done:
+				return
+			case <-m.wake:
+				m.matchPlayers()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := range players {
+		playerID := int64(1_000_000 + i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				m.join(playerID, timeFormatInMilliseconds, incrementInMilliseconds, true, randomColour, 0)
+				return
+			}
+			for range 5 {
+				m.join(playerID, timeFormatInMilliseconds, incrementInMilliseconds, false, randomColour, 0)
+				m.status()
+				m.leave(playerID, timeFormatInMilliseconds, incrementInMilliseconds)
+			}
+			m.join(playerID, timeFormatInMilliseconds, incrementInMilliseconds, false, randomColour, 0)
+		}()
+	}
+
+	wg.Wait()
+	close(done)
+	passes.Wait()
+	m.matchPlayers()
+
+	for _, status := range m.status() {
+		if status.Rated && status.WaitingPlayers != 0 {
+			t.Errorf("%v listening players still waiting, want 0", status.WaitingPlayers)
+		}
+		if !status.Rated && status.WaitingPlayers != players/2 {
+			t.Errorf("%v players not listening are waiting, want %v", status.WaitingPlayers, players/2)
+		}
+	}
+
+	// Every listening player is told about exactly one match
+	for playerID, stream := range streams {
+		var matchesFound = 0
+		for len(stream) > 0 {
+			if message := <-stream; message.event == "" {
+				matchesFound += 1
+			}
+		}
+		if matchesFound != 1 {
+			t.Errorf("playerID %v found %v matches, want 1", playerID, matchesFound)
+		}
+	}
+}
+
+func TestMatchPlayersBlockAfterJoin(t *testing.T) {
+	var m = Matchmaker{
+		queues: make(map[string]*QueueData),
+		wake:   make(chan struct{}, 1),
+	}
+	const playerOneID, playerTwoID = 2_000_001, 2_000_002
+	const timeFormatInMilliseconds, incrementInMilliseconds = 300000, 0
+
+	var streams = map[int64]chan sseMessage{
+		playerOneID: clients.openStream(playerOneID),
+		playerTwoID: clients.openStream(playerTwoID),
+	}
+	defer func() {
+		for playerID, stream := range streams {
+			clients.closeStream(playerID, stream)
+		}
+	}()
+
+	m.join(playerOneID, timeFormatInMilliseconds, incrementInMilliseconds, false, randomColour, 0)
+	m.join(playerTwoID, timeFormatInMilliseconds, incrementInMilliseconds, false, randomColour, 0)
+	err := app.userBlocks.Block(playerOneID, playerTwoID)
+	if err != nil {
+		t.Fatalf("Block: %v", err)
+	}
+
+	// The second pass pairs from the blocks the first one found
+	for range 2 {
+		m.matchPlayers()
+	}
+
+	for playerID, stream := range streams {
+		for len(stream) > 0 {
+			if message := <-stream; message.event == "" {
+				t.Errorf("playerID %v was paired with a player they blocked", playerID)
+			}
+		}
+	}
+	for _, status := range m.status() {
+		if status.WaitingPlayers != 2 {
+			t.Errorf("%v players waiting, want 2", status.WaitingPlayers)
+		}
+	}
+}
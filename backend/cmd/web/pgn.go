@@ -0,0 +1,84 @@
+package main
+
+import (
+	"burrchess/internal/chess"
+	"burrchess/internal/models"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+func pgnResultFromPastMatch(match *models.PastMatchWithUsernames) string {
+	// result
+	// draw      = 0
+	// whiteWins = 1
+	// blackWins = 2
+	if chess.GameOverStatusCode(match.ResultReason) == chess.Abort {
+		return chess.PGNUnfinished
+	}
+
+	switch match.Result {
+	case 1:
+		return chess.PGNWhiteWins
+	case 2:
+		return chess.PGNBlackWins
+	default:
+		return chess.PGNDraw
+	}
+}
+
+func pgnPlayerName(username string, valid bool) string {
+	if !valid {
+		return "Anonymous"
+	}
+	return username
+}
+
+func buildPastMatchPGN(match *models.PastMatchWithUsernames) (string, error) {
+	var matchStateHistory []MatchStateHistory
+
+	err := json.Unmarshal(match.GameHistoryJSONString, &matchStateHistory)
+	if err != nil {
+		return "", fmt.Errorf("error unmarshalling matchStateHistory: %w", err)
+	}
+
+	game := chess.PGNGame{
+		Tags: []chess.PGNTag{
+			{Name: "Event", Value: "Live Chess"},
+			{Name: "Site", Value: "burrchess"},
+			{Name: "Date", Value: chess.PGNDate(time.Unix(match.MatchStartTime, 0))},
+			{Name: "Round", Value: "-"},
+			{Name: "White", Value: pgnPlayerName(match.WhitePlayerUsername.String, match.WhitePlayerUsername.Valid)},
+			{Name: "Black", Value: pgnPlayerName(match.BlackPlayerUsername.String, match.BlackPlayerUsername.Valid)},
+			{Name: "WhiteElo", Value: fmt.Sprint(match.WhitePlayerElo)},
+			{Name: "BlackElo", Value: fmt.Sprint(match.BlackPlayerElo)},
+			{Name: "TimeControl", Value: chess.PGNTimeControl(match.TimeFormatInMilliseconds, match.IncrementInMilliseconds)},
+			{Name: "Termination", Value: chess.PGNTermination(chess.GameOverStatusCode(match.ResultReason))},
+		},
+		Result: pgnResultFromPastMatch(match),
+	}
+
+	// First entry is the starting position, every later entry is the position after a move
+	for i := 1; i < len(matchStateHistory); i++ {
+		previous := matchStateHistory[i-1]
+		current := matchStateHistory[i]
+
+		san, err := chess.SANFromPositions(previous.FEN, current.LastMove[0], current.LastMove[1], current.FEN)
+		if err != nil {
+			return "", fmt.Errorf("match %v ply %v: %w", match.MatchID, i, err)
+		}
+
+		// Clock of the player who just moved
+		var clockMilliseconds = current.WhitePlayerTimeRemainingMilliseconds
+		if i%2 == 0 {
+			clockMilliseconds = current.BlackPlayerTimeRemainingMilliseconds
+		}
+
+		game.Moves = append(game.Moves, chess.PGNMove{
+			SAN:     san,
+			Comment: chess.PGNClockComment(time.Duration(clockMilliseconds) * time.Millisecond),
+		})
+	}
+
+	return game.String(), nil
+}
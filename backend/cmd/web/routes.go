@@ -39,12 +39,26 @@ func (app *application) routes() http.Handler {
 	mux.Handle("/getAccountSettings", withLogSessionSecureCorsChain(getUserAccountSettingsHandler))
 	mux.Handle("/updateEmail", withLogSessionSecureCorsChain(updateEmailHandler))
 	mux.Handle("/updatePassword", withLogSessionSecureCorsChain(updatePasswordHandler))
+	mux.Handle("/challenges", withLogSessionSecureCorsChain(createChallengeHandler))
+	mux.Handle("/challenges/{challengeID}/{action}", withLogSessionSecureCorsChain(answerChallengeHandler))
+	mux.Handle("/lobby", withLogSessionSecureCorsChain(lobbyHandler))
+	mux.Handle("/lobby/{seekID}", withLogSessionSecureCorsChain(cancelSeekHandler))
+	mux.Handle("/lobby/{seekID}/accept", withLogSessionSecureCorsChain(acceptSeekHandler))
+	mux.Handle("/invites", withLogSessionSecureCorsChain(createInviteHandler))
+	mux.Handle("/invites/{token}", withLogSessionSecureCorsChain(inviteHandler))
+	mux.Handle("/invites/{token}/accept", withLogSessionSecureCorsChain(acceptInviteHandler))
+	mux.Handle("/users/{username}/block", withLogSessionSecureCorsChain(blockUserHandler))
 
+	mux.Handle("/queues", withLogSecureCorsChain(queuesHandler))
 	mux.Handle("/userSearch", withLogSecureCorsChain(userSearchHandler))
 	mux.Handle("/getTileInfo", withLogSecureCorsChain(getTileInfoHandler))
 	mux.Handle("/getPastMatches", withLogSecureCorsChain(getPastMatchesListHandler))
+	mux.Handle("/pastMatches/{matchID}/pgn", withLogSecureCorsChain(getPastMatchPGNHandler))
+	mux.Handle("/pastMatches/{matchID}/chat", withLogSecureCorsChain(getPastMatchChatHandler))
+	mux.Handle("/users/{username}/ratingHistory", withLogSecureCorsChain(getRatingHistoryHandler))
 
 	mux.Handle("/listenformatch", app.logRequest(app.recoverPanic(http.HandlerFunc(matchFoundSSEHandler))))
+	mux.Handle("/lobby/feed", app.logRequest(app.recoverPanic(corsHeaders(sseHeaders(http.HandlerFunc(lobbyFeedSSEHandler))))))
 
 	// Add the pprof routes
 	mux.HandleFunc("/debug/pprof/", pprof.Index)
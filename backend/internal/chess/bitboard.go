@@ -0,0 +1,138 @@
+package chess
+
+import "math/bits"
+
+// Bit i is square i, so bit 0 is a8 and bit 63 is h1, matching the board indices used everywhere else
+
+type bitboard uint64
+
+func squareBitboard(square int) bitboard {
+	return bitboard(1) << square
+}
+
+func (b bitboard) has(square int) bool {
+	return b&squareBitboard(square) != 0
+}
+
+func (b bitboard) count() int {
+	return bits.OnesCount64(uint64(b))
+}
+
+// Lowest set square, only call on a non-empty bitboard
+func (b bitboard) first() int {
+	return bits.TrailingZeros64(uint64(b))
+}
+
+// Highest set square, only call on a non-empty bitboard
+func (b bitboard) last() int {
+	return 63 - bits.LeadingZeros64(uint64(b))
+}
+
+type direction int
+
+const (
+	north direction = iota
+	south
+	east
+	west
+	northEast
+	northWest
+	southEast
+	southWest
+)
+
+var directionSteps = [8][2]int{
+	north:     {-1, 0},
+	south:     {1, 0},
+	east:      {0, 1},
+	west:      {0, -1},
+	northEast: {-1, 1},
+	northWest: {-1, -1},
+	southEast: {1, 1},
+	southWest: {1, -1},
+}
+
+var rookDirections = [4]direction{north, south, east, west}
+var bishopDirections = [4]direction{northEast, northWest, southEast, southWest}
+
+// Rays walking towards higher square indices find their first blocker with first(), the rest with last()
+func (d direction) increasesIndex() bool {
+	return directionSteps[d][0]*8+directionSteps[d][1] > 0
+}
+
+var (
+	knightAttacks [64]bitboard
+	kingAttacks   [64]bitboard
+	pawnAttacks   [2][64]bitboard
+	rays          [8][64]bitboard
+	// Squares strictly between two squares on the same line, empty otherwise
+	between [64][64]bitboard
+)
+
+func isOnBoard(row int, col int) bool {
+	return row >= 0 && row < 8 && col >= 0 && col < 8
+}
+
+func stepAttacks(square int, steps [][2]int) bitboard {
+	var attacks bitboard
+	for _, step := range steps {
+		row, col := getRow(square)+step[0], getCol(square)+step[1]
+		if isOnBoard(row, col) {
+			attacks |= squareBitboard(row*8 + col)
+		}
+	}
+	return attacks
+}
+
+func init() {
+	var knightSteps = [][2]int{{-2, -1}, {-2, 1}, {-1, -2}, {-1, 2}, {1, -2}, {1, 2}, {2, -1}, {2, 1}}
+	var kingSteps = [][2]int{{-1, -1}, {-1, 0}, {-1, 1}, {0, -1}, {0, 1}, {1, -1}, {1, 0}, {1, 1}}
+
+	for square := 0; square < 64; square++ {
+		knightAttacks[square] = stepAttacks(square, knightSteps)
+		kingAttacks[square] = stepAttacks(square, kingSteps)
+		// White pawns move towards row 0
+		pawnAttacks[White][square] = stepAttacks(square, [][2]int{{-1, -1}, {-1, 1}})
+		pawnAttacks[Black][square] = stepAttacks(square, [][2]int{{1, -1}, {1, 1}})
+
+		for d, step := range directionSteps {
+			var ray bitboard
+			row, col := getRow(square)+step[0], getCol(square)+step[1]
+			for isOnBoard(row, col) {
+				target := row*8 + col
+				between[square][target] = ray
+				ray |= squareBitboard(target)
+				row, col = row+step[0], col+step[1]
+			}
+			rays[d][square] = ray
+		}
+	}
+}
+
+// Classical sliding attacks, each ray is cut off behind its first blocker
+func slidingAttacks(square int, occupied bitboard, directions [4]direction) bitboard {
+	var attacks bitboard
+	for _, d := range directions {
+		var ray = rays[d][square]
+		var blockers = ray & occupied
+		if blockers != 0 {
+			var blocker int
+			if d.increasesIndex() {
+				blocker = blockers.first()
+			} else {
+				blocker = blockers.last()
+			}
+			ray ^= rays[d][blocker]
+		}
+		attacks |= ray
+	}
+	return attacks
+}
+
+func bishopAttacks(square int, occupied bitboard) bitboard {
+	return slidingAttacks(square, occupied, bishopDirections)
+}
+
+func rookAttacks(square int, occupied bitboard) bitboard {
+	return slidingAttacks(square, occupied, rookDirections)
+}
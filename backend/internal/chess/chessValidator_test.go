@@ -0,0 +1,175 @@
+package chess
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPositionKey(t *testing.T) {
+	var tests = []struct {
+		name  string
+		fen   string
+		other string
+		same  bool
+	}{
+		{
+			name:  "no pawn can capture en passant",
+			fen:   "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1",
+			other: "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1",
+			same:  true,
+		},
+		{
+			name:  "en passant capture is legal",
+			fen:   "rnbqkbnr/ppp1pppp/8/3pP3/8/8/PPPP1PPP/RNBQKBNR w KQkq d6 0 3",
+			other: "rnbqkbnr/ppp1pppp/8/3pP3/8/8/PPPP1PPP/RNBQKBNR w KQkq - 0 3",
+			same:  false,
+		},
+		{
+			name:  "en passant capture would expose the king",
+			fen:   "8/8/8/K2pP2r/8/8/8/7k w - d6 0 1",
+			other: "8/8/8/K2pP2r/8/8/8/7k w - - 0 1",
+			same:  true,
+		},
+		{
+			name:  "clocks are ignored",
+			fen:   "rnbqkbnr/pppppppp/8/8/8/5N2/PPPPPPPP/RNBQKB1R b KQkq - 1 1",
+			other: "rnbqkbnr/pppppppp/8/8/8/5N2/PPPPPPPP/RNBQKB1R b KQkq - 5 3",
+			same:  true,
+		},
+		{
+			name:  "castling rights count",
+			fen:   "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1",
+			other: "r3k2r/8/8/8/8/8/8/R3K2R w Qkq - 0 1",
+			same:  false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, otherKey := PositionKey(test.fen), PositionKey(test.other)
+			if (key == otherKey) != test.same {
+				t.Errorf("keys %q and %q, want same %v", key, otherKey, test.same)
+			}
+		})
+	}
+}
+
+func TestHalfMoveClock(t *testing.T) {
+	const fen = "4k3/8/8/3pP3/8/7n/8/4K1N1 w - d6 10 30"
+	var tests = []struct {
+		name     string
+		from     string
+		to       string
+		halfMove string
+	}{
+		{name: "quiet piece move", from: "g1", to: "f3", halfMove: "11"},
+		{name: "pawn move", from: "e5", to: "e6", halfMove: "0"},
+		{name: "en passant", from: "e5", to: "d6", halfMove: "0"},
+		{name: "piece capture", from: "g1", to: "h3", halfMove: "0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			from, _ := squareFromAlgebraic(test.from)
+			to, _ := squareFromAlgebraic(test.to)
+			fenAfter, _, _ := GetFENAfterMove(fen, from, to, "")
+			if halfMove := strings.Fields(fenAfter)[4]; halfMove != test.halfMove {
+				t.Errorf("halfmove clock %v after %v%v, want %v", halfMove, test.from, test.to, test.halfMove)
+			}
+		})
+	}
+}
+
+func TestSeventyFiveMoveRule(t *testing.T) {
+	var tests = []struct {
+		name           string
+		fen            string
+		from           string
+		to             string
+		gameOverStatus GameOverStatusCode
+	}{
+		{
+			name:           "one move short",
+			fen:            "4k3/8/8/8/8/8/8/R3K3 w - - 148 100",
+			from:           "a1",
+			to:             "a2",
+			gameOverStatus: Ongoing,
+		},
+		{
+			name:           "seventy-five moves",
+			fen:            "4k3/8/8/8/8/8/8/R3K3 w - - 149 100",
+			from:           "a1",
+			to:             "a2",
+			gameOverStatus: SeventyFiveMoveRule,
+		},
+		{
+			name:           "capture on the last move",
+			fen:            "4k3/8/8/8/8/8/n7/R3K3 w - - 149 100",
+			from:           "a1",
+			to:             "a2",
+			gameOverStatus: Ongoing,
+		},
+		{
+			name:           "checkmate on the last move",
+			fen:            "6k1/5ppp/8/8/8/8/8/R3K3 w - - 149 100",
+			from:           "a1",
+			to:             "a8",
+			gameOverStatus: Checkmate,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			from, _ := squareFromAlgebraic(test.from)
+			to, _ := squareFromAlgebraic(test.to)
+			_, gameOverStatus, _ := GetFENAfterMove(test.fen, from, to, "")
+			if gameOverStatus != test.gameOverStatus {
+				t.Errorf("game over status %v, want %v", gameOverStatus, test.gameOverStatus)
+			}
+		})
+	}
+}
+
+func TestAdjudicateRepetition(t *testing.T) {
+	var tests = []struct {
+		name           string
+		gameOverStatus GameOverStatusCode
+		occurrences    int
+		want           GameOverStatusCode
+	}{
+		{name: "fourth occurrence", gameOverStatus: Ongoing, occurrences: 4, want: Ongoing},
+		{name: "fifth occurrence", gameOverStatus: Ongoing, occurrences: 5, want: FivefoldRepetition},
+		{name: "checkmate takes precedence", gameOverStatus: Checkmate, occurrences: 5, want: Checkmate},
+		{name: "stalemate takes precedence", gameOverStatus: Stalemate, occurrences: 5, want: Stalemate},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := AdjudicateRepetition(test.gameOverStatus, test.occurrences); got != test.want {
+				t.Errorf("AdjudicateRepetition(%v, %v) = %v, want %v", test.gameOverStatus, test.occurrences, got, test.want)
+			}
+		})
+	}
+
+	// Knights out and back four times, the starting position occurs for the fifth time on the last move
+	var fen = StartingFEN
+	var occurrences = map[string]int{PositionKey(fen): 1}
+	var shuffle = [][2]string{{"g1", "f3"}, {"g8", "f6"}, {"f3", "g1"}, {"f6", "g8"}}
+	for round := 1; round <= 4; round++ {
+		for i, squares := range shuffle {
+			from, _ := squareFromAlgebraic(squares[0])
+			to, _ := squareFromAlgebraic(squares[1])
+			var gameOverStatus GameOverStatusCode
+			fen, gameOverStatus, _ = GetFENAfterMove(fen, from, to, "")
+			occurrences[PositionKey(fen)] += 1
+
+			want := GameOverStatusCode(Ongoing)
+			if round == 4 && i == len(shuffle)-1 {
+				want = FivefoldRepetition
+			}
+			if got := AdjudicateRepetition(gameOverStatus, occurrences[PositionKey(fen)]); got != want {
+				t.Fatalf("round %v move %v%v: status %v, want %v", round, squares[0], squares[1], got, want)
+			}
+		}
+	}
+}
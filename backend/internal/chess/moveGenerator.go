@@ -0,0 +1,285 @@
+package chess
+
+// Legal move generation on bitboards, checks and pins are resolved up front so no move has to be tried and undone
+
+type chessMove struct {
+	from      int
+	to        int
+	promotion pieceVariant // Pawn when the move does not promote
+}
+
+var promotionVariants = [4]pieceVariant{Queen, Rook, Bishop, Knight}
+
+func opponent(colour pieceColour) pieceColour {
+	return 1 - colour
+}
+
+func (gs *gameState) allPieces() bitboard {
+	return gs.occupied[White] | gs.occupied[Black]
+}
+
+// Pieces of colour attacking square, sliders are blocked by occupied
+func (gs *gameState) attackers(square int, colour pieceColour, occupied bitboard) bitboard {
+	var pieces = &gs.pieces[colour]
+	var queens = pieces[Queen]
+	return pawnAttacks[opponent(colour)][square]&pieces[Pawn] |
+		knightAttacks[square]&pieces[Knight] |
+		kingAttacks[square]&pieces[King] |
+		bishopAttacks(square, occupied)&(pieces[Bishop]|queens) |
+		rookAttacks(square, occupied)&(pieces[Rook]|queens)
+}
+
+func (gs *gameState) isSquareAttacked(square int, colour pieceColour) bool {
+	return gs.attackers(square, colour, gs.allPieces()) != 0
+}
+
+func (gs *gameState) isInCheck(colour pieceColour) bool {
+	var king = gs.pieces[colour][King]
+	return king != 0 && gs.isSquareAttacked(king.first(), opponent(colour))
+}
+
+func appendPawnMoves(moves []chessMove, from int, to int) []chessMove {
+	if isPromotionSquare(to) {
+		for _, variant := range promotionVariants {
+			moves = append(moves, chessMove{from: from, to: to, promotion: variant})
+		}
+		return moves
+	}
+	return append(moves, chessMove{from: from, to: to, promotion: Pawn})
+}
+
+// Appends the legal moves of the side to move, only for pieces on fromMask
+func (gs *gameState) generateLegalMoves(moves []chessMove, fromMask bitboard) []chessMove {
+	var us = gs.turn
+	var them = opponent(us)
+	var ours = gs.occupied[us]
+	var all = gs.allPieces()
+
+	if gs.pieces[us][King] == 0 {
+		return moves
+	}
+
+	var kingPosition = gs.pieces[us][King].first()
+	var checkers = gs.attackers(kingPosition, them, all)
+
+	/*King moves, the king itself must not block attacks on the squares it moves along*/
+	if fromMask.has(kingPosition) {
+		var withoutKing = all &^ squareBitboard(kingPosition)
+		for targets := kingAttacks[kingPosition] &^ ours; targets != 0; targets &= targets - 1 {
+			to := targets.first()
+			if gs.attackers(to, them, withoutKing) == 0 {
+				moves = append(moves, chessMove{from: kingPosition, to: to, promotion: Pawn})
+			}
+		}
+		if checkers == 0 {
+			moves = gs.appendCastlingMoves(moves, kingPosition)
+		}
+	}
+
+	/*Double check, only the king can move*/
+	if checkers.count() > 1 {
+		return moves
+	}
+
+	/*Single check, must capture the checker or block*/
+	var checkMask = ^bitboard(0)
+	if checkers != 0 {
+		checker := checkers.first()
+		checkMask = between[kingPosition][checker] | squareBitboard(checker)
+	}
+
+	/*Pinned pieces may only move along the line between king and pinner*/
+	var pinned bitboard
+	var pinRays [64]bitboard
+	var enemyRooks = gs.pieces[them][Rook] | gs.pieces[them][Queen]
+	var enemyBishops = gs.pieces[them][Bishop] | gs.pieces[them][Queen]
+	var snipers = rookAttacks(kingPosition, 0)&enemyRooks | bishopAttacks(kingPosition, 0)&enemyBishops
+	for ; snipers != 0; snipers &= snipers - 1 {
+		sniper := snipers.first()
+		blockers := between[kingPosition][sniper] & all
+		if blockers.count() == 1 && blockers&ours != 0 {
+			pinned |= blockers
+			pinRays[blockers.first()] = between[kingPosition][sniper] | squareBitboard(sniper)
+		}
+	}
+
+	var forward = -8
+	var startingRow = 6
+	if us == Black {
+		forward = 8
+		startingRow = 1
+	}
+
+	for pieces := ours & fromMask &^ squareBitboard(kingPosition); pieces != 0; pieces &= pieces - 1 {
+		from := pieces.first()
+		variant := gs.board[from].variant()
+
+		var targets bitboard
+		switch variant {
+		case Pawn:
+			targets = pawnAttacks[us][from] & gs.occupied[them]
+			if single := from + forward; isSquareInBoard(single) && !all.has(single) {
+				targets |= squareBitboard(single)
+				if double := single + forward; getRow(from) == startingRow && !all.has(double) {
+					targets |= squareBitboard(double)
+				}
+			}
+		case Knight:
+			targets = knightAttacks[from]
+		case Bishop:
+			targets = bishopAttacks(from, all)
+		case Rook:
+			targets = rookAttacks(from, all)
+		case Queen:
+			targets = bishopAttacks(from, all) | rookAttacks(from, all)
+		}
+
+		targets &= checkMask &^ ours
+		if pinned.has(from) {
+			targets &= pinRays[from]
+		}
+
+		for ; targets != 0; targets &= targets - 1 {
+			to := targets.first()
+			if variant == Pawn {
+				moves = appendPawnMoves(moves, from, to)
+			} else {
+				moves = append(moves, chessMove{from: from, to: to, promotion: Pawn})
+			}
+		}
+
+		if variant == Pawn && gs.enPassantAvailable && pawnAttacks[us][from].has(gs.enPassantTargetSquare) && gs.isEnPassantLegal(from, kingPosition) {
+			moves = append(moves, chessMove{from: from, to: gs.enPassantTargetSquare, promotion: Pawn})
+		}
+	}
+
+	return moves
+}
+
+func (gs *gameState) enPassantCapturedSquare() int {
+	if gs.turn == White {
+		return gs.enPassantTargetSquare + 8
+	}
+	return gs.enPassantTargetSquare - 8
+}
+
+// Both pawns leave their squares at once, so replay the capture on the occupancy and look at the king
+func (gs *gameState) isEnPassantLegal(from int, kingPosition int) bool {
+	var captured = gs.enPassantCapturedSquare()
+	var occupied = gs.allPieces()&^squareBitboard(from)&^squareBitboard(captured) | squareBitboard(gs.enPassantTargetSquare)
+	return gs.attackers(kingPosition, opponent(gs.turn), occupied)&^squareBitboard(captured) == 0
+}
+
+func (gs *gameState) appendCastlingMoves(moves []chessMove, kingPosition int) []chessMove {
+	var us = gs.turn
+	var them = opponent(us)
+	var all = gs.allPieces()
+	var rook = makePiece(us, Rook)
+
+	var homeSquare = 60
+	var kingSide, queenSide = gs.whiteCanKingSideCastle, gs.whiteCanQueenSideCastle
+	if us == Black {
+		homeSquare = 4
+		kingSide, queenSide = gs.blackCanKingSideCastle, gs.blackCanQueenSideCastle
+	}
+
+	if kingPosition != homeSquare {
+		return moves
+	}
+
+	if kingSide && gs.board[kingPosition+3] == rook && !all.has(kingPosition+1) && !all.has(kingPosition+2) {
+		if !gs.isSquareAttacked(kingPosition+1, them) && !gs.isSquareAttacked(kingPosition+2, them) {
+			moves = append(moves, chessMove{from: kingPosition, to: kingPosition + 2, promotion: Pawn})
+		}
+	}
+
+	/*The rook passes through b1/b8, it may be attacked*/
+	if queenSide && gs.board[kingPosition-4] == rook && !all.has(kingPosition-1) && !all.has(kingPosition-2) && !all.has(kingPosition-3) {
+		if !gs.isSquareAttacked(kingPosition-1, them) && !gs.isSquareAttacked(kingPosition-2, them) {
+			moves = append(moves, chessMove{from: kingPosition, to: kingPosition - 2, promotion: Pawn})
+		}
+	}
+
+	return moves
+}
+
+func (gs *gameState) hasLegalMove() bool {
+	var buffer [256]chessMove
+	return len(gs.generateLegalMoves(buffer[:0], ^bitboard(0))) > 0
+}
+
+// Plays m, which must be legal, and hands the turn over
+func (gs *gameState) makeMove(m chessMove) {
+	var us = gs.turn
+	var movingPiece = gs.board[m.from]
+	var variant = movingPiece.variant()
+	var isCapture = gs.board[m.to] != noPiece
+
+	gs.removePiece(m.from)
+	if isCapture {
+		gs.removePiece(m.to)
+	}
+
+	if variant == Pawn && gs.enPassantAvailable && m.to == gs.enPassantTargetSquare {
+		gs.removePiece(gs.enPassantCapturedSquare())
+	}
+
+	if m.promotion != Pawn {
+		movingPiece = makePiece(us, m.promotion)
+	}
+	gs.putPiece(m.to, movingPiece)
+
+	if variant == King {
+		// Castling, the rook jumps over the king
+		if m.to-m.from == 2 {
+			gs.putPiece(m.to-1, gs.board[m.to+1])
+			gs.removePiece(m.to + 1)
+		} else if m.from-m.to == 2 {
+			gs.putPiece(m.to+1, gs.board[m.to-2])
+			gs.removePiece(m.to - 2)
+		}
+
+		if us == White {
+			gs.whiteCanKingSideCastle = false
+			gs.whiteCanQueenSideCastle = false
+		} else {
+			gs.blackCanKingSideCastle = false
+			gs.blackCanQueenSideCastle = false
+		}
+	}
+
+	// Rook moves or captures
+	if m.to == 0 || m.from == 0 {
+		gs.blackCanQueenSideCastle = false
+	}
+	if m.to == 7 || m.from == 7 {
+		gs.blackCanKingSideCastle = false
+	}
+	if m.to == 56 || m.from == 56 {
+		gs.whiteCanQueenSideCastle = false
+	}
+	if m.to == 63 || m.from == 63 {
+		gs.whiteCanKingSideCastle = false
+	}
+
+	if variant == Pawn && abs(m.to-m.from) == 16 {
+		gs.enPassantAvailable = true
+		gs.enPassantTargetSquare = (m.from + m.to) / 2
+	} else {
+		gs.enPassantAvailable = false
+		gs.enPassantTargetSquare = 0
+	}
+
+	// Pawn moves and captures reset the halfmove clock, en passant is a pawn move
+	if variant == Pawn || isCapture {
+		gs.halfMoveClock = 0
+	} else {
+		gs.halfMoveClock += 1
+	}
+
+	if us == Black {
+		gs.fullMoveNumber += 1
+	}
+
+	gs.turn = opponent(us)
+}
@@ -0,0 +1,73 @@
+package chess
+
+import (
+	"sort"
+	"strings"
+)
+
+// Perft counts leaf nodes of the legal move tree, see https://www.chessprogramming.org/Perft
+
+func (m chessMove) uci() string {
+	var uci = intToAlgebraicNotation(m.from) + intToAlgebraicNotation(m.to)
+	if m.promotion != Pawn {
+		uci += strings.ToLower(variantToSAN[m.promotion])
+	}
+	return uci
+}
+
+func perft(currentGameState *gameState, depth int) int64 {
+	var buffer [256]chessMove
+	var legalMoves = currentGameState.generateLegalMoves(buffer[:0], ^bitboard(0))
+	if depth == 1 {
+		return int64(len(legalMoves))
+	}
+
+	var nodes int64
+	for _, legalMove := range legalMoves {
+		var newGameState = *currentGameState
+		newGameState.makeMove(legalMove)
+		nodes += perft(&newGameState, depth-1)
+	}
+
+	return nodes
+}
+
+func Perft(fen string, depth int) int64 {
+	if depth <= 0 {
+		return 1
+	}
+
+	var currentGameState = BoardFromFEN(fen)
+	return perft(&currentGameState, depth)
+}
+
+type PerftDivideEntry struct {
+	UCI   string
+	Nodes int64
+}
+
+// Node count below each legal move, sorted by UCI string
+func PerftDivide(fen string, depth int) []PerftDivideEntry {
+	var entries []PerftDivideEntry
+	if depth <= 0 {
+		return entries
+	}
+
+	var currentGameState = BoardFromFEN(fen)
+	for _, legalMove := range currentGameState.generateLegalMoves(nil, ^bitboard(0)) {
+		var newGameState = currentGameState
+		newGameState.makeMove(legalMove)
+
+		var nodes int64 = 1
+		if depth > 1 {
+			nodes = perft(&newGameState, depth-1)
+		}
+		entries = append(entries, PerftDivideEntry{UCI: legalMove.uci(), Nodes: nodes})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].UCI < entries[j].UCI
+	})
+
+	return entries
+}
@@ -0,0 +1,79 @@
+package chess
+
+import "testing"
+
+// Positions and counts from https://www.chessprogramming.org/Perft_Results
+var perftPositions = []struct {
+	name   string
+	fen    string
+	counts []int64 // counts[i] is the node count at depth i+1
+}{
+	{
+		name:   "initial position",
+		fen:    StartingFEN,
+		counts: []int64{20, 400, 8902, 197281, 4865609},
+	},
+	{
+		name:   "kiwipete",
+		fen:    "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+		counts: []int64{48, 2039, 97862, 4085603},
+	},
+	{
+		name:   "position 3",
+		fen:    "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1",
+		counts: []int64{14, 191, 2812, 43238, 674624},
+	},
+	{
+		name:   "position 4",
+		fen:    "r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1",
+		counts: []int64{6, 264, 9467, 422333},
+	},
+	{
+		name:   "position 4 mirrored",
+		fen:    "r2q1rk1/pP1p2pp/Q4n2/bbp1p3/Np6/1B3NBn/pPPP1PPP/R3K2R b KQ - 0 1",
+		counts: []int64{6, 264, 9467, 422333},
+	},
+	{
+		name:   "position 5",
+		fen:    "rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8",
+		counts: []int64{44, 1486, 62379, 2103487},
+	},
+	{
+		name:   "position 6",
+		fen:    "r4rk1/1pp1qppp/p1np1n2/2b1p1B1/2B1P1b1/P1NP1N2/1PP1QPPP/R4RK1 w - - 0 10",
+		counts: []int64{46, 2079, 89890, 3894594},
+	},
+}
+
+// Depths above this only run without -short
+const perftShortMaxNodes = 10_000
+
+func TestPerft(t *testing.T) {
+	for _, position := range perftPositions {
+		t.Run(position.name, func(t *testing.T) {
+			for i, want := range position.counts {
+				depth := i + 1
+				if testing.Short() && want > perftShortMaxNodes {
+					t.Skipf("skipping depth %v in short mode", depth)
+				}
+
+				got := Perft(position.fen, depth)
+				if got != want {
+					t.Errorf("depth %v: got %v nodes, want %v", depth, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPerftDivideSumsToPerft(t *testing.T) {
+	for _, position := range perftPositions {
+		var total int64
+		for _, entry := range PerftDivide(position.fen, 2) {
+			total += entry.Nodes
+		}
+		if total != position.counts[1] {
+			t.Errorf("%s: divide sums to %v, want %v", position.name, total, position.counts[1])
+		}
+	}
+}
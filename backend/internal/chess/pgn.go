@@ -0,0 +1,237 @@
+package chess
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// PGN export, see https://www.saremba.de/chessgml/standards/pgn/pgn-complete.htm
+
+const pgnMaxLineLength = 80
+
+type PGNTag struct {
+	Name  string
+	Value string
+}
+
+type PGNMove struct {
+	SAN            string
+	Comment        string
+	LeadingComment string // Comment before the first move of a variation
+	NAGs           []int
+	Variations     [][]PGNMove
+
+	// Filled in when a game is read, FEN is the position after the move
+	Piece           int
+	Move            int
+	PromotionString string
+	FEN             string
+}
+
+type PGNGame struct {
+	Tags    []PGNTag
+	Comment string
+	Moves   []PGNMove
+	Result  string
+}
+
+// Seven Tag Roster, must come first and in this order
+var sevenTagRoster = []string{"Event", "Site", "Date", "Round", "White", "Black", "Result"}
+
+const (
+	PGNWhiteWins  = "1-0"
+	PGNBlackWins  = "0-1"
+	PGNDraw       = "1/2-1/2"
+	PGNUnfinished = "*"
+)
+
+func PGNTermination(gameOverStatus GameOverStatusCode) string {
+	switch gameOverStatus {
+	case Ongoing:
+		return "unterminated"
+	case WhiteFlagged, BlackFlagged:
+		return "time forfeit"
+	case Abort, WhiteDisconnected, BlackDisconnected:
+		return "abandoned"
+	default:
+		return "normal"
+	}
+}
+
+func PGNDate(t time.Time) string {
+	return t.UTC().Format("2006.01.02")
+}
+
+func PGNTimeControl(timeFormatInMilliseconds int64, incrementInMilliseconds int64) string {
+	return fmt.Sprintf("%v+%v", timeFormatInMilliseconds/1000, incrementInMilliseconds/1000)
+}
+
+func PGNClockComment(timeRemaining time.Duration) string {
+	if timeRemaining < 0 {
+		timeRemaining = 0
+	}
+	totalSeconds := int64(timeRemaining / time.Second)
+	return fmt.Sprintf("[%%clk %d:%02d:%02d]", totalSeconds/3600, (totalSeconds/60)%60, totalSeconds%60)
+}
+
+func pgnCommentToken(comment string) string {
+	return "{" + strings.ReplaceAll(comment, "}", ")") + "}"
+}
+
+func escapePGNTagValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
+
+func (game *PGNGame) tagValue(name string) (string, bool) {
+	for _, tag := range game.Tags {
+		if tag.Name == name {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
+
+func (game *PGNGame) writeTags(builder *strings.Builder) {
+	// Roster first, unknown values are written as "?"
+	for _, name := range sevenTagRoster {
+		value, ok := game.tagValue(name)
+		if name == "Result" {
+			value, ok = game.Result, game.Result != ""
+		}
+		if !ok || value == "" {
+			value = "?"
+		}
+		fmt.Fprintf(builder, "[%s \"%s\"]\n", name, escapePGNTagValue(value))
+	}
+
+	for _, tag := range game.Tags {
+		if slices.Contains(sevenTagRoster, tag.Name) {
+			continue
+		}
+		fmt.Fprintf(builder, "[%s \"%s\"]\n", tag.Name, escapePGNTagValue(tag.Value))
+	}
+}
+
+// Ply of the first move, non-zero when the game starts from a FEN tag
+func (game *PGNGame) startingPly() int {
+	fen, ok := game.tagValue("FEN")
+	if !ok {
+		return 0
+	}
+	var startingGameState = BoardFromFEN(fen)
+	var ply = (max(startingGameState.fullMoveNumber, 1) - 1) * 2
+	if startingGameState.turn == Black {
+		ply += 1
+	}
+	return ply
+}
+
+func appendMovetextTokens(tokens []string, moves []PGNMove, ply int) []string {
+	// Black moves need a move number when they do not directly follow white's move
+	var needsMoveNumber = true
+
+	for _, move := range moves {
+		if move.LeadingComment != "" {
+			tokens = append(tokens, pgnCommentToken(move.LeadingComment))
+			needsMoveNumber = true
+		}
+
+		if ply%2 == 0 {
+			tokens = append(tokens, fmt.Sprintf("%v.", ply/2+1))
+		} else if needsMoveNumber {
+			tokens = append(tokens, fmt.Sprintf("%v...", ply/2+1))
+		}
+		tokens = append(tokens, move.SAN)
+		needsMoveNumber = false
+
+		for _, nag := range move.NAGs {
+			tokens = append(tokens, fmt.Sprintf("$%v", nag))
+		}
+
+		if move.Comment != "" {
+			tokens = append(tokens, pgnCommentToken(move.Comment))
+			needsMoveNumber = true
+		}
+
+		// Variations replace this move, so they start from the same ply
+		for _, variation := range move.Variations {
+			variationTokens := appendMovetextTokens([]string{}, variation, ply)
+			if len(variationTokens) == 0 {
+				continue
+			}
+			variationTokens[0] = "(" + variationTokens[0]
+			variationTokens[len(variationTokens)-1] += ")"
+			tokens = append(tokens, variationTokens...)
+			needsMoveNumber = true
+		}
+
+		ply += 1
+	}
+
+	return tokens
+}
+
+func (game *PGNGame) movetextTokens() []string {
+	var tokens []string
+
+	if game.Comment != "" {
+		tokens = append(tokens, pgnCommentToken(game.Comment))
+	}
+
+	tokens = appendMovetextTokens(tokens, game.Moves, game.startingPly())
+
+	result := game.Result
+	if result == "" {
+		result = PGNUnfinished
+	}
+
+	return append(tokens, result)
+}
+
+func (game *PGNGame) String() string {
+	var builder strings.Builder
+
+	game.writeTags(&builder)
+	builder.WriteString("\n")
+
+	// Wrap movetext, tokens are never split
+	var lineLength = 0
+	for _, token := range game.movetextTokens() {
+		if lineLength > 0 && lineLength+1+len(token) > pgnMaxLineLength {
+			builder.WriteString("\n")
+			lineLength = 0
+		} else if lineLength > 0 {
+			builder.WriteString(" ")
+			lineLength += 1
+		}
+		builder.WriteString(token)
+		lineLength += len(token)
+	}
+	builder.WriteString("\n")
+
+	return builder.String()
+}
+
+// Works out the SAN for a stored move by replaying it, the promotion piece is read from the FEN after the move
+func SANFromPositions(previousFEN string, piece int, move int, nextFEN string) (string, error) {
+	if !IsMoveValid(previousFEN, piece, move) {
+		return "", errors.New("move is not valid")
+	}
+
+	var promotionString = ""
+	var previousGameState = BoardFromFEN(previousFEN)
+	var nextGameState = BoardFromFEN(nextFEN)
+	var movingPiece = previousGameState.board[piece]
+	var landedPiece = nextGameState.board[move]
+
+	if movingPiece.variant() == Pawn && landedPiece != noPiece && landedPiece.variant() != Pawn {
+		promotionString = strings.ToLower(variantToSAN[landedPiece.variant()])
+	}
+
+	_, _, algebraicNotation := GetFENAfterMove(previousFEN, piece, move, promotionString)
+	return algebraicNotation, nil
+}
@@ -0,0 +1,468 @@
+package chess
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// PGN import, tokens follow section 7 of the PGN standard
+
+const StartingFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+type PGNError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *PGNError) Error() string {
+	return fmt.Sprintf("pgn: line %v, column %v: %s", e.Line, e.Column, e.Message)
+}
+
+type pgnTokenType int
+
+const (
+	pgnEOF = iota
+	pgnSymbol
+	pgnString
+	pgnComment
+	pgnNAG
+	pgnPeriod
+	pgnAsterisk
+	pgnOpenBracket
+	pgnCloseBracket
+	pgnOpenParen
+	pgnCloseParen
+)
+
+type pgnToken struct {
+	tokenType pgnTokenType
+	value     string
+	line      int
+	column    int
+}
+
+// Traditional suffix annotations and their NAG equivalents
+var suffixAnnotationToNAG = map[string]int{
+	"!":  1,
+	"?":  2,
+	"!!": 3,
+	"??": 4,
+	"!?": 5,
+	"?!": 6,
+}
+
+type pgnLexer struct {
+	input  []rune
+	pos    int
+	line   int
+	column int
+	peeked *pgnToken
+}
+
+func newPGNLexer(input string) *pgnLexer {
+	return &pgnLexer{input: []rune(input), line: 1, column: 1}
+}
+
+func (l *pgnLexer) errorAt(line int, column int, format string, args ...any) *PGNError {
+	return &PGNError{Line: line, Column: column, Message: fmt.Sprintf(format, args...)}
+}
+
+func (l *pgnLexer) atEnd() bool {
+	return l.pos >= len(l.input)
+}
+
+func (l *pgnLexer) current() rune {
+	return l.input[l.pos]
+}
+
+func (l *pgnLexer) advance() rune {
+	char := l.input[l.pos]
+	l.pos += 1
+	if char == '\n' {
+		l.line += 1
+		l.column = 1
+	} else {
+		l.column += 1
+	}
+	return char
+}
+
+func (l *pgnLexer) readUntil(stop rune) string {
+	var builder strings.Builder
+	for !l.atEnd() && l.current() != stop {
+		builder.WriteRune(l.advance())
+	}
+	return builder.String()
+}
+
+func isPGNSymbolContinuation(char rune) bool {
+	return unicode.IsLetter(char) || unicode.IsDigit(char) || strings.ContainsRune("_+#=:-/", char)
+}
+
+func (l *pgnLexer) peek() (pgnToken, error) {
+	if l.peeked != nil {
+		return *l.peeked, nil
+	}
+	token, err := l.readToken()
+	if err != nil {
+		return pgnToken{}, err
+	}
+	l.peeked = &token
+	return token, nil
+}
+
+func (l *pgnLexer) next() (pgnToken, error) {
+	if l.peeked != nil {
+		token := *l.peeked
+		l.peeked = nil
+		return token, nil
+	}
+	return l.readToken()
+}
+
+func (l *pgnLexer) readToken() (pgnToken, error) {
+	for !l.atEnd() {
+		char := l.current()
+
+		// Escape mechanism, lines starting with % are ignored
+		if char == '%' && l.column == 1 {
+			l.readUntil('\n')
+			continue
+		}
+
+		if unicode.IsSpace(char) {
+			l.advance()
+			continue
+		}
+
+		break
+	}
+
+	if l.atEnd() {
+		return pgnToken{tokenType: pgnEOF, line: l.line, column: l.column}, nil
+	}
+
+	var line, column = l.line, l.column
+	var char = l.advance()
+	var token = pgnToken{line: line, column: column, value: string(char)}
+
+	switch {
+	case char == '[':
+		token.tokenType = pgnOpenBracket
+	case char == ']':
+		token.tokenType = pgnCloseBracket
+	case char == '(':
+		token.tokenType = pgnOpenParen
+	case char == ')':
+		token.tokenType = pgnCloseParen
+	case char == '.':
+		token.tokenType = pgnPeriod
+	case char == '*':
+		token.tokenType = pgnAsterisk
+
+	case char == ';':
+		token.tokenType = pgnComment
+		token.value = strings.TrimSpace(l.readUntil('\n'))
+
+	case char == '{':
+		token.tokenType = pgnComment
+		token.value = strings.TrimSpace(l.readUntil('}'))
+		if l.atEnd() {
+			return pgnToken{}, l.errorAt(line, column, "unterminated comment")
+		}
+		l.advance()
+
+	case char == '"':
+		var builder strings.Builder
+		for {
+			if l.atEnd() || l.current() == '\n' {
+				return pgnToken{}, l.errorAt(line, column, "unterminated string")
+			}
+			next := l.advance()
+			if next == '"' {
+				break
+			}
+			if next == '\\' && !l.atEnd() && (l.current() == '\\' || l.current() == '"') {
+				next = l.advance()
+			}
+			builder.WriteRune(next)
+		}
+		token.tokenType = pgnString
+		token.value = builder.String()
+
+	case char == '$':
+		var digits = ""
+		for !l.atEnd() && unicode.IsDigit(l.current()) {
+			digits += string(l.advance())
+		}
+		if digits == "" {
+			return pgnToken{}, l.errorAt(line, column, "NAG without a number")
+		}
+		token.tokenType = pgnNAG
+		token.value = digits
+
+	case char == '!' || char == '?':
+		var annotation = string(char)
+		for !l.atEnd() && (l.current() == '!' || l.current() == '?') {
+			annotation += string(l.advance())
+		}
+		nag, ok := suffixAnnotationToNAG[annotation]
+		if !ok {
+			return pgnToken{}, l.errorAt(line, column, "unknown annotation %q", annotation)
+		}
+		token.tokenType = pgnNAG
+		token.value = strconv.Itoa(nag)
+
+	case unicode.IsLetter(char) || unicode.IsDigit(char):
+		var builder strings.Builder
+		builder.WriteRune(char)
+		for !l.atEnd() && isPGNSymbolContinuation(l.current()) {
+			builder.WriteRune(l.advance())
+		}
+		token.tokenType = pgnSymbol
+		token.value = builder.String()
+
+	default:
+		return pgnToken{}, l.errorAt(line, column, "unexpected character %q", char)
+	}
+
+	return token, nil
+}
+
+type pgnParser struct {
+	lexer *pgnLexer
+}
+
+func isPGNResult(value string) bool {
+	return value == PGNWhiteWins || value == PGNBlackWins || value == PGNDraw || value == PGNUnfinished
+}
+
+func isPGNMoveNumber(value string) bool {
+	for _, char := range value {
+		if !unicode.IsDigit(char) {
+			return false
+		}
+	}
+	return true
+}
+
+func joinPGNComments(existing string, comment string) string {
+	if existing == "" {
+		return comment
+	}
+	if comment == "" {
+		return existing
+	}
+	return existing + " " + comment
+}
+
+func (p *pgnParser) expect(tokenType pgnTokenType, description string) (pgnToken, error) {
+	token, err := p.lexer.next()
+	if err != nil {
+		return pgnToken{}, err
+	}
+	if token.tokenType != tokenType {
+		return pgnToken{}, p.lexer.errorAt(token.line, token.column, "expected %s, found %q", description, token.value)
+	}
+	return token, nil
+}
+
+func (p *pgnParser) parseTags(game *PGNGame) error {
+	for {
+		token, err := p.lexer.peek()
+		if err != nil {
+			return err
+		}
+		if token.tokenType != pgnOpenBracket {
+			return nil
+		}
+		p.lexer.next()
+
+		name, err := p.expect(pgnSymbol, "tag name")
+		if err != nil {
+			return err
+		}
+		value, err := p.expect(pgnString, "tag value")
+		if err != nil {
+			return err
+		}
+		_, err = p.expect(pgnCloseBracket, "]")
+		if err != nil {
+			return err
+		}
+
+		game.Tags = append(game.Tags, PGNTag{Name: name.value, Value: value.value})
+	}
+}
+
+// Parses moves until the end of the game or variation, every move is replayed from fen
+func (p *pgnParser) parseMovetext(game *PGNGame, fen string, depth int) ([]PGNMove, error) {
+	var moves []PGNMove
+	// Position before the last move, variations start from here
+	var previousFEN = fen
+	var currentFEN = fen
+	// Comments before the first move of a variation, the game's own go to game.Comment
+	var leadingComment = ""
+
+	for {
+		token, err := p.lexer.peek()
+		if err != nil {
+			return nil, err
+		}
+
+		switch token.tokenType {
+		case pgnEOF:
+			if depth > 0 {
+				return nil, p.lexer.errorAt(token.line, token.column, "unterminated variation")
+			}
+			return moves, nil
+
+		case pgnOpenBracket:
+			// Next game starts without a result for this one
+			if depth > 0 {
+				return nil, p.lexer.errorAt(token.line, token.column, "unterminated variation")
+			}
+			return moves, nil
+
+		case pgnCloseParen:
+			if depth == 0 {
+				return nil, p.lexer.errorAt(token.line, token.column, "unexpected %q", token.value)
+			}
+			return moves, nil
+		}
+
+		p.lexer.next()
+
+		switch token.tokenType {
+		case pgnPeriod:
+			continue
+
+		case pgnAsterisk:
+			if depth > 0 {
+				return nil, p.lexer.errorAt(token.line, token.column, "result inside variation")
+			}
+			game.Result = PGNUnfinished
+			return moves, nil
+
+		case pgnSymbol:
+			if isPGNMoveNumber(token.value) {
+				continue
+			}
+
+			if isPGNResult(token.value) {
+				if depth > 0 {
+					return nil, p.lexer.errorAt(token.line, token.column, "result inside variation")
+				}
+				game.Result = token.value
+				return moves, nil
+			}
+
+			piece, move, promotionString, err := MoveFromSAN(currentFEN, token.value)
+			if err != nil {
+				return nil, p.lexer.errorAt(token.line, token.column, "%s", err.Error())
+			}
+
+			newFEN, _, algebraicNotation := GetFENAfterMove(currentFEN, piece, move, promotionString)
+			moves = append(moves, PGNMove{
+				SAN:             algebraicNotation,
+				Piece:           piece,
+				Move:            move,
+				PromotionString: promotionString,
+				FEN:             newFEN,
+				LeadingComment:  leadingComment,
+			})
+			previousFEN, currentFEN = currentFEN, newFEN
+			leadingComment = ""
+
+		case pgnComment:
+			if len(moves) == 0 && depth == 0 {
+				game.Comment = joinPGNComments(game.Comment, token.value)
+				continue
+			}
+			if len(moves) == 0 {
+				leadingComment = joinPGNComments(leadingComment, token.value)
+				continue
+			}
+			moves[len(moves)-1].Comment = joinPGNComments(moves[len(moves)-1].Comment, token.value)
+
+		case pgnNAG:
+			if len(moves) == 0 {
+				return nil, p.lexer.errorAt(token.line, token.column, "annotation before any move")
+			}
+			nag, _ := strconv.Atoi(token.value)
+			moves[len(moves)-1].NAGs = append(moves[len(moves)-1].NAGs, nag)
+
+		case pgnOpenParen:
+			if len(moves) == 0 {
+				return nil, p.lexer.errorAt(token.line, token.column, "variation before any move")
+			}
+			variation, err := p.parseMovetext(game, previousFEN, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			_, err = p.expect(pgnCloseParen, ")")
+			if err != nil {
+				return nil, err
+			}
+			moves[len(moves)-1].Variations = append(moves[len(moves)-1].Variations, variation)
+
+		default:
+			return nil, p.lexer.errorAt(token.line, token.column, "unexpected %q", token.value)
+		}
+	}
+}
+
+func (p *pgnParser) parseGame() (PGNGame, error) {
+	var game PGNGame
+
+	err := p.parseTags(&game)
+	if err != nil {
+		return PGNGame{}, err
+	}
+
+	fen, ok := game.tagValue("FEN")
+	if !ok {
+		fen = StartingFEN
+	}
+
+	game.Moves, err = p.parseMovetext(&game, fen, 0)
+	if err != nil {
+		return PGNGame{}, err
+	}
+
+	// Movetext result wins, fall back to the tag
+	if game.Result == "" {
+		if result, ok := game.tagValue("Result"); ok && isPGNResult(result) {
+			game.Result = result
+		} else {
+			game.Result = PGNUnfinished
+		}
+	}
+
+	return game, nil
+}
+
+// Reads every game in pgn, each move is validated by replaying it from the starting position
+func ReadPGN(pgn string) ([]PGNGame, error) {
+	var parser = pgnParser{lexer: newPGNLexer(pgn)}
+	var games []PGNGame
+
+	for {
+		token, err := parser.lexer.peek()
+		if err != nil {
+			return nil, err
+		}
+		if token.tokenType == pgnEOF {
+			return games, nil
+		}
+
+		game, err := parser.parseGame()
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, game)
+	}
+}
@@ -0,0 +1,339 @@
+package chess
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const annotatedPGN = `[Event "Casual game"]
+[Site "?"]
+[Date "2024.05.01"]
+[Round "?"]
+[White "alice"]
+[Black "bob"]
+[Result "1-0"]
+[TimeControl "300+2"]
+
+{Opening practice} 1. e4 $1 e5 ({Sicilian} 1... c5 2. Nf3 (2. c3 d5 $5) 2... d6)
+2. Nf3 Nc6 $6 3. Bb5 {Ruy Lopez} 3... a6 1-0
+`
+
+// Same game as annotatedPGN written the way people type it, suffix annotations and comments after move numbers
+const looseAnnotatedPGN = `[Event "Casual game"] [White "alice"] [Black "bob"] [Result "1-0"]
+[Date "2024.05.01"] [TimeControl "300+2"]
+{Opening practice} 1.e4! e5 (1...{Sicilian}c5 2.Nf3 (2.c3 d5!?) d6) 2.Nf3 Nc6?!
+3.Bb5 {Ruy Lopez} a6 1-0`
+
+func TestReadPGNRoundTrip(t *testing.T) {
+	games, err := ReadPGN(annotatedPGN)
+	if err != nil {
+		t.Fatalf("ReadPGN: %v", err)
+	}
+	if len(games) != 1 {
+		t.Fatalf("read %v games, want 1", len(games))
+	}
+
+	exported := games[0].String()
+	if exported != annotatedPGN {
+		t.Errorf("export differs from the input\ngot:\n%s\nwant:\n%s", exported, annotatedPGN)
+	}
+
+	reread, err := ReadPGN(exported)
+	if err != nil {
+		t.Fatalf("ReadPGN of the export: %v", err)
+	}
+	if !reflect.DeepEqual(reread, games) {
+		t.Errorf("reading the export gave %+v, want %+v", reread, games)
+	}
+}
+
+func TestReadPGNAnnotations(t *testing.T) {
+	games, err := ReadPGN(looseAnnotatedPGN)
+	if err != nil {
+		t.Fatalf("ReadPGN: %v", err)
+	}
+	var game = games[0]
+
+	canonicalGames, err := ReadPGN(annotatedPGN)
+	if err != nil {
+		t.Fatalf("ReadPGN: %v", err)
+	}
+	if !reflect.DeepEqual(game.Moves, canonicalGames[0].Moves) {
+		t.Errorf("moves %+v, want %+v", game.Moves, canonicalGames[0].Moves)
+	}
+
+	if game.Comment != "Opening practice" {
+		t.Errorf("game comment %q, want %q", game.Comment, "Opening practice")
+	}
+	if game.Result != PGNWhiteWins {
+		t.Errorf("result %q, want %q", game.Result, PGNWhiteWins)
+	}
+
+	var mainline []string
+	for _, move := range game.Moves {
+		mainline = append(mainline, move.SAN)
+	}
+	if want := []string{"e4", "e5", "Nf3", "Nc6", "Bb5", "a6"}; !slices.Equal(mainline, want) {
+		t.Fatalf("mainline %v, want %v", mainline, want)
+	}
+
+	if !slices.Equal(game.Moves[0].NAGs, []int{1}) {
+		t.Errorf("e4 NAGs %v, want [1]", game.Moves[0].NAGs)
+	}
+	// Suffix annotations are read as their NAG
+	if !slices.Equal(game.Moves[3].NAGs, []int{6}) {
+		t.Errorf("Nc6?! NAGs %v, want [6]", game.Moves[3].NAGs)
+	}
+	if game.Moves[4].Comment != "Ruy Lopez" {
+		t.Errorf("Bb5 comment %q, want %q", game.Moves[4].Comment, "Ruy Lopez")
+	}
+
+	if len(game.Moves[1].Variations) != 1 {
+		t.Fatalf("e5 has %v variations, want 1", len(game.Moves[1].Variations))
+	}
+	var sicilian = game.Moves[1].Variations[0]
+	if len(sicilian) != 3 || sicilian[0].SAN != "c5" || sicilian[2].SAN != "d6" {
+		t.Fatalf("variation %+v, want c5 Nf3 d6", sicilian)
+	}
+	if sicilian[0].LeadingComment != "Sicilian" {
+		t.Errorf("c5 leading comment %q, want %q", sicilian[0].LeadingComment, "Sicilian")
+	}
+
+	// Nested variation replaces 2. Nf3 and starts from the position after 1... c5
+	if len(sicilian[1].Variations) != 1 {
+		t.Fatalf("Nf3 has %v variations, want 1", len(sicilian[1].Variations))
+	}
+	var alapin = sicilian[1].Variations[0]
+	if len(alapin) != 2 || alapin[0].SAN != "c3" || alapin[1].SAN != "d5" {
+		t.Fatalf("nested variation %+v, want c3 d5", alapin)
+	}
+	if !slices.Equal(alapin[1].NAGs, []int{5}) {
+		t.Errorf("d5 NAGs %v, want [5]", alapin[1].NAGs)
+	}
+	if alapin[0].FEN != "rnbqkbnr/pp1ppppp/8/2p5/4P3/2P5/PP1P1PPP/RNBQKBNR b KQkq - 0 2" {
+		t.Errorf("FEN after 2. c3 is %q", alapin[0].FEN)
+	}
+}
+
+func TestReadPGNErrors(t *testing.T) {
+	var tests = []struct {
+		name    string
+		pgn     string
+		line    int
+		column  int
+		message string
+	}{
+		{
+			name:    "illegal move",
+			pgn:     "1. e4 e5\n2. Ke3 Nc6 *",
+			line:    2,
+			column:  4,
+			message: "illegal move",
+		},
+		{
+			name:    "bad token",
+			pgn:     "1. e4 & e5 *",
+			line:    1,
+			column:  7,
+			message: "unexpected character",
+		},
+		{
+			name:    "unterminated comment",
+			pgn:     "1. e4 {never\nclosed e5 *",
+			line:    1,
+			column:  7,
+			message: "unterminated comment",
+		},
+		{
+			name:    "unterminated variation",
+			pgn:     "1. e4 (1. d4 d5\n2. c4",
+			line:    2,
+			column:  6,
+			message: "unterminated variation",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ReadPGN(test.pgn)
+			var pgnError *PGNError
+			if !errors.As(err, &pgnError) {
+				t.Fatalf("got error %v, want a PGNError", err)
+			}
+			if pgnError.Line != test.line || pgnError.Column != test.column {
+				t.Errorf("error at line %v, column %v, want line %v, column %v", pgnError.Line, pgnError.Column, test.line, test.column)
+			}
+			if !strings.Contains(pgnError.Message, test.message) {
+				t.Errorf("message %q, want it to contain %q", pgnError.Message, test.message)
+			}
+		})
+	}
+}
+
+func TestMoveFromSAN(t *testing.T) {
+	var tests = []struct {
+		name            string
+		fen             string
+		san             string
+		from            string
+		to              string
+		promotionString string
+		fenAfter        string // Checked when not empty
+	}{
+		{
+			name: "file disambiguation",
+			fen:  "rnbqkb1r/ppp2ppp/5n2/3pp3/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq - 0 4",
+			san:  "Nbd7",
+			from: "b8",
+			to:   "d7",
+		},
+		{
+			name: "other knight",
+			fen:  "rnbqkb1r/ppp2ppp/5n2/3pp3/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq - 0 4",
+			san:  "Nfd7",
+			from: "f6",
+			to:   "d7",
+		},
+		{
+			name:     "en passant",
+			fen:      "rnbqkbnr/ppp1pppp/8/3pP3/8/8/PPPP1PPP/RNBQKBNR w KQkq d6 0 3",
+			san:      "exd6",
+			from:     "e5",
+			to:       "d6",
+			fenAfter: "rnbqkbnr/ppp1pppp/3P4/8/8/8/PPPP1PPP/RNBQKBNR b KQkq - 0 3",
+		},
+		{
+			name:            "promotion with check",
+			fen:             "7k/4P3/8/8/8/8/8/K7 w - - 0 1",
+			san:             "e8=Q+",
+			from:            "e7",
+			to:              "e8",
+			promotionString: "q",
+			fenAfter:        "4Q2k/8/8/8/8/8/8/K7 b - - 0 1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			piece, move, promotionString, err := MoveFromSAN(test.fen, test.san)
+			if err != nil {
+				t.Fatalf("MoveFromSAN(%q): %v", test.san, err)
+			}
+			from, _ := squareFromAlgebraic(test.from)
+			to, _ := squareFromAlgebraic(test.to)
+			if piece != from || move != to || promotionString != test.promotionString {
+				t.Errorf("got %v to %v promoting to %q, want %v to %v promoting to %q", piece, move, promotionString, from, to, test.promotionString)
+			}
+
+			fenAfter, _, algebraicNotation := GetFENAfterMove(test.fen, piece, move, promotionString)
+			if algebraicNotation != test.san {
+				t.Errorf("replayed as %q, want %q", algebraicNotation, test.san)
+			}
+			if test.fenAfter != "" && fenAfter != test.fenAfter {
+				t.Errorf("FEN after the move %q, want %q", fenAfter, test.fenAfter)
+			}
+		})
+	}
+
+	// Both knights reach d7, so the move needs a file
+	_, _, _, err := MoveFromSAN("rnbqkb1r/ppp2ppp/5n2/3pp3/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq - 0 4", "Nd7")
+	if err == nil {
+		t.Errorf("ambiguous Nd7 was accepted")
+	}
+}
+
+func TestMoveFromUCI(t *testing.T) {
+	const promotionFEN = "7k/4P3/8/8/8/8/8/K7 w - - 0 1"
+	const castlingFEN = "r3k2r/pppppppp/8/8/8/8/PPPPPPPP/R3K2R w KQkq - 0 1"
+	var tests = []struct {
+		name            string
+		fen             string
+		uci             string
+		from            string
+		to              string
+		promotionString string
+		fenAfter        string // Checked when not empty
+	}{
+		{
+			name: "pawn push",
+			fen:  StartingFEN,
+			uci:  "e2e4",
+			from: "e2",
+			to:   "e4",
+		},
+		{
+			name:            "promotion",
+			fen:             promotionFEN,
+			uci:             "e7e8q",
+			from:            "e7",
+			to:              "e8",
+			promotionString: "q",
+			fenAfter:        "4Q2k/8/8/8/8/8/8/K7 b - - 0 1",
+		},
+		{
+			name:            "underpromotion",
+			fen:             promotionFEN,
+			uci:             "e7e8n",
+			from:            "e7",
+			to:              "e8",
+			promotionString: "n",
+			fenAfter:        "4N2k/8/8/8/8/8/8/K7 b - - 0 1",
+		},
+		{
+			name:     "castling",
+			fen:      castlingFEN,
+			uci:      "e1g1",
+			from:     "e1",
+			to:       "g1",
+			fenAfter: "r3k2r/pppppppp/8/8/8/8/PPPPPPPP/R4RK1 b kq - 1 1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			piece, move, promotionString, err := MoveFromUCI(test.fen, test.uci)
+			if err != nil {
+				t.Fatalf("MoveFromUCI(%q): %v", test.uci, err)
+			}
+			from, _ := squareFromAlgebraic(test.from)
+			to, _ := squareFromAlgebraic(test.to)
+			if piece != from || move != to || promotionString != test.promotionString {
+				t.Errorf("got %v to %v promoting to %q, want %v to %v promoting to %q", piece, move, promotionString, from, to, test.promotionString)
+			}
+
+			fenAfter, _, _ := GetFENAfterMove(test.fen, piece, move, promotionString)
+			if test.fenAfter != "" && fenAfter != test.fenAfter {
+				t.Errorf("FEN after the move %q, want %q", fenAfter, test.fenAfter)
+			}
+		})
+	}
+
+	var rejected = []struct {
+		name string
+		fen  string
+		uci  string
+	}{
+		{name: "too short", fen: StartingFEN, uci: "e2e"},
+		{name: "too long", fen: StartingFEN, uci: "e2e4e5"},
+		{name: "not a square", fen: StartingFEN, uci: "i2e4"},
+		{name: "SAN", fen: StartingFEN, uci: "Nf3"},
+		{name: "illegal move", fen: StartingFEN, uci: "e2e5"},
+		{name: "castling through check", fen: "r3kr2/ppppp1pp/8/8/8/8/PPPPP1PP/R3K2R w KQq - 0 1", uci: "e1g1"},
+		{name: "missing promotion piece", fen: promotionFEN, uci: "e7e8"},
+		{name: "promotion to a king", fen: promotionFEN, uci: "e7e8k"},
+		{name: "upper case promotion", fen: promotionFEN, uci: "e7e8Q"},
+		{name: "promotion on a quiet move", fen: StartingFEN, uci: "e2e4q"},
+	}
+
+	for _, test := range rejected {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, _, err := MoveFromUCI(test.fen, test.uci)
+			if err == nil {
+				t.Errorf("MoveFromUCI(%q) was accepted", test.uci)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package chess
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var sanToVariant = map[byte]pieceVariant{
+	'N': Knight,
+	'B': Bishop,
+	'R': Rook,
+	'Q': Queen,
+	'K': King,
+}
+
+func squareFromAlgebraic(algebraic string) (int, bool) {
+	if len(algebraic) != 2 {
+		return 0, false
+	}
+	file := int(algebraic[0]) - 'a'
+	rank := int(algebraic[1]) - '0'
+	if file < 0 || file > 7 || rank < 1 || rank > 8 {
+		return 0, false
+	}
+	return (8-rank)*8 + file, true
+}
+
+func isPromotionSquare(position int) bool {
+	return position <= 7 || position >= 56
+}
+
+// Resolves a SAN string such as "Nbd7", "exd8=Q+" or "O-O" against fen into square indices and a promotion string
+func MoveFromSAN(fen string, san string) (piece int, move int, promotionString string, err error) {
+	var currentGameState = BoardFromFEN(fen)
+
+	// Check, mate and annotation suffixes carry no move information
+	var trimmed = strings.TrimRight(san, "+#!?")
+	if trimmed == "" {
+		return 0, 0, "", fmt.Errorf("empty move %q", san)
+	}
+
+	// Castling
+	var castling = strings.ReplaceAll(trimmed, "0", "O")
+	if castling == "O-O" || castling == "O-O-O" {
+		var kingPosition = currentGameState.kingPosition(currentGameState.turn)
+		if kingPosition == -1 {
+			return 0, 0, "", fmt.Errorf("illegal move %q", san)
+		}
+		move = kingPosition + 2
+		if castling == "O-O-O" {
+			move = kingPosition - 2
+		}
+		if !IsMoveValid(fen, kingPosition, move) {
+			return 0, 0, "", fmt.Errorf("illegal move %q", san)
+		}
+		return kingPosition, move, "", nil
+	}
+
+	// Promotion, with or without the "="
+	var promotionVariant pieceVariant = Pawn
+	if idx := strings.IndexByte(trimmed, '='); idx != -1 {
+		if idx != len(trimmed)-2 {
+			return 0, 0, "", fmt.Errorf("malformed promotion %q", san)
+		}
+		variant, ok := sanToVariant[trimmed[idx+1]]
+		if !ok || variant == King {
+			return 0, 0, "", fmt.Errorf("malformed promotion %q", san)
+		}
+		promotionVariant = variant
+		trimmed = trimmed[:idx]
+	} else if len(trimmed) >= 3 && trimmed[len(trimmed)-2] >= '1' && trimmed[len(trimmed)-2] <= '8' {
+		if variant, ok := sanToVariant[trimmed[len(trimmed)-1]]; ok && variant != King {
+			promotionVariant = variant
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+	}
+
+	if len(trimmed) < 2 {
+		return 0, 0, "", fmt.Errorf("malformed move %q", san)
+	}
+
+	move, ok := squareFromAlgebraic(trimmed[len(trimmed)-2:])
+	if !ok {
+		return 0, 0, "", fmt.Errorf("malformed move %q", san)
+	}
+
+	var variant pieceVariant = Pawn
+	var prefix = trimmed[:len(trimmed)-2]
+	if len(prefix) > 0 {
+		if prefixVariant, ok := sanToVariant[prefix[0]]; ok {
+			variant = prefixVariant
+			prefix = prefix[1:]
+		}
+	}
+	prefix = strings.TrimSuffix(prefix, "x")
+
+	// Whatever is left is disambiguation, a file and/or a rank
+	var disambiguationFile, disambiguationRank = -1, -1
+	for _, char := range prefix {
+		switch {
+		case char >= 'a' && char <= 'h' && disambiguationFile == -1:
+			disambiguationFile = int(char - 'a')
+		case char >= '1' && char <= '8' && disambiguationRank == -1:
+			disambiguationRank = int(char - '0')
+		default:
+			return 0, 0, "", fmt.Errorf("malformed move %q", san)
+		}
+	}
+
+	var candidates []int
+	var buffer [256]chessMove
+	for _, legalMove := range currentGameState.generateLegalMoves(buffer[:0], currentGameState.pieces[currentGameState.turn][variant]) {
+		if legalMove.to != move || slices.Contains(candidates, legalMove.from) {
+			continue
+		}
+		if disambiguationFile != -1 && getCol(legalMove.from) != disambiguationFile {
+			continue
+		}
+		if disambiguationRank != -1 && 8-getRow(legalMove.from) != disambiguationRank {
+			continue
+		}
+		candidates = append(candidates, legalMove.from)
+	}
+
+	if len(candidates) == 0 {
+		return 0, 0, "", fmt.Errorf("illegal move %q", san)
+	}
+	if len(candidates) > 1 {
+		return 0, 0, "", fmt.Errorf("ambiguous move %q", san)
+	}
+
+	piece = candidates[0]
+
+	if variant == Pawn && isPromotionSquare(move) {
+		if promotionVariant == Pawn {
+			return 0, 0, "", fmt.Errorf("missing promotion piece %q", san)
+		}
+		promotionString = strings.ToLower(variantToSAN[promotionVariant])
+	} else if promotionVariant != Pawn {
+		return 0, 0, "", fmt.Errorf("promotion given for a move that does not promote %q", san)
+	}
+
+	return piece, move, promotionString, nil
+}
+
+var uciPromotionStrings = map[byte]bool{'q': true, 'r': true, 'b': true, 'n': true}
+
+// Resolves a UCI string such as "e2e4" or "e7e8q" against fen into square indices and a promotion string
+func MoveFromUCI(fen string, uci string) (piece int, move int, promotionString string, err error) {
+	if len(uci) != 4 && len(uci) != 5 {
+		return 0, 0, "", fmt.Errorf("malformed move %q", uci)
+	}
+
+	piece, ok := squareFromAlgebraic(uci[0:2])
+	if !ok {
+		return 0, 0, "", fmt.Errorf("malformed move %q", uci)
+	}
+	move, ok = squareFromAlgebraic(uci[2:4])
+	if !ok {
+		return 0, 0, "", fmt.Errorf("malformed move %q", uci)
+	}
+
+	if !IsMoveValid(fen, piece, move) {
+		return 0, 0, "", fmt.Errorf("illegal move %q", uci)
+	}
+
+	var currentGameState = BoardFromFEN(fen)
+	var isPromotion = currentGameState.board[piece].variant() == Pawn && isPromotionSquare(move)
+
+	if len(uci) == 5 {
+		if !isPromotion || !uciPromotionStrings[uci[4]] {
+			return 0, 0, "", fmt.Errorf("malformed promotion %q", uci)
+		}
+		promotionString = uci[4:]
+	} else if isPromotion {
+		return 0, 0, "", fmt.Errorf("missing promotion piece %q", uci)
+	}
+
+	return piece, move, promotionString, nil
+}
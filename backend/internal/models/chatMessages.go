@@ -0,0 +1,137 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Players and spectators chat in separate channels
+const (
+	PlayerChatChannel    = "players"
+	SpectatorChatChannel = "spectators"
+)
+
+type ChatMessage struct {
+	MessageID      int64  `json:"messageID"`
+	MatchID        int64  `json:"matchID"`
+	PlayerID       int64  `json:"-"`
+	Sender         string `json:"sender"`
+	Channel        string `json:"channel"`
+	MessageContent string `json:"messageContent"`
+	UnixMsTimeSent int64  `json:"unixMsTimeSent"`
+}
+
+type ChatMessageModel struct {
+	DB *sql.DB
+}
+
+func (m *ChatMessageModel) InsertNew(matchID int64, playerID int64, sender string, channel string, messageContent string, timeSent time.Time) error {
+	sqlStmt := `
+	INSERT INTO chat_messages (
+	    match_id,
+		player_id,
+		sender,
+		channel,
+		message_content,
+		unix_ms_time_sent
+		) VALUES(?, ?, ?, ?, ?, ?);
+	`
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		app.errorLog.Printf("Error starting transaction: %v\n", err)
+		return err
+	}
+
+	insertStmt, err := tx.Prepare(sqlStmt)
+	if err != nil {
+		app.errorLog.Printf("Error preparing statement: %v\n", err)
+		return err
+	}
+	defer insertStmt.Close()
+
+	_, err = ExecStatementWithRetry(insertStmt, matchID, playerID, sender, channel, messageContent, timeSent.UnixMilli())
+	if err != nil {
+		app.errorLog.Printf("Error executing statement: %v\n", err)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			app.errorLog.Printf("insert chat_messages: unable to rollback: %v", rollbackErr)
+		}
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.errorLog.Printf("Error commiting transaction in InsertNew chat message: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *ChatMessageModel) EnQueueInsertNew(matchID int64, playerID int64, sender string, channel string, messageContent string, timeSent time.Time, waitFor *sync.WaitGroup, block *sync.WaitGroup) {
+	DBTaskQueue.EnQueueErrorOnlyTask(func() error {
+		return m.InsertNew(matchID, playerID, sender, channel, messageContent, timeSent)
+	}, waitFor, block)
+}
+
+// Oldest first, both channels
+func (m *ChatMessageModel) GetFromMatchID(matchID int64) ([]ChatMessage, error) {
+	sqlStmt := `
+	SELECT message_id,
+	       match_id,
+	       player_id,
+	       sender,
+	       channel,
+	       message_content,
+	       unix_ms_time_sent
+	  FROM chat_messages
+	 WHERE match_id = ?
+	 ORDER BY message_id
+	`
+
+	rows, err := QueryWithRetry(m.DB, sqlStmt, matchID)
+	if err != nil {
+		app.errorLog.Printf("Error getting chat messages for match %v: %s\n", matchID, err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	output := []ChatMessage{}
+	for rows.Next() {
+		var message ChatMessage
+		err := rows.Scan(
+			&message.MessageID,
+			&message.MatchID,
+			&message.PlayerID,
+			&message.Sender,
+			&message.Channel,
+			&message.MessageContent,
+			&message.UnixMsTimeSent,
+		)
+		if err != nil {
+			app.errorLog.Printf("Error in GetFromMatchID chat messages: %s\n", err.Error())
+			return nil, err
+		}
+		output = append(output, message)
+	}
+
+	return output, rows.Err()
+}
+
+func (m *ChatMessageModel) EnQueueReturnGetFromMatchID(matchID int64, waitFor *sync.WaitGroup, block *sync.WaitGroup) ([]ChatMessage, error) {
+	result, err := DBTaskQueue.EnQueueReturn(func() (any, error) {
+		return m.GetFromMatchID(matchID)
+	}, waitFor, block)
+	if err != nil {
+		return nil, err
+	}
+	messages, ok := result.([]ChatMessage)
+	if !ok {
+		app.errorLog.Println("messages is not []models.ChatMessage")
+		return nil, errors.New("messages is not []models.ChatMessage")
+	}
+	return messages, nil
+}
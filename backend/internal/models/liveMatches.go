@@ -2,6 +2,7 @@ package models
 
 import (
 	"burrchess/internal/chess"
+	"burrchess/internal/rating"
 	"database/sql"
 	"errors"
 	"sync"
@@ -25,6 +26,7 @@ type LiveMatch struct {
 	WhitePlayerElo                       int64         `json:"whitePlayerElo"`
 	BlackPlayerElo                       int64         `json:"blackPlayerElo"`
 	MatchStartTime                       int64         `json:"matchStartTime"`
+	Rated                                bool          `json:"rated"`
 }
 
 type LiveMatchWithUsernames struct {
@@ -44,6 +46,7 @@ type LiveMatchWithUsernames struct {
 	WhitePlayerElo                       int64          `json:"whitePlayerElo"`
 	BlackPlayerElo                       int64          `json:"blackPlayerElo"`
 	MatchStartTime                       int64          `json:"matchStartTime"`
+	Rated                                bool           `json:"rated"`
 	WhitePlayerUsername                  sql.NullString `json:"whitePlayerUsername"`
 	BlackPlayerUsername                  sql.NullString `json:"blackPlayerUsername"`
 }
@@ -52,7 +55,7 @@ type LiveMatchModel struct {
 	DB *sql.DB
 }
 
-func (m *LiveMatchModel) InsertNew(playerOneID int64, playerTwoID int64, playerOneIsWhite bool, timeFormatInMilliseconds int64, incrementInMilliseconds int64, gameHistory []byte, averageElo float64, whitePlayerElo int64, blackPlayerElo int64) (int64, error) {
+func (m *LiveMatchModel) InsertNew(playerOneID int64, playerTwoID int64, playerOneIsWhite bool, timeFormatInMilliseconds int64, incrementInMilliseconds int64, gameHistory []byte, averageElo float64, whitePlayerElo int64, blackPlayerElo int64, rated bool) (int64, error) {
 	app.infoLog.Printf("Inserting new match")
 	var result sql.Result
 	var err error
@@ -72,8 +75,9 @@ func (m *LiveMatchModel) InsertNew(playerOneID int64, playerTwoID int64, playerO
 		average_elo,
 		white_player_elo,
 		black_player_elo,
-		match_start_time
-		) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+		match_start_time,
+		rated
+		) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
 	`
 	// Set white and black remaining time equal to the time format
 
@@ -91,9 +95,9 @@ func (m *LiveMatchModel) InsertNew(playerOneID int64, playerTwoID int64, playerO
 	defer insertStmt.Close()
 
 	if playerOneIsWhite {
-		result, err = ExecStatementWithRetry(insertStmt, playerOneID, playerTwoID, timeFormatInMilliseconds, incrementInMilliseconds, timeFormatInMilliseconds, timeFormatInMilliseconds, gameHistory, time.Time.UnixMilli(time.Now()), averageElo, whitePlayerElo, blackPlayerElo, time.Time.Unix(time.Now()))
+		result, err = ExecStatementWithRetry(insertStmt, playerOneID, playerTwoID, timeFormatInMilliseconds, incrementInMilliseconds, timeFormatInMilliseconds, timeFormatInMilliseconds, gameHistory, time.Time.UnixMilli(time.Now()), averageElo, whitePlayerElo, blackPlayerElo, time.Time.Unix(time.Now()), rated)
 	} else {
-		result, err = ExecStatementWithRetry(insertStmt, playerTwoID, playerOneID, timeFormatInMilliseconds, incrementInMilliseconds, timeFormatInMilliseconds, timeFormatInMilliseconds, gameHistory, time.Time.UnixMilli(time.Now()), averageElo, whitePlayerElo, blackPlayerElo, time.Time.Unix(time.Now()))
+		result, err = ExecStatementWithRetry(insertStmt, playerTwoID, playerOneID, timeFormatInMilliseconds, incrementInMilliseconds, timeFormatInMilliseconds, timeFormatInMilliseconds, gameHistory, time.Time.UnixMilli(time.Now()), averageElo, whitePlayerElo, blackPlayerElo, time.Time.Unix(time.Now()), rated)
 	}
 
 	if err != nil {
@@ -120,9 +124,9 @@ func (m *LiveMatchModel) InsertNew(playerOneID int64, playerTwoID int64, playerO
 	return result.LastInsertId()
 }
 
-func (m *LiveMatchModel) EnQueueReturnInsertNew(playerOneID int64, playerTwoID int64, playerOneIsWhite bool, timeFormatInMilliseconds int64, incrementInMilliseconds int64, gameHistory []byte, averageElo float64, whitePlayerElo int64, blackPlayerElo int64, waitFor *sync.WaitGroup, block *sync.WaitGroup) (int64, error) {
+func (m *LiveMatchModel) EnQueueReturnInsertNew(playerOneID int64, playerTwoID int64, playerOneIsWhite bool, timeFormatInMilliseconds int64, incrementInMilliseconds int64, gameHistory []byte, averageElo float64, whitePlayerElo int64, blackPlayerElo int64, rated bool, waitFor *sync.WaitGroup, block *sync.WaitGroup) (int64, error) {
 	result, err := DBTaskQueue.EnQueueReturn(func() (any, error) {
-		return m.InsertNew(playerOneID, playerTwoID, playerOneIsWhite, timeFormatInMilliseconds, incrementInMilliseconds, gameHistory, averageElo, whitePlayerElo, blackPlayerElo)
+		return m.InsertNew(playerOneID, playerTwoID, playerOneIsWhite, timeFormatInMilliseconds, incrementInMilliseconds, gameHistory, averageElo, whitePlayerElo, blackPlayerElo, rated)
 	}, waitFor, block)
 	if err != nil {
 		return 0, err
@@ -135,9 +139,9 @@ func (m *LiveMatchModel) EnQueueReturnInsertNew(playerOneID int64, playerTwoID i
 	return coercedResult, nil
 }
 
-func (m *LiveMatchModel) EnQueueInsertNew(playerOneID int64, playerTwoID int64, playerOneIsWhite bool, timeFormatInMilliseconds int64, incrementInMilliseconds int64, gameHistory []byte, averageElo float64, whitePlayerElo int64, blackPlayerElo int64, waitFor *sync.WaitGroup, block *sync.WaitGroup) {
+func (m *LiveMatchModel) EnQueueInsertNew(playerOneID int64, playerTwoID int64, playerOneIsWhite bool, timeFormatInMilliseconds int64, incrementInMilliseconds int64, gameHistory []byte, averageElo float64, whitePlayerElo int64, blackPlayerElo int64, rated bool, waitFor *sync.WaitGroup, block *sync.WaitGroup) {
 	DBTaskQueue.EnQueue(func() (any, error) {
-		return m.InsertNew(playerOneID, playerTwoID, playerOneIsWhite, timeFormatInMilliseconds, incrementInMilliseconds, gameHistory, averageElo, whitePlayerElo, blackPlayerElo)
+		return m.InsertNew(playerOneID, playerTwoID, playerOneIsWhite, timeFormatInMilliseconds, incrementInMilliseconds, gameHistory, averageElo, whitePlayerElo, blackPlayerElo, rated)
 	}, waitFor, block)
 }
 
@@ -159,6 +163,7 @@ func (m *LiveMatchModel) GetFromMatchID(matchID int64) (*LiveMatchWithUsernames,
            live_matches.white_player_elo,
            live_matches.black_player_elo,
            live_matches.match_start_time,
+           live_matches.rated,
 		   white_player.username,
 		   black_player.username
 	  FROM live_matches
@@ -185,6 +190,7 @@ func (m *LiveMatchModel) GetFromMatchID(matchID int64) (*LiveMatchWithUsernames,
 	var whitePlayerElo int64
 	var blackPlayerElo int64
 	var matchStartTime int64
+	var rated bool
 	var whitePlayerUsername sql.NullString
 	var blackPlayerUsername sql.NullString
 
@@ -209,6 +215,7 @@ func (m *LiveMatchModel) GetFromMatchID(matchID int64) (*LiveMatchWithUsernames,
 			&whitePlayerElo,
 			&blackPlayerElo,
 			&matchStartTime,
+			&rated,
 			&whitePlayerUsername,
 			&blackPlayerUsername,
 		},
@@ -234,6 +241,7 @@ func (m *LiveMatchModel) GetFromMatchID(matchID int64) (*LiveMatchWithUsernames,
 		WhitePlayerElo:                       whitePlayerElo,
 		BlackPlayerElo:                       blackPlayerElo,
 		MatchStartTime:                       matchStartTime,
+		Rated:                                rated,
 		WhitePlayerUsername:                  whitePlayerUsername,
 		BlackPlayerUsername:                  blackPlayerUsername,
 	}
@@ -342,7 +350,16 @@ func (m *LiveMatchModel) EnQueueUpdateLiveMatch(matchID int64, newFEN string, la
 	}, waitFor, block)
 }
 
-func (m *LiveMatchModel) MoveMatchToPastMatches(matchID int64, result int, resultReason chess.GameOverStatusCode, whitePlayerEloGain int64, blackPlayerEloGain int64) error {
+// How to rate a finished match, nil when the match is unrated
+type MatchRating struct {
+	System            rating.System
+	WhitePlayerPoints float64
+	BlackPlayerPoints float64
+}
+
+// Moves the match to past_matches and rates it in one transaction
+// A match that has already been moved is left alone, so calling this twice is safe
+func (m *LiveMatchModel) MoveMatchToPastMatches(matchID int64, result int, resultReason chess.GameOverStatusCode, matchRating *MatchRating) error {
 	// outcome int
 	// draw      = 0
 	// whiteWins = 1
@@ -350,7 +367,7 @@ func (m *LiveMatchModel) MoveMatchToPastMatches(matchID int64, result int, resul
 	app.infoLog.Printf("Moving %v to past matches", matchID)
 
 	stepOne := `
-		-- Step 1: Insert row into past_matches table
+		-- Step 1: Insert row into past_matches table, rating changes are filled in by rateMatchInTx
 	INSERT INTO past_matches (
 	    match_id,
 		white_player_id,
@@ -369,14 +386,15 @@ func (m *LiveMatchModel) MoveMatchToPastMatches(matchID int64, result int, resul
         black_player_elo_gain,
 		average_elo,
 		match_start_time,
-		match_end_time
+		match_end_time,
+		rated
 		)
 
 	SELECT match_id,
            white_player_id,
            black_player_id,
-           last_move_piece,
-           last_move_move,
+           COALESCE(last_move_piece, 0),
+           COALESCE(last_move_move, 0),
            current_fen as final_fen,
            time_format_in_milliseconds,
            increment_in_milliseconds,
@@ -385,11 +403,12 @@ func (m *LiveMatchModel) MoveMatchToPastMatches(matchID int64, result int, resul
 		   ?,
 		   white_player_elo,
 		   black_player_elo,
-		   ?,
-		   ?,
+		   0,
+		   0,
 		   average_elo,
 		   match_start_time,
-		   ?
+		   ?,
+		   rated
 	  FROM live_matches
 	 WHERE match_id = ?;`
 
@@ -400,7 +419,6 @@ func (m *LiveMatchModel) MoveMatchToPastMatches(matchID int64, result int, resul
 	`
 
 	var stmtOne, stmtTwo *sql.Stmt
-	// var resultOne, resultTwo sql.Result
 
 	tx, err := m.DB.Begin()
 	if err != nil {
@@ -408,9 +426,16 @@ func (m *LiveMatchModel) MoveMatchToPastMatches(matchID int64, result int, resul
 		return err
 	}
 
+	var rollback = func(context string) {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			app.errorLog.Printf("%s: unable to rollback: %v", context, rollbackErr)
+		}
+	}
+
 	stmtOne, err = tx.Prepare(stepOne)
 	if err != nil {
 		app.errorLog.Printf("Error preparing first statement: %v\n", err)
+		rollback("prepare past_matches insert")
 		return err
 	}
 	defer stmtOne.Close()
@@ -418,28 +443,48 @@ func (m *LiveMatchModel) MoveMatchToPastMatches(matchID int64, result int, resul
 	stmtTwo, err = tx.Prepare(stepTwo)
 	if err != nil {
 		app.errorLog.Printf("Error preparing second statement: %v\n", err)
+		rollback("prepare live_matches delete")
 		return err
 	}
 	defer stmtTwo.Close()
 
-	_, err = ExecStatementWithRetry(stmtOne, result, resultReason, whitePlayerEloGain, blackPlayerEloGain, time.Now().Unix(), matchID)
+	// Writing first takes the write lock before anything is read
+	insertResult, err := ExecStatementWithRetry(stmtOne, result, resultReason, time.Now().Unix(), matchID)
 	if err != nil {
 		app.errorLog.Printf("Error executing first statement: %v\n", err)
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			app.errorLog.Printf("insert past_matches: unable to rollback: %v", rollbackErr)
-		}
+		rollback("insert past_matches")
+		return err
+	}
+
+	rowsInserted, err := insertResult.RowsAffected()
+	if err != nil {
+		app.errorLog.Printf("Error getting rows affected: %v\n", err)
+		rollback("insert past_matches")
 		return err
 	}
+	if rowsInserted == 0 {
+		// Already moved, or never existed
+		app.infoLog.Printf("Match %v is not live, nothing to move\n", matchID)
+		rollback("insert past_matches")
+		return nil
+	}
 
 	_, err = ExecStatementWithRetry(stmtTwo, matchID)
 	if err != nil {
 		app.errorLog.Printf("Error executing second statement: %v\n", err)
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			app.errorLog.Printf("delete live_matches: unable to rollback: %v", rollbackErr)
-		}
+		rollback("delete live_matches")
 		return err
 	}
 
+	if matchRating != nil {
+		err = rateMatchInTx(tx, matchID, matchRating)
+		if err != nil {
+			app.errorLog.Printf("Error rating match %v: %v\n", matchID, err)
+			rollback("rate match")
+			return err
+		}
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		app.errorLog.Printf("Error commiting transaction: %v\n", err)
@@ -449,16 +494,76 @@ func (m *LiveMatchModel) MoveMatchToPastMatches(matchID int64, result int, resul
 	return err
 }
 
-func (m *LiveMatchModel) EnQueueReturnMoveMatchToPastMatches(matchID int64, result int, resultReason chess.GameOverStatusCode, whitePlayerEloGain int64, blackPlayerEloGain int64, waitFor *sync.WaitGroup, block *sync.WaitGroup) error {
+// Updates both players' ratings from their ratings inside tx and stores the rounded changes with the past match
+func rateMatchInTx(tx *sql.Tx, matchID int64, matchRating *MatchRating) error {
+	var whitePlayerID, blackPlayerID, timeFormatInMilliseconds int64
+	err := tx.QueryRow(`
+	SELECT white_player_id, black_player_id, time_format_in_milliseconds
+	  FROM past_matches
+	 WHERE match_id = ?
+	`, matchID).Scan(&whitePlayerID, &blackPlayerID, &timeFormatInMilliseconds)
+	if err != nil {
+		return err
+	}
+
+	var ratingType = GetRatingTypeFromTimeFormat(timeFormatInMilliseconds)
+	var now = time.Now()
+
+	// Logged out players have no ratings, games against them are left unrated
+	whitePlayerRating, err := getRatingInTx(tx, whitePlayerID, ratingType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	blackPlayerRating, err := getRatingInTx(tx, blackPlayerID, ratingType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// Update decays the stored ratings itself, the decayed ones are only kept as the history's old ratings
+	whitePlayerNewRating := matchRating.System.Update(whitePlayerRating, blackPlayerRating, matchRating.WhitePlayerPoints, now)
+	blackPlayerNewRating := matchRating.System.Update(blackPlayerRating, whitePlayerRating, matchRating.BlackPlayerPoints, now)
+	whitePlayerRating = matchRating.System.Decay(whitePlayerRating, now)
+	blackPlayerRating = matchRating.System.Decay(blackPlayerRating, now)
+
+	err = updateRatingInTx(tx, "", whitePlayerID, matchID, ratingType, whitePlayerRating, whitePlayerNewRating, qmPlayerID)
+	if err != nil {
+		return err
+	}
+	err = updateRatingInTx(tx, "", blackPlayerID, matchID, ratingType, blackPlayerRating, blackPlayerNewRating, qmPlayerID)
+	if err != nil {
+		return err
+	}
+
+	updateGains, err := tx.Prepare(`
+	UPDATE past_matches
+	   SET white_player_elo_gain = ?,
+	       black_player_elo_gain = ?
+	 WHERE match_id = ?
+	`)
+	if err != nil {
+		return err
+	}
+	defer updateGains.Close()
+
+	whitePlayerEloGain := whitePlayerNewRating.RoundedRating() - whitePlayerRating.RoundedRating()
+	blackPlayerEloGain := blackPlayerNewRating.RoundedRating() - blackPlayerRating.RoundedRating()
+	_, err = ExecStatementWithRetry(updateGains, whitePlayerEloGain, blackPlayerEloGain, matchID)
+	return err
+}
+
+func (m *LiveMatchModel) EnQueueReturnMoveMatchToPastMatches(matchID int64, result int, resultReason chess.GameOverStatusCode, matchRating *MatchRating, waitFor *sync.WaitGroup, block *sync.WaitGroup) error {
 	err := DBTaskQueue.EnQueueReturnErrorOnlyTask(func() error {
-		return m.MoveMatchToPastMatches(matchID, result, resultReason, whitePlayerEloGain, blackPlayerEloGain)
+		return m.MoveMatchToPastMatches(matchID, result, resultReason, matchRating)
 	}, waitFor, block)
 	return err
 }
 
-func (m *LiveMatchModel) EnQueueMoveMatchToPastMatches(matchID int64, result int, resultReason chess.GameOverStatusCode, whitePlayerEloGain int64, blackPlayerEloGain int64, waitFor *sync.WaitGroup, block *sync.WaitGroup) {
+func (m *LiveMatchModel) EnQueueMoveMatchToPastMatches(matchID int64, result int, resultReason chess.GameOverStatusCode, matchRating *MatchRating, waitFor *sync.WaitGroup, block *sync.WaitGroup) {
 	DBTaskQueue.EnQueueErrorOnlyTask(func() error {
-		return m.MoveMatchToPastMatches(matchID, result, resultReason, whitePlayerEloGain, blackPlayerEloGain)
+		return m.MoveMatchToPastMatches(matchID, result, resultReason, matchRating)
 	}, waitFor, block)
 }
 
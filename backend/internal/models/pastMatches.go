@@ -1,7 +1,9 @@
 package models
 
 import (
+	"burrchess/internal/chess"
 	"database/sql"
+	"time"
 )
 
 // @TODO: DOES SENDING THINGS AS sql.NullType GIVE AWAY THAT IT IS SQL DATABASE?
@@ -47,6 +49,29 @@ type PastMatchSummary struct {
 	MatchEndTime             int64          `json:"matchEndTime"`
 }
 
+type PastMatchWithUsernames struct {
+	MatchID                  int64          `json:"matchID"`
+	WhitePlayerID            int64          `json:"whitePlayerID"`
+	BlackPlayerID            int64          `json:"blackPlayerID"`
+	LastMovePiece            sql.NullInt64  `json:"lastMovePiece"`
+	LastMoveMove             sql.NullInt64  `json:"lastMoveMove"`
+	FinalFEN                 string         `json:"finalFEN"`
+	TimeFormatInMilliseconds int64          `json:"timeFormatInMilliseconds"`
+	IncrementInMilliseconds  int64          `json:"incrementInMilliseconds"`
+	GameHistoryJSONString    []byte         `json:"gameHistoryJSONstring"` // []MatchStateHistory{}
+	Result                   int64          `json:"result"`
+	ResultReason             int64          `json:"resultReason"`
+	WhitePlayerElo           int64          `json:"whitePlayerElo"`
+	BlackPlayerElo           int64          `json:"blackPlayerElo"`
+	WhitePlayerEloGain       int64          `json:"whitePlayerEloGain"`
+	BlackPlayerEloGain       int64          `json:"blackPlayerEloGain"`
+	AverageElo               float64        `json:"averageElo"`
+	MatchStartTime           int64          `json:"matchStartTime"`
+	MatchEndTime             int64          `json:"matchEndTime"`
+	WhitePlayerUsername      sql.NullString `json:"whitePlayerUsername"`
+	BlackPlayerUsername      sql.NullString `json:"blackPlayerUsername"`
+}
+
 type PastMatchModel struct {
 	DB *sql.DB
 }
@@ -198,3 +223,128 @@ func (m *PastMatchModel) GetPastMatchesWithFormat(filters PastMatchFilters) ([]P
 
 	return output, nil
 }
+
+func (m *PastMatchModel) GetFromMatchID(matchID int64) (*PastMatchWithUsernames, error) {
+	sqlStmt := `
+	SELECT m.match_id,
+	       m.white_player_id,
+	       m.black_player_id,
+	       m.last_move_piece,
+	       m.last_move_move,
+	       m.final_fen,
+	       m.time_format_in_milliseconds,
+	       m.increment_in_milliseconds,
+	       m.game_history_json_string,
+	       m.result,
+	       m.result_reason,
+	       m.white_player_elo,
+	       m.black_player_elo,
+	       m.white_player_elo_gain,
+	       m.black_player_elo_gain,
+	       m.average_elo,
+	       m.match_start_time,
+	       m.match_end_time,
+	       white_player.username,
+	       black_player.username
+	  FROM past_matches as m
+	  LEFT JOIN users as white_player
+	    ON m.white_player_id = white_player.player_id
+	  LEFT JOIN users as black_player
+	    ON m.black_player_id = black_player.player_id
+	 WHERE m.match_id = ?
+	`
+
+	var match PastMatchWithUsernames
+
+	err := QueryRowWithRetry(
+		m.DB,
+		sqlStmt,
+		[]any{matchID},
+		[]any{
+			&match.MatchID,
+			&match.WhitePlayerID,
+			&match.BlackPlayerID,
+			&match.LastMovePiece,
+			&match.LastMoveMove,
+			&match.FinalFEN,
+			&match.TimeFormatInMilliseconds,
+			&match.IncrementInMilliseconds,
+			&match.GameHistoryJSONString,
+			&match.Result,
+			&match.ResultReason,
+			&match.WhitePlayerElo,
+			&match.BlackPlayerElo,
+			&match.WhitePlayerEloGain,
+			&match.BlackPlayerEloGain,
+			&match.AverageElo,
+			&match.MatchStartTime,
+			&match.MatchEndTime,
+			&match.WhitePlayerUsername,
+			&match.BlackPlayerUsername,
+		},
+	)
+	if err != nil {
+		app.errorLog.Printf("Error getting past match %v: %s\n", matchID, err.Error())
+		return nil, err
+	}
+
+	return &match, nil
+}
+
+// Opponents playerID finished a match against since the given time, mapped to the end of the latest such match
+// Aborted matches were never played and are left out
+func (m *PastMatchModel) GetRecentOpponents(playerID int64, since time.Time) (map[int64]time.Time, error) {
+	sqlStmt := `
+	SELECT CASE WHEN white_player_id = ? THEN black_player_id ELSE white_player_id END AS opponent_id,
+	       MAX(match_end_time)
+	  FROM past_matches
+	 WHERE (white_player_id = ? OR black_player_id = ?)
+	   AND match_end_time >= ?
+	   AND result_reason != ?
+	 GROUP BY opponent_id
+	`
+
+	rows, err := QueryWithRetry(m.DB, sqlStmt, playerID, playerID, playerID, since.Unix(), chess.Abort)
+	if err != nil {
+		app.errorLog.Printf("Error getting recent opponents of %v: %s\n", playerID, err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	output := make(map[int64]time.Time)
+	for rows.Next() {
+		var opponentID int64
+		var matchEndTime int64
+		err := rows.Scan(&opponentID, &matchEndTime)
+		if err != nil {
+			app.errorLog.Printf("Error in GetRecentOpponents: %s\n", err.Error())
+			return nil, err
+		}
+		output[opponentID] = time.Unix(matchEndTime, 0)
+	}
+
+	return output, rows.Err()
+}
+
+// Games as white minus games as black over the player's last lastGames matches, aborted matches are left out
+func (m *PastMatchModel) GetColourBalance(playerID int64, lastGames int64) (int64, error) {
+	sqlStmt := `
+	SELECT COALESCE(SUM(CASE WHEN white_player_id = ? THEN 1 ELSE -1 END), 0)
+	  FROM (SELECT white_player_id
+	          FROM past_matches
+	         WHERE (white_player_id = ? OR black_player_id = ?)
+	           AND result_reason != ?
+	         ORDER BY match_end_time DESC
+	         LIMIT ?)
+	`
+
+	var balance int64
+	err := QueryRowWithRetry(m.DB, sqlStmt, []any{playerID, playerID, playerID, chess.Abort, lastGames}, []any{&balance})
+	if err != nil {
+		app.errorLog.Printf("Error getting colour balance of %v: %s\n", playerID, err.Error())
+		return 0, err
+	}
+
+	return balance, nil
+}
@@ -3,6 +3,9 @@ DROP TABLE IF EXISTS live_matches;
 DROP TABLE IF EXISTS past_matches;
 DROP TABLE IF EXISTS users;
 DROP TABLE IF EXISTS user_ratings;
+DROP TABLE IF EXISTS chat_messages;
+DROP TABLE IF EXISTS rating_history;
+DROP TABLE IF EXISTS user_blocks;
 
 CREATE TABLE sessions (
 	token TEXT PRIMARY KEY,
@@ -28,7 +31,8 @@ CREATE TABLE live_matches (
     average_elo REAL NOT NULL,
     white_player_elo INTEGER NOT NULL,
     black_player_elo INTEGER NOT NULL,
-    match_start_time INTEGER NOT NULL
+    match_start_time INTEGER NOT NULL,
+    rated INTEGER DEFAULT 1 NOT NULL
 );
 
 CREATE TABLE past_matches (
@@ -49,9 +53,13 @@ CREATE TABLE past_matches (
     black_player_elo_gain INTEGER NOT NULL,
     average_elo REAL NOT NULL,
     match_start_time INTEGER NOT NULL,
-    match_end_time INTEGER NOT NULL
+    match_end_time INTEGER NOT NULL,
+    rated INTEGER DEFAULT 1 NOT NULL
 );
 
+CREATE INDEX past_matches_white_player_id_idx ON past_matches (white_player_id);
+CREATE INDEX past_matches_black_player_id_idx ON past_matches (black_player_id);
+
 CREATE TABLE users (
     player_id INTEGER PRIMARY KEY NOT NULL,
     username TEXT UNIQUE NOT NULL,
@@ -66,10 +74,59 @@ CREATE UNIQUE INDEX users_username_idx ON users (username);
 CREATE TABLE user_ratings (
     player_id INTEGER PRIMARY KEY NOT NULL,
     username TEXT UNIQUE NOT NULL,
-    bullet_rating INTEGER DEFAULT 1500,
-    blitz_rating INTEGER DEFAULT 1500,
-    rapid_rating INTEGER DEFAULT 1500,
-    classical_rating INTEGER DEFAULT 1500
+    -- Glicko-2 deviation and volatility, last_played is unix ms and 0 before the first rated game
+    bullet_rating REAL DEFAULT 1500 NOT NULL,
+    bullet_deviation REAL DEFAULT 350 NOT NULL,
+    bullet_volatility REAL DEFAULT 0.06 NOT NULL,
+    bullet_last_played INTEGER DEFAULT 0 NOT NULL,
+    blitz_rating REAL DEFAULT 1500 NOT NULL,
+    blitz_deviation REAL DEFAULT 350 NOT NULL,
+    blitz_volatility REAL DEFAULT 0.06 NOT NULL,
+    blitz_last_played INTEGER DEFAULT 0 NOT NULL,
+    rapid_rating REAL DEFAULT 1500 NOT NULL,
+    rapid_deviation REAL DEFAULT 350 NOT NULL,
+    rapid_volatility REAL DEFAULT 0.06 NOT NULL,
+    rapid_last_played INTEGER DEFAULT 0 NOT NULL,
+    classical_rating REAL DEFAULT 1500 NOT NULL,
+    classical_deviation REAL DEFAULT 350 NOT NULL,
+    classical_volatility REAL DEFAULT 0.06 NOT NULL,
+    classical_last_played INTEGER DEFAULT 0 NOT NULL
+);
+
+CREATE UNIQUE INDEX user_ratings_username_idx ON user_ratings (username);
+
+CREATE TABLE chat_messages (
+    message_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    match_id INTEGER NOT NULL,
+    player_id INTEGER NOT NULL,
+    sender TEXT NOT NULL,
+    channel TEXT NOT NULL,
+    message_content TEXT NOT NULL,
+    unix_ms_time_sent INTEGER NOT NULL
+);
+
+CREATE INDEX chat_messages_match_id_idx ON chat_messages (match_id);
+
+-- One row per rating change, rating_type is the time class name
+CREATE TABLE rating_history (
+    history_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    player_id INTEGER NOT NULL,
+    match_id INTEGER NOT NULL,
+    rating_type TEXT NOT NULL,
+    rating_before REAL NOT NULL,
+    rating_after REAL NOT NULL,
+    deviation_after REAL NOT NULL,
+    unix_ms_time INTEGER NOT NULL
+);
+
+CREATE INDEX rating_history_player_id_rating_type_idx ON rating_history (player_id, rating_type);
+
+-- blocker_id never gets paired with blocked_id, in either direction
+CREATE TABLE user_blocks (
+    blocker_id INTEGER NOT NULL,
+    blocked_id INTEGER NOT NULL,
+    unix_ms_time INTEGER NOT NULL,
+    PRIMARY KEY (blocker_id, blocked_id)
 );
 
-CREATE UNIQUE INDEX user_ratings_username_idx ON user_ratings (username);
\ No newline at end of file
+CREATE INDEX user_blocks_blocked_id_idx ON user_blocks (blocked_id);
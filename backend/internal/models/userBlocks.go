@@ -0,0 +1,159 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Blocks are one directional rows, but they keep both players apart
+type UserBlockModel struct {
+	DB *sql.DB
+}
+
+func (m *UserBlockModel) Block(blockerID int64, blockedID int64) error {
+	sqlStmt := `
+	INSERT INTO user_blocks (
+	    blocker_id,
+		blocked_id,
+		unix_ms_time
+		) VALUES(?, ?, ?)
+	ON CONFLICT (blocker_id, blocked_id) DO NOTHING;
+	`
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		app.errorLog.Printf("Error starting transaction: %v\n", err)
+		return err
+	}
+
+	insertStmt, err := tx.Prepare(sqlStmt)
+	if err != nil {
+		app.errorLog.Printf("Error preparing statement: %v\n", err)
+		return err
+	}
+	defer insertStmt.Close()
+
+	_, err = ExecStatementWithRetry(insertStmt, blockerID, blockedID, time.Now().UnixMilli())
+	if err != nil {
+		app.errorLog.Printf("Error executing statement: %v\n", err)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			app.errorLog.Printf("insert user_blocks: unable to rollback: %v", rollbackErr)
+		}
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.errorLog.Printf("Error commiting transaction in Block: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *UserBlockModel) Unblock(blockerID int64, blockedID int64) error {
+	sqlStmt := `
+	DELETE FROM user_blocks
+	 WHERE blocker_id = ? AND blocked_id = ?;
+	`
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		app.errorLog.Printf("Error starting transaction: %v\n", err)
+		return err
+	}
+
+	deleteStmt, err := tx.Prepare(sqlStmt)
+	if err != nil {
+		app.errorLog.Printf("Error preparing statement: %v\n", err)
+		return err
+	}
+	defer deleteStmt.Close()
+
+	_, err = ExecStatementWithRetry(deleteStmt, blockerID, blockedID)
+	if err != nil {
+		app.errorLog.Printf("Error executing statement: %v\n", err)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			app.errorLog.Printf("delete user_blocks: unable to rollback: %v", rollbackErr)
+		}
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.errorLog.Printf("Error commiting transaction in Unblock: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// Only whether blockerID blocked blockedID, not the other way around
+func (m *UserBlockModel) HasBlocked(blockerID int64, blockedID int64) (bool, error) {
+	sqlStmt := `
+	SELECT EXISTS (
+	    SELECT 1
+	      FROM user_blocks
+	     WHERE blocker_id = ? AND blocked_id = ?
+	)
+	`
+
+	var blocked bool
+	err := QueryRowWithRetry(m.DB, sqlStmt, []any{blockerID, blockedID}, []any{&blocked})
+	if err != nil {
+		app.errorLog.Printf("Error checking block of %v by %v: %s\n", blockedID, blockerID, err.Error())
+		return false, err
+	}
+
+	return blocked, nil
+}
+
+func (m *UserBlockModel) IsBlockedEitherWay(playerOneID int64, playerTwoID int64) (bool, error) {
+	sqlStmt := `
+	SELECT EXISTS (
+	    SELECT 1
+	      FROM user_blocks
+	     WHERE (blocker_id = ? AND blocked_id = ?)
+	        OR (blocker_id = ? AND blocked_id = ?)
+	)
+	`
+
+	var blocked bool
+	err := QueryRowWithRetry(m.DB, sqlStmt, []any{playerOneID, playerTwoID, playerTwoID, playerOneID}, []any{&blocked})
+	if err != nil {
+		app.errorLog.Printf("Error checking blocks between %v and %v: %s\n", playerOneID, playerTwoID, err.Error())
+		return false, err
+	}
+
+	return blocked, nil
+}
+
+// Players playerID blocked or was blocked by
+func (m *UserBlockModel) GetBlockedEitherWay(playerID int64) (map[int64]bool, error) {
+	sqlStmt := `
+	SELECT blocked_id FROM user_blocks WHERE blocker_id = ?
+	 UNION
+	SELECT blocker_id FROM user_blocks WHERE blocked_id = ?
+	`
+
+	rows, err := QueryWithRetry(m.DB, sqlStmt, playerID, playerID)
+	if err != nil {
+		app.errorLog.Printf("Error getting blocks of %v: %s\n", playerID, err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	output := make(map[int64]bool)
+	for rows.Next() {
+		var otherID int64
+		err := rows.Scan(&otherID)
+		if err != nil {
+			app.errorLog.Printf("Error in GetBlockedEitherWay: %s\n", err.Error())
+			return nil, err
+		}
+		output[otherID] = true
+	}
+
+	return output, rows.Err()
+}
@@ -2,8 +2,11 @@ package models
 
 import (
 	"burrchess/internal/chess"
+	"burrchess/internal/rating"
 	"database/sql"
 	"errors"
+	"fmt"
+	"time"
 )
 
 type RatingType int
@@ -20,23 +23,24 @@ type UserRatingsModel struct {
 }
 
 type UserRatings struct {
-	PlayerID        int64  `json:"playerID"`
-	Username        string `json:"username"`
-	BulletRating    int64  `json:"bulletRating"`
-	BlitzRating     int64  `json:"blitzRating"`
-	RapidRating     int64  `json:"rapidRating"`
-	ClassicalRating int64  `json:"classicalRating"`
+	PlayerID  int64         `json:"playerID"`
+	Username  string        `json:"username"`
+	Bullet    rating.Player `json:"bullet"`
+	Blitz     rating.Player `json:"blitz"`
+	Rapid     rating.Player `json:"rapid"`
+	Classical rating.Player `json:"classical"`
 }
 
-func (u *UserRatings) GetRatingForTimeFormat(timeFormatInMilliseconds int64) int64 {
-	if timeFormatInMilliseconds < chess.Bullet[1] {
-		return u.BulletRating
-	} else if timeFormatInMilliseconds < chess.Blitz[1] {
-		return u.BlitzRating
-	} else if timeFormatInMilliseconds < chess.Rapid[1] {
-		return u.RapidRating
-	} else {
-		return u.ClassicalRating
+func (u *UserRatings) GetRatingForTimeFormat(timeFormatInMilliseconds int64) rating.Player {
+	switch GetRatingTypeFromTimeFormat(timeFormatInMilliseconds) {
+	case bullet:
+		return u.Bullet
+	case blitz:
+		return u.Blitz
+	case rapid:
+		return u.Rapid
+	default:
+		return u.Classical
 	}
 }
 
@@ -52,35 +56,64 @@ func GetRatingTypeFromTimeFormat(timeFormatInMilliseconds int64) RatingType {
 	}
 }
 
+// Column prefix in user_ratings and rating_type in rating_history
+func (ratingType RatingType) String() string {
+	switch ratingType {
+	case bullet:
+		return "bullet"
+	case blitz:
+		return "blitz"
+	case rapid:
+		return "rapid"
+	default:
+		return "classical"
+	}
+}
+
+func RatingTypeFromString(name string) (RatingType, bool) {
+	for _, ratingType := range []RatingType{bullet, blitz, rapid, classical} {
+		if ratingType.String() == name {
+			return ratingType, true
+		}
+	}
+	return 0, false
+}
+
+type RatingHistoryEntry struct {
+	MatchID        int64   `json:"matchID"`
+	RatingBefore   float64 `json:"ratingBefore"`
+	RatingAfter    float64 `json:"ratingAfter"`
+	DeviationAfter float64 `json:"deviationAfter"`
+	UnixMsTime     int64   `json:"unixMsTime"`
+}
+
 func (m *UserRatingsModel) getRating(username string, playerID int64, queryMode QueryMode) (UserRatings, error) {
 	sqlStmt := `
 	SELECT player_id,
 	       username,
-		   bullet_rating,
-		   blitz_rating,
-		   rapid_rating,
-		   classical_rating  
+		   bullet_rating, bullet_deviation, bullet_volatility, bullet_last_played,
+		   blitz_rating, blitz_deviation, blitz_volatility, blitz_last_played,
+		   rapid_rating, rapid_deviation, rapid_volatility, rapid_last_played,
+		   classical_rating, classical_deviation, classical_volatility, classical_last_played
 	  FROM user_ratings
 	`
 	app.infoLog.Printf("Getting rating for username: %s, or playerID: %v\n", username, playerID)
 
-	var _playerID int64
-	var _username string
-	var bulletRating int64
-	var blitzRating int64
-	var rapidRating int64
-	var classicalRating int64
-	var row *sql.Row
+	var userRatings UserRatings
+	var players = [4]*rating.Player{&userRatings.Bullet, &userRatings.Blitz, &userRatings.Rapid, &userRatings.Classical}
+	var lastPlayed [4]int64
+	var dest = []any{&userRatings.PlayerID, &userRatings.Username}
+	for i, player := range players {
+		dest = append(dest, &player.Rating, &player.Deviation, &player.Volatility, &lastPlayed[i])
+	}
 	var err error
 
 	if queryMode == qmUsername {
 		sqlStmt += ` WHERE username = ?`
-		err = QueryRowWithRetry(m.DB, sqlStmt, []any{username}, []any{&_playerID, &_username, &bulletRating, &blitzRating, &rapidRating, &classicalRating})
-		app.rowsLog.Println(row)
+		err = QueryRowWithRetry(m.DB, sqlStmt, []any{username}, dest)
 	} else if queryMode == qmPlayerID {
 		sqlStmt += ` WHERE player_id = ?`
-		err = QueryRowWithRetry(m.DB, sqlStmt, []any{playerID}, []any{&_playerID, &_username, &bulletRating, &blitzRating, &rapidRating, &classicalRating})
-		app.rowsLog.Println(row)
+		err = QueryRowWithRetry(m.DB, sqlStmt, []any{playerID}, dest)
 	} else {
 		return UserRatings{}, errors.New("queryMode unknown")
 	}
@@ -89,14 +122,13 @@ func (m *UserRatingsModel) getRating(username string, playerID int64, queryMode
 		app.errorLog.Printf("Error getting user_ratings: %s\n", err.Error())
 		return UserRatings{}, err
 	}
-	return UserRatings{
-		PlayerID:        _playerID,
-		Username:        _username,
-		BulletRating:    bulletRating,
-		BlitzRating:     blitzRating,
-		RapidRating:     rapidRating,
-		ClassicalRating: classicalRating,
-	}, nil
+
+	for i, player := range players {
+		if lastPlayed[i] != 0 {
+			player.LastPlayed = time.UnixMilli(lastPlayed[i])
+		}
+	}
+	return userRatings, nil
 }
 
 func (m *UserRatingsModel) GetRatingFromUsername(username string) (UserRatings, error) {
@@ -107,37 +139,69 @@ func (m *UserRatingsModel) GetRatingFromPlayerID(playerID int64) (UserRatings, e
 	return m.getRating("", playerID, qmPlayerID)
 }
 
-func (m *UserRatingsModel) updateRating(username string, playerID int64, ratingType RatingType, newRating int64, queryMode QueryMode) error {
-	app.infoLog.Printf("Updating rating to %v\n", newRating)
+func (m *UserRatingsModel) updateRating(username string, playerID int64, matchID int64, ratingType RatingType, oldRating rating.Player, newRating rating.Player, queryMode QueryMode) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		app.errorLog.Printf("Error starting transaction: %v\n", err)
+		return err
+	}
 
-	sqlStmt := `
-	UPDATE user_ratings
-	`
+	err = updateRatingInTx(tx, username, playerID, matchID, ratingType, oldRating, newRating, queryMode)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			app.errorLog.Printf("updateRating: unable to rollback: %v", rollbackErr)
+		}
+		return err
+	}
 
-	switch ratingType {
-	case bullet:
-		sqlStmt += " SET bullet_rating = ?"
-	case blitz:
-		sqlStmt += " SET blitz_rating = ?"
-	case rapid:
-		sqlStmt += " SET rapid_rating = ?"
-	case classical:
-		sqlStmt += " SET classical_rating = ?"
+	err = tx.Commit()
+	if err != nil {
+		app.errorLog.Printf("Error commiting transaction in updateRating: %v\n", err)
+		return err
 	}
-	var err error
 
+	return err
+}
+
+// Updates the rating and records the change in rating_history, committing or rolling back tx is left to the caller
+func updateRatingInTx(tx *sql.Tx, username string, playerID int64, matchID int64, ratingType RatingType, oldRating rating.Player, newRating rating.Player, queryMode QueryMode) error {
+	app.infoLog.Printf("Updating rating to %+v\n", newRating)
+
+	sqlStmt := fmt.Sprintf(`
+	UPDATE user_ratings
+	   SET %[1]s_rating = ?, %[1]s_deviation = ?, %[1]s_volatility = ?, %[1]s_last_played = ?
+	`, ratingType)
+
+	historyStmt := `
+	INSERT INTO rating_history (
+	    player_id,
+		match_id,
+		rating_type,
+		rating_before,
+		rating_after,
+		deviation_after,
+		unix_ms_time
+		)
+	SELECT player_id, ?, ?, ?, ?, ?, ?
+	  FROM user_ratings
+	`
+
+	var where any
 	if queryMode == qmUsername {
 		sqlStmt += ` WHERE username = ?`
+		historyStmt += ` WHERE username = ?`
+		where = username
 	} else if queryMode == qmPlayerID {
 		sqlStmt += ` WHERE player_id = ?`
+		historyStmt += ` WHERE player_id = ?`
+		where = playerID
 	} else {
 		return errors.New("queryMode unknown")
 	}
 
-	tx, err := m.DB.Begin()
-	if err != nil {
-		app.errorLog.Printf("Error starting transaction: %v\n", err)
-		return err
+	var lastPlayed int64
+	if !newRating.LastPlayed.IsZero() {
+		lastPlayed = newRating.LastPlayed.UnixMilli()
 	}
 
 	stmtOne, err := tx.Prepare(sqlStmt)
@@ -147,35 +211,98 @@ func (m *UserRatingsModel) updateRating(username string, playerID int64, ratingT
 	}
 	defer stmtOne.Close()
 
-	if queryMode == qmUsername {
-		_, err = ExecStatementWithRetry(stmtOne, newRating, username)
-	} else if queryMode == qmPlayerID {
-		_, err = ExecStatementWithRetry(stmtOne, newRating, playerID)
+	stmtTwo, err := tx.Prepare(historyStmt)
+	if err != nil {
+		app.errorLog.Printf("Error preparing second statement: %v\n", err)
+		return err
 	}
+	defer stmtTwo.Close()
 
+	_, err = ExecStatementWithRetry(stmtOne, newRating.Rating, newRating.Deviation, newRating.Volatility, lastPlayed, where)
 	if err != nil {
 		app.errorLog.Printf("Error executing statement: %v\n", err)
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			app.errorLog.Printf("updateRating: unable to rollback: %v", rollbackErr)
-		}
 		return err
 	}
 
-	err = tx.Commit()
+	_, err = ExecStatementWithRetry(stmtTwo, matchID, ratingType.String(), oldRating.Rating, newRating.Rating, newRating.Deviation, time.Now().UnixMilli(), where)
 	if err != nil {
-		app.errorLog.Printf("Error commiting transaction in updateRating: %v\n", err)
+		app.errorLog.Printf("Error executing second statement: %v\n", err)
 		return err
 	}
 
-	return err
+	return nil
+}
+
+// Reads one time class inside tx, so the rating cannot change before tx commits
+func getRatingInTx(tx *sql.Tx, playerID int64, ratingType RatingType) (rating.Player, error) {
+	sqlStmt := fmt.Sprintf(`
+	SELECT %[1]s_rating, %[1]s_deviation, %[1]s_volatility, %[1]s_last_played
+	  FROM user_ratings
+	 WHERE player_id = ?
+	`, ratingType)
+
+	var player rating.Player
+	var lastPlayed int64
+	err := tx.QueryRow(sqlStmt, playerID).Scan(&player.Rating, &player.Deviation, &player.Volatility, &lastPlayed)
+	if err != nil {
+		app.errorLog.Printf("Error getting %v rating for player %v: %v\n", ratingType, playerID, err)
+		return rating.Player{}, err
+	}
+
+	if lastPlayed != 0 {
+		player.LastPlayed = time.UnixMilli(lastPlayed)
+	}
+	return player, nil
 }
 
-func (m *UserRatingsModel) UpdateRatingFromUsername(username string, ratingType RatingType, newRating int64) error {
-	return m.updateRating(username, 0, ratingType, newRating, qmUsername)
+func (m *UserRatingsModel) UpdateRatingFromUsername(username string, matchID int64, ratingType RatingType, oldRating rating.Player, newRating rating.Player) error {
+	return m.updateRating(username, 0, matchID, ratingType, oldRating, newRating, qmUsername)
 }
 
-func (m *UserRatingsModel) UpdateRatingFromPlayerID(playerID int64, ratingType RatingType, newRating int64) error {
-	return m.updateRating("", playerID, ratingType, newRating, qmPlayerID)
+func (m *UserRatingsModel) UpdateRatingFromPlayerID(playerID int64, matchID int64, ratingType RatingType, oldRating rating.Player, newRating rating.Player) error {
+	return m.updateRating("", playerID, matchID, ratingType, oldRating, newRating, qmPlayerID)
+}
+
+// Oldest first
+func (m *UserRatingsModel) GetRatingHistoryFromPlayerID(playerID int64, ratingType RatingType) ([]RatingHistoryEntry, error) {
+	sqlStmt := `
+	SELECT match_id,
+	       rating_before,
+	       rating_after,
+	       deviation_after,
+	       unix_ms_time
+	  FROM rating_history
+	 WHERE player_id = ?
+	   AND rating_type = ?
+	 ORDER BY history_id
+	`
+
+	rows, err := QueryWithRetry(m.DB, sqlStmt, playerID, ratingType.String())
+	if err != nil {
+		app.errorLog.Printf("Error getting rating history for player %v: %s\n", playerID, err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	output := []RatingHistoryEntry{}
+	for rows.Next() {
+		var entry RatingHistoryEntry
+		err := rows.Scan(
+			&entry.MatchID,
+			&entry.RatingBefore,
+			&entry.RatingAfter,
+			&entry.DeviationAfter,
+			&entry.UnixMsTime,
+		)
+		if err != nil {
+			app.errorLog.Printf("Error in GetRatingHistoryFromPlayerID: %s\n", err.Error())
+			return nil, err
+		}
+		output = append(output, entry)
+	}
+
+	return output, rows.Err()
 }
 
 func (m *UserRatingsModel) LogAll() {
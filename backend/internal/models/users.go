@@ -1,8 +1,10 @@
 package models
 
 import (
+	"burrchess/internal/chess"
 	"database/sql"
 	"errors"
+	"math"
 	"math/rand"
 	"strings"
 	"time"
@@ -64,10 +66,19 @@ type UserClientSide struct {
 }
 
 type Ratings struct {
-	BulletRating    int64 `json:"bullet"`
-	BlitzRating     int64 `json:"blitz"`
-	RapidRating     int64 `json:"rapid"`
-	ClassicalRating int64 `json:"classical"`
+	BulletRating    int64              `json:"bullet"`
+	BlitzRating     int64              `json:"blitz"`
+	RapidRating     int64              `json:"rapid"`
+	ClassicalRating int64              `json:"classical"`
+	Provisional     ProvisionalRatings `json:"provisional"`
+}
+
+// Left for the caller to fill in, only the rating system knows when a rating has settled
+type ProvisionalRatings struct {
+	Bullet    bool `json:"bullet"`
+	Blitz     bool `json:"blitz"`
+	Rapid     bool `json:"rapid"`
+	Classical bool `json:"classical"`
 }
 
 type UserTileInfo struct {
@@ -505,10 +516,10 @@ func (m *UserModel) GetTileInfoFromUsername(username string) (*UserTileInfo, err
 	var playerID int64
 	var joinDate int64
 	var lastSeen int64
-	var bullet_rating int64
-	var blitz_rating int64
-	var rapid_rating int64
-	var classical_rating int64
+	var bullet_rating float64
+	var blitz_rating float64
+	var rapid_rating float64
+	var classical_rating float64
 
 	err := QueryRowWithRetry(m.DB, sqlStmt, []any{username}, []any{&playerID, &joinDate, &lastSeen, &bullet_rating, &blitz_rating, &rapid_rating, &classical_rating})
 	if err != nil {
@@ -516,6 +527,7 @@ func (m *UserModel) GetTileInfoFromUsername(username string) (*UserTileInfo, err
 		return nil, err
 	}
 
+	// Aborted games are kept in past_matches but do not count as games played
 	sqlStmt = `
 	SELECT Count(past_matches.match_id) as number_of_games
 	  FROM users
@@ -523,11 +535,12 @@ func (m *UserModel) GetTileInfoFromUsername(username string) (*UserTileInfo, err
 	    ON past_matches.white_player_id = users.player_id
 		OR past_matches.black_player_id = users.player_id
 	 WHERE users.username = ?
+	   AND past_matches.result_reason != ?
 	`
 
 	var numberOfGames int64
 
-	err = QueryRowWithRetry(m.DB, sqlStmt, []any{username}, []any{&numberOfGames})
+	err = QueryRowWithRetry(m.DB, sqlStmt, []any{username, chess.Abort}, []any{&numberOfGames})
 	if err != nil {
 		app.errorLog.Printf("Error in GetTileInfoFromPlayerID gameCount: %s\n", err.Error())
 		return nil, err
@@ -542,10 +555,10 @@ func (m *UserModel) GetTileInfoFromUsername(username string) (*UserTileInfo, err
 		JoinDate:   joinDate,
 		LastSeen:   lastSeen,
 		Ratings: Ratings{
-			BulletRating:    bullet_rating,
-			BlitzRating:     blitz_rating,
-			RapidRating:     rapid_rating,
-			ClassicalRating: classical_rating,
+			BulletRating:    int64(math.Round(bullet_rating)),
+			BlitzRating:     int64(math.Round(blitz_rating)),
+			RapidRating:     int64(math.Round(rapid_rating)),
+			ClassicalRating: int64(math.Round(classical_rating)),
 		},
 		NumberOfGames: numberOfGames,
 	}, nil
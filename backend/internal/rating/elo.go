@@ -0,0 +1,59 @@
+package rating
+
+import (
+	"math"
+	"time"
+)
+
+// Points a player scores from a game
+const (
+	Loss = 0.0
+	Draw = 0.5
+	Win  = 1.0
+)
+
+func KFactor(rating int64) float64 {
+	if rating < 2100 {
+		return 32
+	} else if rating <= 2400 {
+		return 24
+	} else {
+		return 16
+	}
+}
+
+// Points a player is expected to score against opponentRating, see https://en.wikipedia.org/wiki/Elo_rating_system
+func ExpectedScore(rating int64, opponentRating int64) float64 {
+	return 1 / (1 + math.Pow(10, float64(opponentRating-rating)/400))
+}
+
+// Unrounded rating change after scoring points against opponentRating
+func EloChange(rating int64, opponentRating int64, points float64) float64 {
+	return KFactor(rating) * (points - ExpectedScore(rating, opponentRating))
+}
+
+// Rating after the game, rounded to a whole point and never below zero
+func NewRating(rating int64, opponentRating int64, points float64) int64 {
+	return int64(math.Max(float64(rating)+math.Round(EloChange(rating, opponentRating, points)), 0))
+}
+
+// Plain Elo, ratings carry no deviation so nobody is ever provisional
+type Elo struct{}
+
+func (Elo) NewPlayer() Player {
+	return Player{Rating: DefaultRating}
+}
+
+func (Elo) Decay(player Player, now time.Time) Player {
+	return player
+}
+
+func (Elo) Update(player Player, opponent Player, points float64, now time.Time) Player {
+	player.Rating = float64(NewRating(player.RoundedRating(), opponent.RoundedRating(), points))
+	player.LastPlayed = now
+	return player
+}
+
+func (Elo) IsProvisional(player Player) bool {
+	return false
+}
@@ -0,0 +1,101 @@
+package rating
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestKFactor(t *testing.T) {
+	tests := []struct {
+		rating int64
+		want   float64
+	}{
+		{1500, 32},
+		{2099, 32},
+		{2100, 24},
+		{2400, 24},
+		{2401, 16},
+	}
+
+	for _, test := range tests {
+		if got := KFactor(test.rating); got != test.want {
+			t.Errorf("KFactor(%v) = %v, want %v", test.rating, got, test.want)
+		}
+	}
+}
+
+func TestExpectedScore(t *testing.T) {
+	tests := []struct {
+		rating         int64
+		opponentRating int64
+		want           float64
+	}{
+		{1500, 1500, 0.5},
+		{1900, 1500, 10.0 / 11.0},
+		{1500, 1900, 1.0 / 11.0},
+		{2000, 1200, 100.0 / 101.0},
+	}
+
+	for _, test := range tests {
+		got := ExpectedScore(test.rating, test.opponentRating)
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("ExpectedScore(%v, %v) = %v, want %v", test.rating, test.opponentRating, got, test.want)
+		}
+
+		// Both players' expected scores always add up to one game
+		total := got + ExpectedScore(test.opponentRating, test.rating)
+		if math.Abs(total-1) > 1e-9 {
+			t.Errorf("ExpectedScore(%v, %v) plus its reverse = %v, want 1", test.rating, test.opponentRating, total)
+		}
+	}
+}
+
+func TestNewRating(t *testing.T) {
+	tests := []struct {
+		name           string
+		rating         int64
+		opponentRating int64
+		points         float64
+		want           int64
+	}{
+		{"even win", 1500, 1500, Win, 1516},
+		{"even draw", 1500, 1500, Draw, 1500},
+		{"even loss", 1500, 1500, Loss, 1484},
+		{"favourite wins", 1900, 1500, Win, 1903},
+		{"favourite draws", 1900, 1500, Draw, 1887},
+		{"favourite loses", 1900, 1500, Loss, 1871},
+		{"underdog wins", 1500, 1900, Win, 1529},
+		{"underdog loses", 1500, 1900, Loss, 1497},
+		{"lower K factor", 2500, 2500, Win, 2508},
+		{"never below zero", 10, 10, Loss, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := NewRating(test.rating, test.opponentRating, test.points); got != test.want {
+				t.Errorf("NewRating(%v, %v, %v) = %v, want %v", test.rating, test.opponentRating, test.points, got, test.want)
+			}
+		})
+	}
+}
+
+func TestPreviewChanges(t *testing.T) {
+	tests := []struct {
+		rating         int64
+		opponentRating int64
+		want           Preview
+	}{
+		{1500, 1500, Preview{Win: 16, Draw: 0, Loss: -16}},
+		{1900, 1500, Preview{Win: 3, Draw: -13, Loss: -29}},
+		{1500, 1900, Preview{Win: 29, Draw: 13, Loss: -3}},
+	}
+
+	for _, test := range tests {
+		player := Player{Rating: float64(test.rating)}
+		opponent := Player{Rating: float64(test.opponentRating)}
+		if got := PreviewChanges(Elo{}, player, opponent, time.Now()); got != test.want {
+			t.Errorf("PreviewChanges(%v, %v) = %+v, want %+v", test.rating, test.opponentRating, got, test.want)
+		}
+	}
+}
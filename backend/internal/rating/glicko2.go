@@ -0,0 +1,154 @@
+package rating
+
+import (
+	"math"
+	"time"
+)
+
+// Glicko-2, see http://www.glicko.net/glicko/glicko2.pdf
+// Every game is rated on its own as a rating period of one game, as on most online sites
+
+// Converts between the Glicko and Glicko-2 scales
+const glicko2Scale = 173.7178
+
+// Convergence tolerance of the volatility iteration
+const volatilityTolerance = 0.000001
+
+type Glicko2 struct {
+	// Constrains volatility changes, Glickman suggests 0.3 to 1.2
+	Tau               float64
+	InitialDeviation  float64
+	InitialVolatility float64
+	// Deviation never shrinks below MinDeviation, so established ratings can still move
+	MinDeviation float64
+	// Ratings with a deviation above ProvisionalDeviation are provisional
+	ProvisionalDeviation float64
+	// Inactivity grows the deviation by one period's volatility every RatingPeriod
+	RatingPeriod time.Duration
+}
+
+func DefaultGlicko2() Glicko2 {
+	return Glicko2{
+		Tau:                  0.5,
+		InitialDeviation:     350,
+		InitialVolatility:    0.06,
+		MinDeviation:         45,
+		ProvisionalDeviation: 110,
+		RatingPeriod:         24 * time.Hour,
+	}
+}
+
+func (g Glicko2) NewPlayer() Player {
+	return Player{
+		Rating:     DefaultRating,
+		Deviation:  g.InitialDeviation,
+		Volatility: g.InitialVolatility,
+	}
+}
+
+func (g Glicko2) Decay(player Player, now time.Time) Player {
+	if player.LastPlayed.IsZero() || !now.After(player.LastPlayed) {
+		return player
+	}
+
+	var periods = float64(now.Sub(player.LastPlayed)) / float64(g.RatingPeriod)
+	var phi = player.Deviation / glicko2Scale
+	phi = math.Sqrt(phi*phi + periods*player.Volatility*player.Volatility)
+	player.Deviation = math.Min(phi*glicko2Scale, g.InitialDeviation)
+	return player
+}
+
+func (g Glicko2) Update(player Player, opponent Player, points float64, now time.Time) Player {
+	player = g.Decay(player, now)
+	opponent = g.Decay(opponent, now)
+
+	player = g.rate(player, []glicko2Game{{opponent: opponent, points: points}})
+	player.LastPlayed = now
+	return player
+}
+
+func (g Glicko2) IsProvisional(player Player) bool {
+	return player.Deviation > g.ProvisionalDeviation
+}
+
+type glicko2Game struct {
+	opponent Player
+	points   float64
+}
+
+// Lowers the weight of a result against an opponent whose rating is uncertain
+func glicko2G(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func glicko2E(mu float64, opponentMu float64, opponentPhi float64) float64 {
+	return 1 / (1 + math.Exp(-glicko2G(opponentPhi)*(mu-opponentMu)))
+}
+
+// Steps 2 to 8 of the paper for one rating period
+func (g Glicko2) rate(player Player, games []glicko2Game) Player {
+	var mu = (player.Rating - DefaultRating) / glicko2Scale
+	var phi = player.Deviation / glicko2Scale
+	var sigma = player.Volatility
+
+	var vInverse, deltaSum float64
+	for _, game := range games {
+		opponentMu := (game.opponent.Rating - DefaultRating) / glicko2Scale
+		opponentPhi := game.opponent.Deviation / glicko2Scale
+		gPhi := glicko2G(opponentPhi)
+		e := glicko2E(mu, opponentMu, opponentPhi)
+		vInverse += gPhi * gPhi * e * (1 - e)
+		deltaSum += gPhi * (game.points - e)
+	}
+	var v = 1 / vInverse
+	var delta = v * deltaSum
+
+	sigma = g.newVolatility(phi, sigma, v, delta)
+
+	var phiStar = math.Sqrt(phi*phi + sigma*sigma)
+	var newPhi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	var newMu = mu + newPhi*newPhi*deltaSum
+
+	return Player{
+		Rating:     newMu*glicko2Scale + DefaultRating,
+		Deviation:  math.Max(math.Min(newPhi*glicko2Scale, g.InitialDeviation), g.MinDeviation),
+		Volatility: sigma,
+		LastPlayed: player.LastPlayed,
+	}
+}
+
+// Step 5, the Illinois algorithm
+func (g Glicko2) newVolatility(phi float64, sigma float64, v float64, delta float64) float64 {
+	var a = math.Log(sigma * sigma)
+	var f = func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-d)/(2*d*d) - (x-a)/(g.Tau*g.Tau)
+	}
+
+	var A = a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*g.Tau) < 0 {
+			k += 1
+		}
+		B = a - k*g.Tau
+	}
+
+	var fA, fB = f(A), f(B)
+	for math.Abs(B-A) > volatilityTolerance {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+
+	return math.Exp(A / 2)
+}
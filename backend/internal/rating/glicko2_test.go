@@ -0,0 +1,131 @@
+package rating
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// The worked example from Glickman's paper
+func TestGlicko2PaperExample(t *testing.T) {
+	var g = DefaultGlicko2()
+	g.MinDeviation = 0
+
+	player := Player{Rating: 1500, Deviation: 200, Volatility: 0.06}
+	games := []glicko2Game{
+		{opponent: Player{Rating: 1400, Deviation: 30}, points: Win},
+		{opponent: Player{Rating: 1550, Deviation: 100}, points: Loss},
+		{opponent: Player{Rating: 1700, Deviation: 300}, points: Loss},
+	}
+
+	got := g.rate(player, games)
+	if math.Abs(got.Rating-1464.06) > 0.01 {
+		t.Errorf("rating = %v, want 1464.06", got.Rating)
+	}
+	if math.Abs(got.Deviation-151.52) > 0.01 {
+		t.Errorf("deviation = %v, want 151.52", got.Deviation)
+	}
+	if math.Abs(got.Volatility-0.05999) > 0.00001 {
+		t.Errorf("volatility = %v, want 0.05999", got.Volatility)
+	}
+}
+
+func TestGlicko2NewPlayerMovesEstablishedPlayerLess(t *testing.T) {
+	var g = DefaultGlicko2()
+	var now = time.Now()
+
+	established := Player{Rating: 1500, Deviation: 60, Volatility: 0.06, LastPlayed: now}
+	newcomer := g.NewPlayer()
+	peer := Player{Rating: 1500, Deviation: 60, Volatility: 0.06, LastPlayed: now}
+
+	lossToNewcomer := established.Rating - g.Update(established, newcomer, Loss, now).Rating
+	lossToPeer := established.Rating - g.Update(established, peer, Loss, now).Rating
+	if lossToNewcomer >= lossToPeer {
+		t.Errorf("losing to a new player cost %v, losing to an established one cost %v", lossToNewcomer, lossToPeer)
+	}
+
+	newcomerGain := g.Update(newcomer, established, Win, now).Rating - newcomer.Rating
+	if newcomerGain <= lossToNewcomer {
+		t.Errorf("new player gained %v, established player lost only %v", newcomerGain, lossToNewcomer)
+	}
+}
+
+func TestGlicko2Decay(t *testing.T) {
+	var g = DefaultGlicko2()
+	var lastPlayed = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	player := Player{Rating: 1800, Deviation: 50, Volatility: 0.06, LastPlayed: lastPlayed}
+
+	if got := g.Decay(player, lastPlayed); got.Deviation != 50 {
+		t.Errorf("deviation without inactivity = %v, want 50", got.Deviation)
+	}
+
+	month := g.Decay(player, lastPlayed.Add(30*24*time.Hour))
+	year := g.Decay(player, lastPlayed.Add(365*24*time.Hour))
+	if !(50 < month.Deviation && month.Deviation < year.Deviation) {
+		t.Errorf("deviation after a month = %v, after a year = %v, want it to grow from 50", month.Deviation, year.Deviation)
+	}
+	if month.Rating != player.Rating {
+		t.Errorf("decay changed the rating to %v", month.Rating)
+	}
+
+	forever := g.Decay(player, lastPlayed.Add(100*365*24*time.Hour))
+	if forever.Deviation != g.InitialDeviation {
+		t.Errorf("deviation after a century = %v, want it capped at %v", forever.Deviation, g.InitialDeviation)
+	}
+
+	if got := g.Decay(g.NewPlayer(), lastPlayed); got != g.NewPlayer() {
+		t.Errorf("decay changed a player who never played: %+v", got)
+	}
+}
+
+// Idle periods grow phi² by exactly one σ² each, and Update applies that growth once
+func TestGlicko2UpdateDecaysOnce(t *testing.T) {
+	var g = DefaultGlicko2()
+	var lastPlayed = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	player := Player{Rating: 1700, Deviation: 60, Volatility: 0.06, LastPlayed: lastPlayed}
+	opponent := Player{Rating: 1650, Deviation: 80, Volatility: 0.06, LastPlayed: lastPlayed}
+
+	for _, periods := range []int{1, 5, 30} {
+		now := lastPlayed.Add(time.Duration(periods) * g.RatingPeriod)
+
+		phi := player.Deviation / glicko2Scale
+		decayedPhi := g.Decay(player, now).Deviation / glicko2Scale
+		wantGrowth := float64(periods) * player.Volatility * player.Volatility
+		if growth := decayedPhi*decayedPhi - phi*phi; math.Abs(growth-wantGrowth) > 1e-12 {
+			t.Errorf("%v periods: phi² grew by %v, want %v", periods, growth, wantGrowth)
+		}
+
+		// Rating the decayed players by hand is what a single decay gives
+		want := g.rate(g.Decay(player, now), []glicko2Game{{opponent: g.Decay(opponent, now), points: Win}})
+		got := g.Update(player, opponent, Win, now)
+		if math.Abs(got.Deviation-want.Deviation) > 1e-9 || math.Abs(got.Rating-want.Rating) > 1e-9 {
+			t.Errorf("%v periods: Update gave %+v, want %+v", periods, got, want)
+		}
+		if !got.LastPlayed.Equal(now) {
+			t.Errorf("%v periods: last played %v, want %v", periods, got.LastPlayed, now)
+		}
+	}
+}
+
+func TestGlicko2Provisional(t *testing.T) {
+	var g = DefaultGlicko2()
+	var now = time.Now()
+
+	player := g.NewPlayer()
+	if !g.IsProvisional(player) {
+		t.Fatalf("new player is not provisional")
+	}
+
+	opponent := Player{Rating: 1500, Deviation: 60, Volatility: 0.06, LastPlayed: now}
+	games := 0
+	for g.IsProvisional(player) && games < 100 {
+		player = g.Update(player, opponent, Draw, now)
+		games++
+	}
+	if g.IsProvisional(player) {
+		t.Fatalf("still provisional after %v games, deviation %v", games, player.Deviation)
+	}
+	if player.Deviation < g.MinDeviation {
+		t.Errorf("deviation %v below the minimum %v", player.Deviation, g.MinDeviation)
+	}
+}
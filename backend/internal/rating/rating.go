@@ -0,0 +1,63 @@
+package rating
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const DefaultRating = 1500
+
+// A player's rating in one time class
+type Player struct {
+	Rating     float64   `json:"rating"`
+	Deviation  float64   `json:"deviation"`
+	Volatility float64   `json:"volatility"`
+	LastPlayed time.Time `json:"lastPlayed"` // Zero until the first rated game
+}
+
+func (p Player) RoundedRating() int64 {
+	return int64(math.Round(p.Rating))
+}
+
+// A rating system turns game results into new ratings, the site uses one system for every time class
+type System interface {
+	// Rating given to a player who has not played in a time class yet
+	NewPlayer() Player
+	// Player with their rating uncertainty grown for the time since they last played
+	Decay(player Player, now time.Time) Player
+	// Player after scoring points against opponent, both as stored before the game
+	// Update applies Decay itself, passing already decayed players would decay them twice
+	Update(player Player, opponent Player, points float64, now time.Time) Player
+	// Provisional ratings are still settling and should be shown as such
+	IsProvisional(player Player) bool
+}
+
+// Looks a system up by the name used on the command line
+func NewSystem(name string) (System, error) {
+	switch name {
+	case "glicko2":
+		return DefaultGlicko2(), nil
+	case "elo":
+		return Elo{}, nil
+	}
+	return nil, fmt.Errorf("unknown rating system %q", name)
+}
+
+type Preview struct {
+	Win  int64 `json:"win"`
+	Draw int64 `json:"draw"`
+	Loss int64 `json:"loss"`
+}
+
+// Rounded rating change for each result of a game against opponent
+func PreviewChanges(system System, player Player, opponent Player, now time.Time) Preview {
+	var change = func(points float64) int64 {
+		return system.Update(player, opponent, points, now).RoundedRating() - player.RoundedRating()
+	}
+	return Preview{
+		Win:  change(Win),
+		Draw: change(Draw),
+		Loss: change(Loss),
+	}
+}
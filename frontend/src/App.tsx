@@ -18,6 +18,9 @@ import { WatchPage } from './WatchPage.tsx';
 import { AccountPage } from './auth/AccountPage.tsx';
 import { AccountSettingsPage } from './auth/AccountSettings.tsx';
 import { ProtectedRoute } from './auth/ProtectedRoute.tsx';
+import { ChallengeListener } from './Challenges.tsx';
+import { LobbyPage } from './LobbyPage.tsx';
+import { InvitePage } from './InvitePage.tsx';
 
 function App() {
   console.log(import.meta.env.VITE_API_URL)
@@ -29,12 +32,15 @@ function App() {
         <PlayerInfoTile>
           <Router>
             <TopNavBar />
+            <ChallengeListener />
             <Routes>
               <Route path="/" element={<Home />} />
               <Route path="/matchroom/:matchid" element={<MatchRoom />} />
               <Route path="/login" element={<LoginPage />}/>
               <Route path="/register" element={<RegisterPage/>}/>
               <Route path="/watch" element={<WatchPage />}/>
+              <Route path="/lobby" element={<LobbyPage />}/>
+              <Route path="/invite/:token" element={<InvitePage />}/>
               <Route path="/user/:username" element={<AccountPage/>}/>
               <Route path="/account/settings" element={<ProtectedRoute element={<AccountSettingsPage/>}/>}/>
               <Route path="*" element={<PageNotFound />}/>
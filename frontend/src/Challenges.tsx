@@ -0,0 +1,251 @@
+import { useContext, useEffect, useState } from "react"
+import { useNavigate } from "react-router-dom"
+import { AuthContext } from "./auth/AuthContext"
+
+export type ColourPreference = "white" | "black" | "random"
+
+interface ChallengeEventData {
+  challengeID: number
+  challenger: string
+  timeFormatInMilliseconds: number
+  incrementInMilliseconds: number
+  colour: ColourPreference // The challenger's colour
+  rated: boolean
+  expiresAtUnixMs: number
+}
+
+interface ChallengeDeclinedEventData {
+  challengeID: number
+  username: string
+}
+
+export const challengeTimeControls = [[1, 0], [3, 0], [3, 2], [5, 0], [10, 0], [15, 10]]
+
+export function formatTimeControl(timeFormatInMilliseconds: number, incrementInMilliseconds: number) {
+  return `${timeFormatInMilliseconds / 60000} + ${incrementInMilliseconds / 1000}`
+}
+
+async function answerChallenge(challengeID: number, action: "accept" | "decline") {
+  try {
+    const response = await fetch(import.meta.env.VITE_API_CHALLENGES_URL + `/${challengeID}/${action}`, {
+      signal: AbortSignal.timeout(5000),
+      method: "POST",
+      credentials: "include",
+    })
+    return response.status
+  } catch (e) {
+    console.error(e)
+  }
+  return 0
+}
+
+// Listens for challenges while logged in, accepted challenges open the match room
+export function ChallengeListener() {
+  const auth = useContext(AuthContext)
+  const navigate = useNavigate()
+  const [incoming, setIncoming] = useState<ChallengeEventData[]>([])
+  const [notice, setNotice] = useState("")
+
+  useEffect(() => {
+    if (!auth.isLoggedIn) {
+      return
+    }
+
+    const eventSource = new EventSource(import.meta.env.VITE_API_MATCH_LISTEN_URL, {
+      withCredentials: true,
+    })
+
+    eventSource.addEventListener("challenge", (event) => {
+      const challenge: ChallengeEventData = JSON.parse(event.data)
+      setIncoming((previous) => [...previous, challenge])
+    })
+
+    eventSource.addEventListener("challengeDeclined", (event) => {
+      const declined: ChallengeDeclinedEventData = JSON.parse(event.data)
+      setNotice(`${declined.username} declined your challenge`)
+    })
+
+    // Match found, "matchID,timeFormat,increment"
+    eventSource.onmessage = (event) => {
+      const matchID = event.data.split(",")[0]
+      setIncoming([])
+      navigate(`/matchroom/${matchID}`)
+    }
+
+    return () => {
+      eventSource.close()
+    }
+  }, [auth.isLoggedIn, navigate])
+
+  // Drop challenges once they expire
+  useEffect(() => {
+    if (incoming.length === 0) {
+      return
+    }
+    const interval = setInterval(() => {
+      setIncoming((previous) => previous.filter((challenge) => challenge.expiresAtUnixMs > Date.now()))
+    }, 1000)
+    return () => clearInterval(interval)
+  }, [incoming.length])
+
+  const answer = async (challenge: ChallengeEventData, action: "accept" | "decline") => {
+    setIncoming((previous) => previous.filter((c) => c.challengeID !== challenge.challengeID))
+    const status = await answerChallenge(challenge.challengeID, action)
+    if (action !== "accept" || (status >= 200 && status < 300)) {
+      return
+    }
+    if (status === 409) {
+      // The challenge is still open, it can be accepted once the game in progress ends
+      setIncoming((previous) => [...previous, challenge])
+      setNotice(`You or ${challenge.challenger} are still in a game`)
+    } else {
+      setNotice(`Challenge from ${challenge.challenger} is no longer available`)
+    }
+  }
+
+  if (incoming.length === 0 && notice === "") {
+    return null
+  }
+
+  return (
+    <div className="challengeList">
+      {notice !== "" && (
+        <div className="challengeNotice" onClick={() => setNotice("")}>{notice}</div>
+      )}
+      {incoming.map((challenge) => (
+        <div className="challengeCard" key={challenge.challengeID}>
+          <div>
+            <b>{challenge.challenger}</b> challenges you
+          </div>
+          <div>
+            {formatTimeControl(challenge.timeFormatInMilliseconds, challenge.incrementInMilliseconds)}
+            {challenge.rated ? " rated" : " casual"}
+            {challenge.colour !== "random" && `, they play ${challenge.colour}`}
+          </div>
+          <div className="challengeButtons">
+            <button onClick={() => answer(challenge, "accept")}>Accept</button>
+            <button onClick={() => answer(challenge, "decline")}>Decline</button>
+          </div>
+        </div>
+      ))}
+    </div>
+  )
+}
+
+export function ChallengeForm({ username }: { username: string }) {
+  const auth = useContext(AuthContext)
+  const [timeControl, setTimeControl] = useState(2)
+  const [colour, setColour] = useState<ColourPreference>("random")
+  const [rated, setRated] = useState(true)
+  const [status, setStatus] = useState("")
+
+  if (!auth.isLoggedIn || auth.authData.username === username) {
+    return null
+  }
+
+  const sendChallenge = async () => {
+    const [minutes, seconds] = challengeTimeControls[timeControl]
+    try {
+      const response = await fetch(import.meta.env.VITE_API_CHALLENGES_URL, {
+        signal: AbortSignal.timeout(5000),
+        method: "POST",
+        credentials: "include",
+        body: JSON.stringify({
+          "username": username,
+          "timeFormatInMilliseconds": minutes * 60 * 1000,
+          "incrementInMilliseconds": seconds * 1000,
+          "colour": colour,
+          "rated": rated,
+        })
+      })
+
+      if (response.ok) {
+        setStatus("Challenge sent")
+      } else if (response.status === 409) {
+        setStatus(`${username} is not available`)
+      } else if (response.status === 403) {
+        setStatus(`You cannot challenge ${username}`)
+      } else {
+        setStatus("Could not send challenge")
+      }
+    } catch (e) {
+      console.error(e)
+      setStatus("Could not send challenge")
+    }
+  }
+
+  return (
+    <div className="challengeForm">
+      <select value={timeControl} onChange={(e) => setTimeControl(Number(e.target.value))}>
+        {challengeTimeControls.map(([minutes, seconds], i) => (
+          <option key={i} value={i}>{minutes} + {seconds}</option>
+        ))}
+      </select>
+      <select value={colour} onChange={(e) => setColour(e.target.value as ColourPreference)}>
+        <option value="random">Random</option>
+        <option value="white">White</option>
+        <option value="black">Black</option>
+      </select>
+      <label>
+        <input type="checkbox" checked={rated} onChange={(e) => setRated(e.target.checked)}/>
+        Rated
+      </label>
+      <button onClick={sendChallenge}>Challenge</button>
+      <BlockButton username={username}/>
+      {status !== "" && <span>{status}</span>}
+    </div>
+  )
+}
+
+// Returns whether username is blocked after the request, undefined if it failed
+async function requestBlock(username: string, method: "GET" | "POST" | "DELETE", signal: AbortSignal) {
+  try {
+    const response = await fetch(import.meta.env.VITE_API_USERS_URL + `${encodeURIComponent(username)}/block`, {
+      signal: signal,
+      method: method,
+      credentials: "include",
+    })
+    if (response.ok) {
+      const data: { blocked: boolean } = await response.json()
+      return data.blocked
+    }
+  } catch (e) {
+    console.error(e)
+  }
+  return undefined
+}
+
+// Blocked players are never paired or challenged, either way round
+function BlockButton({ username }: { username: string }) {
+  const [blocked, setBlocked] = useState<boolean | undefined>(undefined)
+
+  useEffect(() => {
+    let ignore = false
+    const controller = new AbortController();
+
+    (async () => {
+      const current = await requestBlock(username, "GET", controller.signal)
+      if (!ignore) {
+        setBlocked(current)
+      }
+    })()
+
+    return () => {
+      ignore = true
+      controller.abort("username changed")
+    }
+  }, [username])
+
+  if (blocked === undefined) {
+    return null
+  }
+
+  const toggle = async () => {
+    const updated = await requestBlock(username, blocked ? "DELETE" : "POST", AbortSignal.timeout(5000))
+    if (updated !== undefined) {
+      setBlocked(updated)
+    }
+  }
+
+  return <button onClick={toggle}>{blocked ? "Unblock" : "Block"}</button>
+}
@@ -0,0 +1,108 @@
+import { useEffect, useState } from "react"
+import { LoaderCircle } from "lucide-react"
+import { useNavigate, useParams } from "react-router-dom"
+import { ColourPreference, formatTimeControl } from "./Challenges"
+
+interface InviteData {
+  creator: string // Empty for logged out players
+  timeFormatInMilliseconds: number
+  incrementInMilliseconds: number
+  colour: ColourPreference // The creator's colour
+  rated: boolean
+  expiresAtUnixMs: number
+}
+
+async function fetchInvite(token: string, signal: AbortSignal) {
+  try {
+    const response = await fetch(import.meta.env.VITE_API_INVITES_URL + `/${token}`, {
+      signal: signal,
+      method: "GET",
+      credentials: "include",
+    })
+    if (response.ok) {
+      const invite: InviteData = await response.json()
+      return invite
+    }
+  } catch (e) {
+    console.error(e)
+  }
+  return undefined
+}
+
+const yourColour = {
+  white: "black",
+  black: "white",
+  random: "a random colour",
+}
+
+export function InvitePage() {
+  const { token } = useParams()
+  const navigate = useNavigate()
+  const [invite, setInvite] = useState<InviteData | undefined>(undefined)
+  const [loading, setLoading] = useState(true)
+  const [status, setStatus] = useState("")
+
+  useEffect(() => {
+    let ignore = false
+    const controller = new AbortController();
+
+    (async () => {
+      const invite = await fetchInvite(token || "", controller.signal)
+      if (!ignore) {
+        setInvite(invite)
+        setLoading(false)
+      }
+    })()
+
+    return () => {
+      ignore = true
+      controller.abort("token changed")
+    }
+  }, [token])
+
+  const join = async () => {
+    try {
+      const response = await fetch(import.meta.env.VITE_API_INVITES_URL + `/${token}/accept`, {
+        signal: AbortSignal.timeout(5000),
+        method: "POST",
+        credentials: "include",
+      })
+
+      if (response.ok) {
+        const data: { matchID: number } = await response.json()
+        navigate(`/matchroom/${data.matchID}`)
+      } else if (response.status === 401) {
+        setStatus("Sign in to play rated games")
+      } else if (response.status === 409) {
+        setStatus("The game cannot start right now, one of you is already in a game")
+      } else {
+        setInvite(undefined)
+      }
+    } catch (e) {
+      console.error(e)
+    }
+  }
+
+  if (loading) {
+    return <LoaderCircle className="loaderSpin"/>
+  }
+
+  if (invite === undefined) {
+    return (
+      <div className="invitePage">This invite has been used or has expired.</div>
+    )
+  }
+
+  return (
+    <div className="invitePage">
+      <div>
+        <b>{invite.creator || "Anonymous"}</b> invites you to a {invite.rated ? "rated" : "casual"} game
+      </div>
+      <div>
+        {formatTimeControl(invite.timeFormatInMilliseconds, invite.incrementInMilliseconds)}, you play {yourColour[invite.colour]}
+      </div>
+      <button onClick={join}>Join game</button>
+      {status !== "" && <div>{status}</div>}
+    </div>
+  )
+}
@@ -0,0 +1,298 @@
+import { useContext, useEffect, useState } from "react"
+import { LoaderCircle } from "lucide-react"
+import { useNavigate } from "react-router-dom"
+import { AuthContext } from "./auth/AuthContext"
+import { challengeTimeControls, ColourPreference, formatTimeControl } from "./Challenges"
+
+interface Seek {
+  seekID: number
+  username: string // Empty for logged out players
+  rating: number
+  provisional: boolean
+  timeFormatInMilliseconds: number
+  incrementInMilliseconds: number
+  minRating: number // 0 for no limit
+  maxRating: number // 0 for no limit
+  colour: ColourPreference // The seeker's colour
+  rated: boolean
+  unixMsTimeCreated: number
+}
+
+async function fetchSeeks() {
+  try {
+    const response = await fetch(import.meta.env.VITE_API_LOBBY_URL, {
+      signal: AbortSignal.timeout(5000),
+      method: "GET",
+    })
+    if (response.ok) {
+      const seeks: Seek[] = await response.json()
+      return seeks
+    }
+  } catch (e) {
+    console.error(e)
+  }
+  return undefined
+}
+
+function formatRatingRange(seek: Seek) {
+  if (seek.minRating === 0 && seek.maxRating === 0) {
+    return "Any"
+  }
+  return `${seek.minRating || ""} - ${seek.maxRating || ""}`
+}
+
+function inviteLink(token: string) {
+  return `${window.location.origin}/invite/${token}`
+}
+
+function SeekForm({ openSeekID, setOpenSeekID }: { openSeekID: number | undefined, setOpenSeekID: (seekID: number | undefined) => void }) {
+  const auth = useContext(AuthContext)
+  const [timeControl, setTimeControl] = useState(2)
+  const [colour, setColour] = useState<ColourPreference>("random")
+  const [rated, setRated] = useState(true)
+  const [minRating, setMinRating] = useState("")
+  const [maxRating, setMaxRating] = useState("")
+  const [status, setStatus] = useState("")
+  const [inviteToken, setInviteToken] = useState<string | undefined>(undefined)
+
+  const gameSettings = () => {
+    const [minutes, seconds] = challengeTimeControls[timeControl]
+    return {
+      "timeFormatInMilliseconds": minutes * 60 * 1000,
+      "incrementInMilliseconds": seconds * 1000,
+      "colour": colour,
+      "rated": rated && auth.isLoggedIn,
+    }
+  }
+
+  const createSeek = async () => {
+    try {
+      const response = await fetch(import.meta.env.VITE_API_LOBBY_URL, {
+        signal: AbortSignal.timeout(5000),
+        method: "POST",
+        credentials: "include",
+        body: JSON.stringify({
+          ...gameSettings(),
+          "minRating": Number(minRating) || 0,
+          "maxRating": Number(maxRating) || 0,
+        })
+      })
+
+      if (response.ok) {
+        const data: { seekID: number } = await response.json()
+        setOpenSeekID(data.seekID)
+        setStatus("")
+      } else {
+        setStatus("Could not create seek")
+      }
+    } catch (e) {
+      console.error(e)
+      setStatus("Could not create seek")
+    }
+  }
+
+  const cancelSeek = async () => {
+    if (openSeekID === undefined) {
+      return
+    }
+    try {
+      await fetch(import.meta.env.VITE_API_LOBBY_URL + `/${openSeekID}`, {
+        signal: AbortSignal.timeout(5000),
+        method: "DELETE",
+        credentials: "include",
+      })
+    } catch (e) {
+      console.error(e)
+    }
+    setOpenSeekID(undefined)
+  }
+
+  const createInvite = async () => {
+    try {
+      const response = await fetch(import.meta.env.VITE_API_INVITES_URL, {
+        signal: AbortSignal.timeout(5000),
+        method: "POST",
+        credentials: "include",
+        body: JSON.stringify(gameSettings())
+      })
+
+      if (response.ok) {
+        const data: { token: string } = await response.json()
+        setInviteToken(data.token)
+        setStatus("")
+      } else {
+        setStatus("Could not create invite")
+      }
+    } catch (e) {
+      console.error(e)
+      setStatus("Could not create invite")
+    }
+  }
+
+  const cancelInvite = async () => {
+    if (inviteToken === undefined) {
+      return
+    }
+    try {
+      await fetch(import.meta.env.VITE_API_INVITES_URL + `/${inviteToken}`, {
+        signal: AbortSignal.timeout(5000),
+        method: "DELETE",
+        credentials: "include",
+      })
+    } catch (e) {
+      console.error(e)
+    }
+    setInviteToken(undefined)
+  }
+
+  // The game starts when someone opens the link, the lobby listens for it
+  if (inviteToken !== undefined) {
+    return (
+      <div className="seekForm">
+        <LoaderCircle className="loaderSpin"/>
+        <input className="inviteLink" readOnly value={inviteLink(inviteToken)} onFocus={(e) => e.target.select()}/>
+        <button onClick={() => navigator.clipboard.writeText(inviteLink(inviteToken))}>Copy</button>
+        <button onClick={cancelInvite}>Cancel</button>
+      </div>
+    )
+  }
+
+  if (openSeekID !== undefined) {
+    return (
+      <div className="seekForm">
+        <LoaderCircle className="loaderSpin"/>
+        <span>Waiting for an opponent</span>
+        <button onClick={cancelSeek}>Cancel</button>
+      </div>
+    )
+  }
+
+  return (
+    <div className="seekForm">
+      <select value={timeControl} onChange={(e) => setTimeControl(Number(e.target.value))}>
+        {challengeTimeControls.map(([minutes, seconds], i) => (
+          <option key={i} value={i}>{minutes} + {seconds}</option>
+        ))}
+      </select>
+      <select value={colour} onChange={(e) => setColour(e.target.value as ColourPreference)}>
+        <option value="random">Random</option>
+        <option value="white">White</option>
+        <option value="black">Black</option>
+      </select>
+      <input type="number" placeholder="Min rating" value={minRating} onChange={(e) => setMinRating(e.target.value)}/>
+      <input type="number" placeholder="Max rating" value={maxRating} onChange={(e) => setMaxRating(e.target.value)}/>
+      <label>
+        <input type="checkbox" checked={rated && auth.isLoggedIn} disabled={!auth.isLoggedIn} onChange={(e) => setRated(e.target.checked)}/>
+        Rated
+      </label>
+      <button onClick={createSeek}>Create game</button>
+      <button onClick={createInvite}>Invite a friend</button>
+      {status !== "" && <span>{status}</span>}
+    </div>
+  )
+}
+
+export function LobbyPage() {
+  const navigate = useNavigate()
+  const [seeks, setSeeks] = useState<Seek[] | undefined>(undefined)
+  const [openSeekID, setOpenSeekID] = useState<number | undefined>(undefined)
+  const [status, setStatus] = useState("")
+
+  // Current seeks, then the feed keeps them up to date
+  useEffect(() => {
+    let ignore = false
+    const feed = new EventSource(import.meta.env.VITE_API_LOBBY_FEED_URL)
+
+    feed.addEventListener("seekAdded", (event) => {
+      const seek: Seek = JSON.parse(event.data)
+      setSeeks((previous) => [...(previous || []).filter((s) => s.seekID !== seek.seekID), seek])
+    })
+
+    feed.addEventListener("seekRemoved", (event) => {
+      const { seekID }: { seekID: number } = JSON.parse(event.data)
+      setSeeks((previous) => (previous || []).filter((s) => s.seekID !== seekID))
+      setOpenSeekID((previous) => previous === seekID ? undefined : previous)
+    })
+
+    feed.onopen = async () => {
+      const current = await fetchSeeks()
+      if (!ignore) {
+        setSeeks(current)
+      }
+    }
+
+    return () => {
+      ignore = true
+      feed.close()
+    }
+  }, [])
+
+  // Seeks are only open while listening, accepted seeks arrive as match found
+  useEffect(() => {
+    const eventSource = new EventSource(import.meta.env.VITE_API_MATCH_LISTEN_URL, {
+      withCredentials: true,
+    })
+
+    eventSource.onmessage = (event) => {
+      const matchID = event.data.split(",")[0]
+      navigate(`/matchroom/${matchID}`)
+    }
+
+    return () => {
+      eventSource.close()
+    }
+  }, [navigate])
+
+  const acceptSeek = async (seek: Seek) => {
+    try {
+      const response = await fetch(import.meta.env.VITE_API_LOBBY_URL + `/${seek.seekID}/accept`, {
+        signal: AbortSignal.timeout(5000),
+        method: "POST",
+        credentials: "include",
+      })
+
+      if (response.status === 401) {
+        setStatus("Sign in to play rated games")
+      } else if (response.status === 403) {
+        setStatus("Your rating is outside of this seek's range")
+      } else if (!response.ok) {
+        setStatus("Game is no longer available")
+      }
+    } catch (e) {
+      console.error(e)
+    }
+  }
+
+  return (
+    <div className="lobbyPage">
+      <SeekForm openSeekID={openSeekID} setOpenSeekID={setOpenSeekID}/>
+      {status !== "" && <div onClick={() => setStatus("")}>{status}</div>}
+      {seeks === undefined ? <LoaderCircle className="loaderSpin"/> :
+        <table className="lobbyTable">
+          <thead>
+            <tr>
+              <th>Player</th>
+              <th>Rating</th>
+              <th>Time</th>
+              <th>Mode</th>
+              <th>Colour</th>
+              <th>Range</th>
+            </tr>
+          </thead>
+          <tbody>
+            {seeks.map((seek) => (
+              <tr key={seek.seekID} className={seek.seekID === openSeekID ? "ownSeek" : ""} onClick={() => seek.seekID !== openSeekID && acceptSeek(seek)}>
+                <td>{seek.username || "Anonymous"}</td>
+                <td>{seek.rating}{seek.provisional ? "?" : ""}</td>
+                <td>{formatTimeControl(seek.timeFormatInMilliseconds, seek.incrementInMilliseconds)}</td>
+                <td>{seek.rated ? "Rated" : "Casual"}</td>
+                <td>{seek.colour}</td>
+                <td>{formatRatingRange(seek)}</td>
+              </tr>
+            ))}
+          </tbody>
+        </table>
+      }
+    </div>
+  )
+}
@@ -12,11 +12,27 @@ interface PlayerInfoTilePosition {
   y: number,
 }
 
+export interface ProvisionalRatingsObject {
+  bullet: boolean
+  blitz: boolean
+  rapid: boolean
+  classical: boolean
+}
+
 export interface RatingsObject {
   bullet: number
   blitz: number
   rapid: number
   classical: number
+  provisional: ProvisionalRatingsObject
+}
+
+// Provisional ratings are still settling, shown with a question mark
+export function formatRating(ratings: RatingsObject | undefined, timeFormat: keyof ProvisionalRatingsObject): string {
+  if (ratings === undefined) {
+    return ""
+  }
+  return `${ratings[timeFormat]}${ratings.provisional?.[timeFormat] ? "?" : ""}`
 }
 
 export interface PlayerInfoTileData {
@@ -75,6 +91,82 @@ export const PlayerInfoTileContext = createContext<PlayerInfoTileContextInterfac
 //   return playerTileInfoArray[playerTileInfoIndex]
 // }
 
+export type RatingTimeFormat = keyof ProvisionalRatingsObject
+
+interface RatingHistoryPoint {
+  matchID: number
+  unixMsTime: number
+  rating: number
+}
+
+interface RatingHistory {
+  username: string
+  timeFormat: RatingTimeFormat
+  history: RatingHistoryPoint[]
+  peak: RatingHistoryPoint | null
+  lowest: RatingHistoryPoint | null
+}
+
+async function fetchRatingHistory(username: string, timeFormat: RatingTimeFormat): Promise<RatingHistory | undefined> {
+  const url = import.meta.env.VITE_API_USERS_URL + `${encodeURIComponent(username)}/ratingHistory?timeFormat=${timeFormat}`
+
+  try {
+    const response = await fetch(url, {
+      method: "GET",
+    })
+
+    if (response.ok) {
+      return await response.json()
+    }
+
+  } catch (e) {
+    console.error(e)
+  }
+}
+
+const RATING_GRAPH_WIDTH = 280
+const RATING_GRAPH_HEIGHT = 50
+
+export function RatingGraph({ username, timeFormat }: { username: string, timeFormat: RatingTimeFormat }) {
+  const [ratingHistory, setRatingHistory] = useState<RatingHistory | undefined>(undefined)
+
+  useEffect(() => {
+    let cancelled = false
+    fetchRatingHistory(username, timeFormat).then((history) => {
+      if (!cancelled) {
+        setRatingHistory(history)
+      }
+    })
+    return () => {
+      cancelled = true
+    }
+  }, [username, timeFormat])
+
+  if (ratingHistory === undefined || ratingHistory.peak === null || ratingHistory.lowest === null) {
+    return <div className="ratingGraph">No rated {timeFormat} games</div>
+  }
+
+  const history = ratingHistory.history
+  const range = Math.max(ratingHistory.peak.rating - ratingHistory.lowest.rating, 1)
+  const points = history.map((point, idx) => {
+    const x = history.length == 1 ? RATING_GRAPH_WIDTH / 2 : idx / (history.length - 1) * RATING_GRAPH_WIDTH
+    const y = RATING_GRAPH_HEIGHT - (point.rating - ratingHistory.lowest!.rating) / range * RATING_GRAPH_HEIGHT
+    return `${x},${y}`
+  }).join(" ")
+
+  return (
+    <div className="ratingGraph">
+      <svg width={RATING_GRAPH_WIDTH} height={RATING_GRAPH_HEIGHT} viewBox={`-2 -2 ${RATING_GRAPH_WIDTH + 4} ${RATING_GRAPH_HEIGHT + 4}`}>
+        <polyline points={points} fill="none" stroke="black" strokeWidth="2" />
+      </svg>
+      <div>
+        <span style={{float: "left"}}>{`Peak ${ratingHistory.peak.rating}`}</span>
+        <span style={{float: "right"}}>{`Lowest ${ratingHistory.lowest.rating}`}</span>
+      </div>
+    </div>
+  )
+}
+
 async function fetchPlayerTileData(username: string) {
   console.log(`Search string: ${username}`)
   const url = import.meta.env.VITE_API_GET_TILE_INFO_URL + `?search=${username}`
@@ -287,6 +379,7 @@ export function PlayerInfoTile({ children }: { children: React.ReactNode }) {
   const [username, setUsername] = useState<string | null>(null)
   const [playerData, setPlayerData] = useState<PlayerInfoTileData | null>(null)
   const [position, setPosition] = useState<PlayerInfoTilePosition>({x: 0, y: 0})
+  const [graphTimeFormat, setGraphTimeFormat] = useState<RatingTimeFormat>("blitz")
 
   const queuedUsername = useRef<string | null>(null)
   const queuedPlayerData = useRef<PlayerInfoTileData | null>(null)
@@ -394,27 +487,29 @@ export function PlayerInfoTile({ children }: { children: React.ReactNode }) {
           </div>
 
           <div className="playerInfoTileRatings">
-            <div>
+            <div className={graphTimeFormat == "bullet" ? "active" : ""} onClick={() => setGraphTimeFormat("bullet")}>
               <TrainFront />
-              {playerData?.ratings.bullet}
+              {formatRating(playerData?.ratings, "bullet")}
             </div>
 
-            <div>
+            <div className={graphTimeFormat == "blitz" ? "active" : ""} onClick={() => setGraphTimeFormat("blitz")}>
               <Flame />
-              {playerData?.ratings.blitz}
+              {formatRating(playerData?.ratings, "blitz")}
             </div>
 
-            <div>
+            <div className={graphTimeFormat == "rapid" ? "active" : ""} onClick={() => setGraphTimeFormat("rapid")}>
               <Rabbit />
-              {playerData?.ratings.rapid}
+              {formatRating(playerData?.ratings, "rapid")}
             </div>
 
-            <div>
+            <div className={graphTimeFormat == "classical" ? "active" : ""} onClick={() => setGraphTimeFormat("classical")}>
               <Turtle />
-              {playerData?.ratings.classical}
+              {formatRating(playerData?.ratings, "classical")}
             </div>
           </div>
 
+          {username != null ? <RatingGraph username={username} timeFormat={graphTimeFormat} /> : <></>}
+
           <div className="Games&JoinDate">
             <div style={{float:"left"}}>
               {`${playerData?.numberOfGames} games`}
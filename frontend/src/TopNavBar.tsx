@@ -17,7 +17,7 @@ export function TopNavBar() {
         <Link to='/' className="siteName">BurrChess</Link>
 
         <Dropdown title='Play' titleTo='/play'>
-          <DropdownItem to="#">Test</DropdownItem>
+          <DropdownItem to="/lobby">Lobby</DropdownItem>
         </Dropdown>
 
         <Dropdown title='Watch' titleTo='/watch'>
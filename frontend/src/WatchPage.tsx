@@ -29,6 +29,9 @@ const resultReasons = [
   "Abort",
   "WhiteDisconnected",
   "BlackDisconnected",
+  "FiftyMoveRule",
+  "SeventyFiveMoveRule",
+  "FivefoldRepetition",
 ]
 
 export interface matchData {
@@ -1,9 +1,10 @@
 import React, { useEffect, useRef, useState } from "react"
-import { formatTimePassed, PlayerInfoTileData } from "../PlayerInfoTile"
+import { formatRating, formatTimePassed, PlayerInfoTileData } from "../PlayerInfoTile"
 import { Flame, LoaderCircle, Rabbit, TrainFront, Turtle } from "lucide-react"
 import { useParams } from "react-router-dom"
 import { matchData, MatchTile } from "../WatchPage"
 import { PingStatus } from "../chess/GameInfoTile"
+import { ChallengeForm } from "../Challenges"
 
 enum Page {
   All = "all",
@@ -240,7 +241,7 @@ export function AccountPage() {
                 <TrainFront style={{marginLeft: "auto"}}/>
               </div>
               <div style={{textAlign: "center"}}>
-                {playerData?.ratings.bullet || "-"}
+                {formatRating(playerData?.ratings, "bullet") || "-"}
               </div>
             </div>
           </li>
@@ -251,7 +252,7 @@ export function AccountPage() {
                 <Flame style={{marginLeft: "auto"}}/>
               </div>
               <div style={{textAlign: "center"}}>
-                {playerData?.ratings.blitz || "-"}
+                {formatRating(playerData?.ratings, "blitz") || "-"}
               </div>
             </div>
           </li>
@@ -262,7 +263,7 @@ export function AccountPage() {
                 <Rabbit style={{marginLeft: "auto"}}/>
               </div>
               <div style={{textAlign: "center"}}>
-                {playerData?.ratings.rapid || "-"}
+                {formatRating(playerData?.ratings, "rapid") || "-"}
               </div>
             </div>
           </li>
@@ -273,7 +274,7 @@ export function AccountPage() {
                 <Turtle style={{marginLeft: "auto"}}/>
               </div>
               <div style={{textAlign: "center"}}>
-                {playerData?.ratings.classical || "-"}
+                {formatRating(playerData?.ratings, "classical") || "-"}
               </div>
             </div>
           </li>
@@ -288,6 +289,7 @@ export function AccountPage() {
         marginRight: "auto",
       }}>
         {loadingPlayerData ? <LoaderCircle className="loaderSpin"/> : <AccountInfoDisplay accountInfo={playerData}/>}
+        {playerData !== undefined && <ChallengeForm username={playerData.username}/>}
         {loadingContent ? <LoaderCircle className="loaderSpin"/> : <AccountContent pageData={pageData}/>}
       </div>
     </>
@@ -1,5 +1,6 @@
 import React, { useRef } from 'react';
 import { ReactNode, useState, useEffect, createContext } from "react"
+import { NavigateFunction, useNavigate } from "react-router-dom"
 import { parseGameStateFromFEN, PieceColour, PieceVariant } from "./ChessLogic"
 
 export interface boardInfo {
@@ -46,8 +47,12 @@ export interface gameContext {
   millisecondsUntilOpponentTimeout: number | null,
   threefoldRepetition: boolean,
   setThreefoldRepetition: React.Dispatch<React.SetStateAction<boolean>>,
+  fiftyMoveRule: boolean,
+  setFiftyMoveRule: React.Dispatch<React.SetStateAction<boolean>>,
   flip: boolean,
   setFlip: React.Dispatch<React.SetStateAction<boolean>>,
+  chatMessages: UserMessage[],
+  ratingChangePreview: RatingChangePreview | null,
 }
 
 export const GameContext = createContext<gameContext | null>(null)
@@ -64,16 +69,25 @@ interface OnConnectMessage {
   matchStateHistory: MatchStateHistory[]
   gameOverStatus: number
   threefoldRepetition: boolean
+  fiftyMoveRule: boolean
   whitePlayerConnected: boolean
   blackPlayerConnected: boolean
   whitePlayerUsername: SQLNullString
   blackPlayerUsername: SQLNullString
+  chatHistory: UserMessage[]
+  ratingChangePreview: RatingChangePreview | null
+  whitePlayerTimeRemainingMilliseconds: number
+  blackPlayerTimeRemainingMilliseconds: number
 }
 
 interface OnMoveMessage {
   matchStateHistory: MatchStateHistory[]
   gameOverStatus: number
   threefoldRepetition: boolean
+  fiftyMoveRule: boolean
+  // Live clocks, extra time can put them ahead of the latest history entry
+  whitePlayerTimeRemainingMilliseconds: number
+  blackPlayerTimeRemainingMilliseconds: number
 }
 
 interface ConnectionStatusMessage {
@@ -86,9 +100,26 @@ interface PlayerCodeMessage {
   playerCode: number
 }
 
+export interface RatingChangePreview {
+  win: number
+  draw: number
+  loss: number
+}
+
+export interface UserMessage {
+  sender: string
+  messageContent: string
+}
+
+interface RematchCreatedMessage {
+  matchID: number
+  timeFormatInMilliseconds: number
+  incrementInMilliseconds: number
+}
+
 interface ChessWebSocketMessage {
   messageType: string
-  body: OnConnectMessage | OnMoveMessage | ConnectionStatusMessage | PlayerCodeMessage | OpponentEventMessage
+  body: OnConnectMessage | OnMoveMessage | ConnectionStatusMessage | PlayerCodeMessage | OpponentEventMessage | RematchCreatedMessage | UserMessage
 }
 
 interface OpponentEventMessage {
@@ -101,10 +132,13 @@ export enum OpponentEventType {
   Takeback = "takeback",
   Draw = "draw",
   Rematch = "rematch",
+  ExtraTime = "extraTime",
+  Abort = "abort",
   Disconnect = "disconnect",
   Decline = "decline",
   Resign = "resign",
-  ThreefoldRepetition = "threefoldRepetition"
+  ThreefoldRepetition = "threefoldRepetition",
+  FiftyMoveRule = "fiftyMoveRule"
 }
 
 function sendPlayerCodeHandler(
@@ -126,14 +160,19 @@ function onConnectHandler(
   matchData: matchData,
   setMatchData: React.Dispatch<React.SetStateAction<matchData>>,
   setThreefoldRepetition: React.Dispatch<React.SetStateAction<boolean>>,
+  setFiftyMoveRule: React.Dispatch<React.SetStateAction<boolean>>,
   setWhitePlayerUsername: React.Dispatch<React.SetStateAction<SQLNullString>>,
   setBlackPlayerUsername: React.Dispatch<React.SetStateAction<SQLNullString>>,
+  setChatMessages: React.Dispatch<React.SetStateAction<UserMessage[]>>,
+  setRatingChangePreview: React.Dispatch<React.SetStateAction<RatingChangePreview | null>>,
 ) {
-  onMoveHandler(body as OnMoveMessage, setOpponentEventType, matchData, setMatchData, setThreefoldRepetition)
+  onMoveHandler(body as OnMoveMessage, setOpponentEventType, matchData, setMatchData, setThreefoldRepetition, setFiftyMoveRule)
   setIsWhiteConnected(body["whitePlayerConnected"])
   setIsBlackConnected(body["blackPlayerConnected"])
   setWhitePlayerUsername(body["whitePlayerUsername"])
   setBlackPlayerUsername(body["blackPlayerUsername"])
+  setChatMessages(body["chatHistory"])
+  setRatingChangePreview(body["ratingChangePreview"])
 }
 
 function connectionStatusHandler(
@@ -161,9 +200,11 @@ function onMoveHandler(
   setOpponentEventType: React.Dispatch<React.SetStateAction<OpponentEventType>>,
   matchData: matchData,
   setMatchData: React.Dispatch<React.SetStateAction<matchData>>,
-  setThreefoldRepetition: React.Dispatch<React.SetStateAction<boolean>>
+  setThreefoldRepetition: React.Dispatch<React.SetStateAction<boolean>>,
+  setFiftyMoveRule: React.Dispatch<React.SetStateAction<boolean>>,
 ) {
   setThreefoldRepetition(body["threefoldRepetition"])
+  setFiftyMoveRule(body["fiftyMoveRule"])
   setOpponentEventType(OpponentEventType.None)
   const newHistory = body["matchStateHistory"]
   if (newHistory.length == 0) {
@@ -177,12 +218,13 @@ function onMoveHandler(
 
   let activeState = {
     ...matchData.activeState,
-    whitePlayerTimeRemainingMilliseconds:  latestHistoryEntry["whitePlayerTimeRemainingMilliseconds"],
-    blackPlayerTimeRemainingMilliseconds:  latestHistoryEntry["blackPlayerTimeRemainingMilliseconds"],
+    whitePlayerTimeRemainingMilliseconds:  body["whitePlayerTimeRemainingMilliseconds"],
+    blackPlayerTimeRemainingMilliseconds:  body["blackPlayerTimeRemainingMilliseconds"],
   }
   let activeMove = matchData.activeMove
 
-  if (matchData.activeState.FEN == matchData.stateHistory.at(-1)?.FEN) {
+  // Takebacks can shorten the history past the move being viewed
+  if (matchData.activeState.FEN == matchData.stateHistory.at(-1)?.FEN || activeMove >= newHistory.length) {
     activeState = {
       ...activeState,
       board: parseGameStateFromFEN(latestFEN).board,
@@ -221,6 +263,16 @@ function opponentEventHandler(body: OpponentEventMessage, setOpponentEventType:
   }
 }
 
+function rematchCreatedHandler(body: RematchCreatedMessage, navigate: NavigateFunction) {
+  navigate("/matchroom/" + body["matchID"], {
+    state: {
+      matchRoom: body["matchID"],
+      timeFormatInMilliseconds: body["timeFormatInMilliseconds"],
+      incrementInMilliseconds: body["incrementInMilliseconds"],
+    }
+  })
+}
+
 function readMessage(
   message: unknown,
   setPlayerColour: React.Dispatch<React.SetStateAction<PieceColour>>,
@@ -231,8 +283,12 @@ function readMessage(
   matchData: matchData,
   setMatchData: React.Dispatch<React.SetStateAction<matchData>>,
   setThreefoldRepetition: React.Dispatch<React.SetStateAction<boolean>>,
+  setFiftyMoveRule: React.Dispatch<React.SetStateAction<boolean>>,
   setWhitePlayerUsername: React.Dispatch<React.SetStateAction<SQLNullString>>,
   setBlackPlayerUsername: React.Dispatch<React.SetStateAction<SQLNullString>>,
+  navigate: NavigateFunction,
+  setChatMessages: React.Dispatch<React.SetStateAction<UserMessage[]>>,
+  setRatingChangePreview: React.Dispatch<React.SetStateAction<RatingChangePreview | null>>,
 ) {
   console.log("FROM WEBSOCKET")
   console.log(message)
@@ -251,17 +307,23 @@ function readMessage(
       sendPlayerCodeHandler(parsedMsg["body"] as PlayerCodeMessage, setPlayerColour)
       break;
     case "onConnect":
-      onConnectHandler(parsedMsg["body"] as OnConnectMessage, setIsWhiteConnected, setIsBlackConnected, setOpponentEventType, matchData, setMatchData, setThreefoldRepetition, setWhitePlayerUsername, setBlackPlayerUsername)
+      onConnectHandler(parsedMsg["body"] as OnConnectMessage, setIsWhiteConnected, setIsBlackConnected, setOpponentEventType, matchData, setMatchData, setThreefoldRepetition, setFiftyMoveRule, setWhitePlayerUsername, setBlackPlayerUsername, setChatMessages, setRatingChangePreview)
       break;
     case "connectionStatus":
       connectionStatusHandler(parsedMsg["body"] as ConnectionStatusMessage, setIsWhiteConnected, setIsBlackConnected, setMillisecondsUntilOpponentTimeout)
       break;
     case "onMove":
-      onMoveHandler(parsedMsg["body"] as OnMoveMessage, setOpponentEventType, matchData, setMatchData, setThreefoldRepetition)
+      onMoveHandler(parsedMsg["body"] as OnMoveMessage, setOpponentEventType, matchData, setMatchData, setThreefoldRepetition, setFiftyMoveRule)
       break;
     case "opponentEvent":
       opponentEventHandler(parsedMsg["body"] as OpponentEventMessage, setOpponentEventType)
       break;
+    case "userMessage":
+      setChatMessages((chatMessages) => [...chatMessages, parsedMsg["body"] as UserMessage])
+      break;
+    case "rematchCreated":
+      rematchCreatedHandler(parsedMsg["body"] as RematchCreatedMessage, navigate)
+      break;
     default:
       console.error("Could not understand message from websocket")
       console.log(message)
@@ -300,7 +362,11 @@ export function GameWrapper({ children, matchID, timeFormatInMilliseconds }: { c
   const [millisecondsUntilOpponentTimeout, setMillisecondsUntilOpponentTimeout] = useState<number | null>(null)
   const [opponentEventType, setOpponentEventType] = useState(OpponentEventType.None)
   const [threefoldRepetition, setThreefoldRepetition] = useState(false)
+  const [fiftyMoveRule, setFiftyMoveRule] = useState(false)
   const [flip, setFlip] = useState(false)
+  const navigate = useNavigate()
+  const [chatMessages, setChatMessages] = useState<UserMessage[]>([])
+  const [ratingChangePreview, setRatingChangePreview] = useState<RatingChangePreview | null>(null)
 
   useEffect(() => {
     console.log("GameWrapper mount")
@@ -317,7 +383,7 @@ export function GameWrapper({ children, matchID, timeFormatInMilliseconds }: { c
     const webSocketConnect = () => {
       webSocket.current = new WebSocket(import.meta.env.VITE_API_MATCHROOM_URL + matchID + '/ws')
       webSocket.current.onopen = () => console.log("Websocket connected")
-      webSocket.current.onmessage = (event) => readMessage(event.data, setPlayerColour, setIsWhiteConnected, setIsBlackConnected, setMillisecondsUntilOpponentTimeout, setOpponentEventType, matchData, setMatchData, setThreefoldRepetition, setWhitePlayerUsername, setBlackPlayerUsername)
+      webSocket.current.onmessage = (event) => readMessage(event.data, setPlayerColour, setIsWhiteConnected, setIsBlackConnected, setMillisecondsUntilOpponentTimeout, setOpponentEventType, matchData, setMatchData, setThreefoldRepetition, setFiftyMoveRule, setWhitePlayerUsername, setBlackPlayerUsername, navigate, setChatMessages, setRatingChangePreview)
       webSocket.current.onerror = (event) => console.error(event)
       webSocket.current.onclose = () => {
         // Should be exponential backoff but server not hanling match not found properly
@@ -341,7 +407,7 @@ export function GameWrapper({ children, matchID, timeFormatInMilliseconds }: { c
   }, [matchData])
   
   return (
-    <GameContext.Provider value={{ matchData, setMatchData, webSocket, playerColour, isWhiteConnected, isBlackConnected, opponentEventType, setOpponentEventType, millisecondsUntilOpponentTimeout, threefoldRepetition, setThreefoldRepetition, flip, setFlip, whitePlayerUsername, blackPlayerUsername }}>
+    <GameContext.Provider value={{ matchData, setMatchData, webSocket, playerColour, isWhiteConnected, isBlackConnected, opponentEventType, setOpponentEventType, millisecondsUntilOpponentTimeout, threefoldRepetition, setThreefoldRepetition, fiftyMoveRule, setFiftyMoveRule, flip, setFlip, whitePlayerUsername, blackPlayerUsername, chatMessages, ratingChangePreview }}>
       {children}
     </GameContext.Provider>
   )
@@ -1,5 +1,5 @@
 import React, { useContext, useRef, useEffect, useState } from "react";
-import { CornerUpLeft, Handshake, Flag, Microscope, ChevronFirst, ChevronLeft, ChevronRight, ChevronLast, AlignJustify } from "lucide-react";
+import { CornerUpLeft, Handshake, Flag, Repeat, AlarmClockPlus, X, Microscope, ChevronFirst, ChevronLeft, ChevronRight, ChevronLast, AlignJustify } from "lucide-react";
 import { PieceColour, PieceVariant, parseGameStateFromFEN } from "./ChessLogic";
 import { GameContext, OpponentEventType, gameContext, boardHistory, SQLNullString } from "./GameContext";
 import { variantToString } from "./ChessBoard";
@@ -21,6 +21,20 @@ function isWhiteClockPaused(game: gameContext) {
   return isClockPaused(game, PieceColour.White)
 }
   
+function sendTakebackEvent(websocket: React.RefObject<WebSocket | null>) {
+  if (!websocket) {
+    console.error("Websocket is null")
+    return
+  }
+  
+  websocket.current?.send(JSON.stringify({
+    "messageType": "playerEvent",
+    "body": {
+      "eventType": OpponentEventType.Takeback,
+    }
+  }))
+}
+  
 function sendDrawEvent(websocket: React.RefObject<WebSocket | null>) {
   if (!websocket) {
     console.error("Websocket is null")
@@ -49,6 +63,49 @@ function sendResignEvent(websocket: React.RefObject<WebSocket | null>) {
   }))
 }
 
+function sendAbortEvent(websocket: React.RefObject<WebSocket | null>) {
+  if (!websocket) {
+    console.error("Websocket is null")
+    return
+  }
+  
+  websocket.current?.send(JSON.stringify({
+    "messageType": "playerEvent",
+    "body": {
+      "eventType": OpponentEventType.Abort,
+    }
+  }))
+}
+
+function sendExtraTimeEvent(websocket: React.RefObject<WebSocket | null>) {
+  if (!websocket) {
+    console.error("Websocket is null")
+    return
+  }
+  
+  websocket.current?.send(JSON.stringify({
+    "messageType": "playerEvent",
+    "body": {
+      "eventType": OpponentEventType.ExtraTime,
+      "extraTimeInMilliseconds": 15_000,
+    }
+  }))
+}
+
+function sendRematchEvent(websocket: React.RefObject<WebSocket | null>) {
+  if (!websocket) {
+    console.error("Websocket is null")
+    return
+  }
+  
+  websocket.current?.send(JSON.stringify({
+    "messageType": "playerEvent",
+    "body": {
+      "eventType": OpponentEventType.Rematch,
+    }
+  }))
+}
+
 function acceptEvent(game: gameContext, eventType: OpponentEventType) {
   if (game.webSocket == null) {
     console.error("Websocket is null")
@@ -66,7 +123,7 @@ function acceptEvent(game: gameContext, eventType: OpponentEventType) {
   
 }
   
-function declineEvent(game: gameContext, isThreefold = false) {
+function declineEvent(game: gameContext, isThreefold = false, isFiftyMoveRule = false) {
   if (game.webSocket == null) {
     console.error("Websocket is null")
     return
@@ -76,6 +133,11 @@ function declineEvent(game: gameContext, isThreefold = false) {
     game.setThreefoldRepetition(false)
     return
   }
+
+  if (isFiftyMoveRule) {
+    game.setFiftyMoveRule(false)
+    return
+  }
   
   game.webSocket.current?.send(JSON.stringify({
     "messageType": "playerEvent",
@@ -123,6 +185,28 @@ function PlayerInfo({ connected, username }: { connected: boolean, username: SQL
   )
 }
 
+function formatRatingChange(change: number): string {
+  return change > 0 ? `+${change}` : `${change}`
+}
+
+function RatingChangePreviewInfo() {
+  const game = useContext(GameContext)
+  if (!game) {
+    throw new Error("RatingChangePreviewInfo must be used within a GameContext")
+  }
+
+  const preview = game.ratingChangePreview
+  if (preview === null || game.matchData.gameOverStatus != 0) {
+    return <></>
+  }
+
+  return (
+    <div className='ratingChangePreview'>
+      Win {formatRatingChange(preview.win)} / Draw {formatRatingChange(preview.draw)} / Loss {formatRatingChange(preview.loss)}
+    </div>
+  )
+}
+
 function updateActiveState(stateHistoryIndex: number, game: gameContext) {
   console.log("updateActiveState Called")
   console.log(stateHistoryIndex)
@@ -172,18 +256,39 @@ function GameControls() {
   if (!game) {
     throw new Error("GameControls must be used within a GameContext")
   }
+
+  if (game.matchData.gameOverStatus != 0) {
+    return (
+      <div className='gameControlsContainer'>
+        <div className='spacer' />
+        <div className='gameControlsButton'>
+          <Repeat onClick={() => sendRematchEvent(game.webSocket)} color='#000000' />
+        </div>
+        <div className='spacer' />
+      </div>
+    )
+  }
   
   return (
     <div className='gameControlsContainer'>
       <div className='spacer' />
       <div className='gameControlsButton'>
-        <CornerUpLeft color='#000000' />
+        <CornerUpLeft onClick={() => sendTakebackEvent(game.webSocket)} color='#000000' />
       </div>
       <div className='gameControlsButton'>
         <Handshake onClick={() => sendDrawEvent(game.webSocket)} color='#000000' />
       </div>
       <div className='gameControlsButton'>
-        <Flag onClick={() => sendResignEvent(game.webSocket)} color='#000000' />
+        {
+          // Games can be aborted until both players have moved
+          game.matchData.stateHistory.length < 3 ?
+            <X onClick={() => sendAbortEvent(game.webSocket)} color='#000000' />
+            :
+            <Flag onClick={() => sendResignEvent(game.webSocket)} color='#000000' />
+        }
+      </div>
+      <div className='gameControlsButton'>
+        <AlarmClockPlus onClick={() => sendExtraTimeEvent(game.webSocket)} color='#000000' />
       </div>
       <div className='spacer' />
     </div>
@@ -300,8 +405,19 @@ function EventTypeDialog() {
   console.log(`Threefold Repetition? ${game.threefoldRepetition}`)
 
   if (game.matchData.gameOverStatus != 0) {
+    if (game.opponentEventType != OpponentEventType.Rematch) {
+      return (
+        <></>
+      )
+    }
     return (
-      <></>
+      <div className="eventTypeDialog">
+        <span>Rematch</span>
+        <div>
+          <button onClick={() => acceptEvent(game, OpponentEventType.Rematch)}>Accept</button>
+          <button onClick={() => declineEvent(game)}>Decline</button>
+        </div>
+      </div>
     )
   }
 
@@ -317,6 +433,18 @@ function EventTypeDialog() {
     )
   }
 
+  if (game.fiftyMoveRule) {
+    return (
+      <div className="eventTypeDialog">
+        <span>Fifty Move Rule</span>
+        <div>
+          <button onClick={() => acceptEvent(game, OpponentEventType.FiftyMoveRule)}>Accept</button>
+          <button onClick={() => declineEvent(game, false, true)}>Decline</button>
+        </div>
+      </div>
+    )
+  }
+
   const isOpponentConnected = game.playerColour == PieceColour.White ? game.isBlackConnected : game.isWhiteConnected
 
   if (!isOpponentConnected && timeoutCountdown != null && timeoutCountdown <= 10_000) {
@@ -527,6 +655,7 @@ export function GameInfoTile() {
       <CountdownTimer className="playerTimeTop" paused={topPaused} countdownTimerMilliseconds={topTime}/>
       <div className='gameInfo'>
         <EventTypeDialog />
+        <RatingChangePreviewInfo />
         <PlayerInfo connected={game.isWhiteConnected} username={topUsername}/>
         <MoveHistoryControls />
         <Moves />
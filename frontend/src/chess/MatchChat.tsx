@@ -0,0 +1,65 @@
+import React, { useContext, useEffect, useRef, useState } from "react";
+import { GameContext } from "./GameContext";
+
+const maxChatMessageLength = 140
+
+function sendUserMessage(websocket: React.RefObject<WebSocket | null>, messageContent: string) {
+  if (!websocket) {
+    console.error("Websocket is null")
+    return
+  }
+
+  websocket.current?.send(JSON.stringify({
+    "messageType": "userMessage",
+    "body": {
+      "messageContent": messageContent,
+    }
+  }))
+}
+
+export function MatchChat() {
+  const game = useContext(GameContext)
+  if (!game) {
+    throw new Error("MatchChat must be used within a GameContext")
+  }
+
+  const [draft, setDraft] = useState("")
+  const messagesEndRef = useRef<HTMLDivElement | null>(null)
+
+  useEffect(() => {
+    messagesEndRef.current?.scrollIntoView({ behavior: "auto", block: "nearest" })
+  }, [game.chatMessages])
+
+  return (
+    <div className="matchChat">
+      <div className="matchChatMessages">
+        {game.chatMessages.map((message, idx) => {
+          return (
+            <div key={idx} className="matchChatMessage">
+              <span className="matchChatSender">{message.sender}</span>
+              <span>{message.messageContent}</span>
+            </div>
+          )
+        })}
+        <div ref={messagesEndRef} />
+      </div>
+      <form onSubmit={(event) => {
+        event.preventDefault()
+        if (draft.trim() == "") {
+          return
+        }
+        sendUserMessage(game.webSocket, draft)
+        setDraft("")
+      }}>
+        <input
+          className="matchChatInput"
+          type="text"
+          placeholder="Chat"
+          maxLength={maxChatMessageLength}
+          value={draft}
+          onChange={(event) => setDraft(event.target.value)}
+        />
+      </form>
+    </div>
+  )
+}
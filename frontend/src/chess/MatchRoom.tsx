@@ -3,6 +3,7 @@ import { useParams, useLocation } from "react-router-dom"
 import { GameWrapper } from "./GameContext";
 import { ChessBoard } from "./ChessBoard";
 import { GameInfoTile } from "./GameInfoTile";
+import { MatchChat } from "./MatchChat";
 
 export function MatchRoom() {
   const { matchid } = useParams()
@@ -18,8 +19,10 @@ export function MatchRoom() {
   }, [])
 
   return (
-    <GameWrapper matchID={matchid as string} timeFormatInMilliseconds={parsedTimeFormatInMilliseconds}>
+    // Keyed so a rematch starts from a fresh game state
+    <GameWrapper key={matchid} matchID={matchid as string} timeFormatInMilliseconds={parsedTimeFormatInMilliseconds}>
       <div className='chessMatch'>
+        <MatchChat />
         <ChessBoard resizeable={true} defaultWidth={800} enableClicking={true}/>
         <GameInfoTile />
       </div>
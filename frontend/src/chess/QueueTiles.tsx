@@ -1,6 +1,8 @@
-import React, { useEffect, useRef, useState } from "react"
+import React, { useContext, useEffect, useRef, useState } from "react"
 import { LoaderCircle } from "lucide-react"
 import { NavigateFunction, useNavigate } from "react-router-dom"
+import { ColourPreference } from "../Challenges"
+import { AuthContext } from "../auth/AuthContext"
 
 interface QueueObject {
   timeFormatInMilliseconds: number,
@@ -17,6 +19,33 @@ interface QueueState {
     eventSource: React.RefObject<EventSource | null>,
     matchFoundState: React.RefObject<MatchFoundState | null>,
     navigate: NavigateFunction,
+    searchOptions: SearchOptions,
+    setSearchRange: React.Dispatch<React.SetStateAction<SearchRange | null>>,
+    queueStatuses: QueueStatus[],
+}
+
+interface SearchOptions {
+  maxRatingGap: number // 0 for no limit
+  colour: ColourPreference
+  rated: boolean
+}
+
+interface SearchRange {
+  minRating: number
+  maxRating: number
+  waitedMs: number
+  estimatedWaitMs: number | null
+  waitingPlayers: number
+}
+
+interface QueueStatus {
+  timeFormatInMilliseconds: number
+  incrementInMilliseconds: number
+  rated: boolean
+  waitingPlayers: number
+  medianWaitMs: number | null // null until the queue has paired players
+  minRating: number | null // null when no one is waiting
+  maxRating: number | null
 }
 
 interface MatchFoundState {
@@ -52,7 +81,7 @@ addQueueObject(10, 0)
 addQueueObject(10, 5)
 addQueueObject(15, 10)
 
-async function tryJoinQueue(queueName: string, matchFoundState: React.RefObject<MatchFoundState | null>, eventSource: React.RefObject<EventSource | null>, navigate: NavigateFunction) {
+async function tryJoinQueue(queueName: string, matchFoundState: React.RefObject<MatchFoundState | null>, eventSource: React.RefObject<EventSource | null>, navigate: NavigateFunction, searchOptions: SearchOptions, setSearchRange: React.Dispatch<React.SetStateAction<SearchRange | null>>, onDropped: () => void) {
   const queueObject = queueObjectsMap.get(queueName)
   if (queueObject === undefined) {
     throw new Error("Queue object not found")
@@ -66,6 +95,9 @@ async function tryJoinQueue(queueName: string, matchFoundState: React.RefObject<
       "timeFormatInMilliseconds": queueObject.timeFormatInMilliseconds,
       "incrementInMilliseconds": queueObject.incrementInMilliseconds,
       "action": "join",
+      "maxRatingGap": searchOptions.maxRatingGap,
+      "colour": searchOptions.colour,
+      "rated": searchOptions.rated,
     })
   })
 
@@ -78,6 +110,11 @@ async function tryJoinQueue(queueName: string, matchFoundState: React.RefObject<
     withCredentials: true,
   })
 
+  // The search range widens while waiting
+  eventSource.current.addEventListener("searchRange", (event) => {
+    setSearchRange(JSON.parse(event.data))
+  })
+
   eventSource.current.onmessage = (event) => {
     console.log(`message: ${event.data}`)
 
@@ -97,13 +134,34 @@ async function tryJoinQueue(queueName: string, matchFoundState: React.RefObject<
     navigate("matchroom/" + matchFoundState.current.matchRoom, { state: matchFoundState.current })
   }
 
+  // The server drops players from the queue once they stop listening
   eventSource.current.onerror = (event) => {
     console.error(`SSE Error: ${event}`)
     console.error(event)
     eventSource.current?.close()
+    onDropped()
   }
 }
 
+async function fetchQueueStatuses() {
+  try {
+    const response = await fetch(import.meta.env.VITE_API_QUEUES_URL, {
+      signal: AbortSignal.timeout(5000),
+      method: "GET",
+    })
+    if (response.ok) {
+      const statuses: QueueStatus[] = await response.json()
+      return statuses
+    }
+  } catch (e) {
+    console.error(e)
+  }
+  return undefined
+}
+
+// Pool activity is polled, players waiting in a queue get their own updates over SSE
+const queueStatusPollMs = 5000
+
 async function tryLeaveQueue(queueName: string, eventSource: React.RefObject<EventSource | null>) {
   const queueObject = queueObjectsMap.get(queueName)
   if (queueObject === undefined) {
@@ -137,11 +195,19 @@ async function toggleQueue({
   eventSource,
   matchFoundState,
   navigate,
+  searchOptions,
+  setSearchRange,
 }: QueueState, newQueueName: string) {
   if (waiting) {
     return
   }
   
+  const onDropped = () => {
+    setInQueue(false)
+    setQueueName("")
+    setSearchRange(null)
+  }
+
   setWaiting(true)
   let clickAction
   if (!inQueue) {
@@ -158,16 +224,18 @@ async function toggleQueue({
       await tryLeaveQueue(queueName, eventSource)
       setInQueue(false)
       setQueueName("")
+      setSearchRange(null)
       break
   
     case ClickAction.changeQueue:
       await tryLeaveQueue(queueName, eventSource)
-      await tryJoinQueue(newQueueName, matchFoundState, eventSource, navigate)
+      setSearchRange(null)
+      await tryJoinQueue(newQueueName, matchFoundState, eventSource, navigate, searchOptions, setSearchRange, onDropped)
       setQueueName(newQueueName)
       break
         
     case ClickAction.joinQueue:
-      await tryJoinQueue(newQueueName, matchFoundState, eventSource, navigate)
+      await tryJoinQueue(newQueueName, matchFoundState, eventSource, navigate, searchOptions, setSearchRange, onDropped)
       setInQueue(true)
       setQueueName(newQueueName)
     }
@@ -181,12 +249,23 @@ async function toggleQueue({
 
 function QueueButton({ queueState, nameOfQueue, queueType }: { queueState: QueueState, nameOfQueue: string, queueType: string }) {
   const loading = nameOfQueue == queueState.queueName
+  const queueObject = queueObjectsMap.get(nameOfQueue)
+  const status = queueState.queueStatuses.find((s) =>
+    s.timeFormatInMilliseconds === queueObject?.timeFormatInMilliseconds &&
+    s.incrementInMilliseconds === queueObject?.incrementInMilliseconds &&
+    s.rated === queueState.searchOptions.rated
+  )
+  const waitingPlayers = status?.waitingPlayers || 0
+  const title = status?.medianWaitMs != null ? `Median wait ${Math.ceil(status.medianWaitMs / 1000)}s` : undefined
   return (
     <>
       {loading ?
         <button onClick={() => toggleQueue(queueState, nameOfQueue)} className="queueButton"><LoaderCircle className="loaderSpin"/></button>
         :
-        <button onClick={() => toggleQueue(queueState, nameOfQueue)} className="queueButton"><span>{nameOfQueue}<br />{queueType}</span></button>
+        <button onClick={() => toggleQueue(queueState, nameOfQueue)} className={waitingPlayers > 0 ? "queueButton activeQueue" : "queueButton"} title={title}>
+          <span>{nameOfQueue}<br />{queueType}</span>
+          {waitingPlayers > 0 && <span className="queueWaiting">{waitingPlayers} waiting</span>}
+        </button>
       }
     </>
   )
@@ -201,6 +280,10 @@ export function QueueTiles() {
   const eventSource = useRef<EventSource>(null)
   const matchFoundState = useRef<MatchFoundState>(null)
   const navigate = useNavigate()
+  const auth = useContext(AuthContext)
+  const [searchOptions, setSearchOptions] = useState<SearchOptions>({ maxRatingGap: 0, colour: "random", rated: true })
+  const [searchRange, setSearchRange] = useState<SearchRange | null>(null)
+  const [queueStatuses, setQueueStatuses] = useState<QueueStatus[]>([])
 
   const queueState: QueueState = {
     waiting,
@@ -212,12 +295,33 @@ export function QueueTiles() {
     eventSource,
     matchFoundState,
     navigate,
+    // Logged out players can only play casual games
+    searchOptions: { ...searchOptions, rated: searchOptions.rated && auth.isLoggedIn },
+    setSearchRange,
+    queueStatuses,
   }
   
   useEffect(() => {
     queueNameRef.current = queueName
   }, [queueName])
 
+  useEffect(() => {
+    let ignore = false
+    const poll = async () => {
+      const statuses = await fetchQueueStatuses()
+      if (!ignore && statuses !== undefined) {
+        setQueueStatuses(statuses)
+      }
+    }
+
+    poll()
+    const interval = setInterval(poll, queueStatusPollMs)
+    return () => {
+      ignore = true
+      clearInterval(interval)
+    }
+  }, [])
+
   useEffect(() => {
     if (matchFoundState.current != null) {
       console.log(matchFoundState.current)
@@ -254,6 +358,36 @@ export function QueueTiles() {
         <QueueButton queueState={queueState} nameOfQueue="10 + 5" queueType="Rapid"/>
         <QueueButton queueState={queueState} nameOfQueue="15 + 10" queueType="Rapid"/>
       </div>
+      <div className="queueSearchOptions">
+        <label>
+          Max rating gap{" "}
+          <select value={searchOptions.maxRatingGap} disabled={inQueue} onChange={(e) => setSearchOptions({ ...searchOptions, maxRatingGap: Number(e.target.value) })}>
+            <option value={0}>Any</option>
+            <option value={100}>100</option>
+            <option value={200}>200</option>
+            <option value={400}>400</option>
+          </select>
+        </label>
+        <label>
+          Colour{" "}
+          <select value={searchOptions.colour} disabled={inQueue} onChange={(e) => setSearchOptions({ ...searchOptions, colour: e.target.value as ColourPreference })}>
+            <option value="random">Random</option>
+            <option value="white">White</option>
+            <option value="black">Black</option>
+          </select>
+        </label>
+        <label>
+          <input type="checkbox" checked={searchOptions.rated && auth.isLoggedIn} disabled={inQueue || !auth.isLoggedIn} onChange={(e) => setSearchOptions({ ...searchOptions, rated: e.target.checked })}/>
+          Rated
+        </label>
+        {inQueue && searchRange !== null &&
+          <span className="queueSearchRange">
+            Searching {searchRange.minRating} - {searchRange.maxRating}, waited {Math.floor(searchRange.waitedMs / 1000)}s
+            {searchRange.estimatedWaitMs !== null && `, about ${Math.ceil(searchRange.estimatedWaitMs / 1000)}s left`}
+            {`, ${searchRange.waitingPlayers} in queue`}
+          </span>
+        }
+      </div>
     </>
   )
 }
\ No newline at end of file